@@ -0,0 +1,90 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// adminGzip enables gzip compression of the admin API responses, -admin-gzip
+var adminGzip = true
+
+// gzipExcludedPaths are admin API paths whose responses are never compressed
+// by gzipAdminAPI: the body endpoints choose their own Content-Encoding and
+// the live stream must reach clients event by event
+var gzipExcludedPaths = []string{"/api/requests/body/", "/api/stream"}
+
+// gzipResponseWriter compresses a response once its headers show it is
+// textual and not already encoded
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // nil while the response is sent as is
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	header := g.Header()
+	header.Add("Vary", "Accept-Encoding")
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" && isTextMediaType(mediaType) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been compressed so far
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close finishes the compressed stream
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// gzipAdminAPI compresses the JSON and text responses of the admin API for
+// clients sending Accept-Encoding: gzip, unless -admin-gzip=false
+func gzipAdminAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminGzip || !strings.HasPrefix(r.URL.Path, "/api/") || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, excluded := range gzipExcludedPaths {
+			if strings.HasPrefix(r.URL.Path, excluded) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: w}
+		defer writer.close()
+		next.ServeHTTP(writer, r)
+	})
+}
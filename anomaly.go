@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	anomalyMinSamples   = 10    // Samples needed per path template before flagging
+	anomalyMaxTemplates = 10000 // Bound on the number of path templates tracked
+)
+
+// anomalySigma is how many standard deviations above the mean of its path
+// template a response time or size must be to be flagged (0 disables)
+var anomalySigma = 3.0
+
+var (
+	uuidSegmentPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegmentPattern  = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	numSegmentPattern  = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// pathTemplate collapses the variable segments (numbers, UUIDs, long hex
+// IDs) of a request URL's path into {id}, e.g. /users/42/orders -> /users/{id}/orders
+func pathTemplate(rawURL string) string {
+	path := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		path = parsed.Path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if numSegmentPattern.MatchString(segment) || uuidSegmentPattern.MatchString(segment) || hexSegmentPattern.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// runningStats tracks the mean and variance of a series (Welford's algorithm)
+type runningStats struct {
+	count int64
+	mean  float64
+	m2    float64
+}
+
+func (s *runningStats) add(x float64) {
+	s.count++
+	delta := x - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (x - s.mean)
+}
+
+func (s *runningStats) stddev() float64 {
+	if s.count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.count-1))
+}
+
+// isOutlier reports whether x lies more than anomalySigma standard deviations above the mean
+func (s *runningStats) isOutlier(x float64) bool {
+	if s.count < anomalyMinSamples {
+		return false
+	}
+	stddev := s.stddev()
+	return stddev > 0 && x > s.mean+anomalySigma*stddev
+}
+
+// templateStats holds the response time and size statistics of one path template
+type templateStats struct {
+	durationMs runningStats
+	size       runningStats
+}
+
+var (
+	anomalyMu    sync.Mutex
+	anomalyStats = make(map[string]*templateStats)
+)
+
+// detectAnomaly compares an entry's response time and size with earlier
+// requests to the same method and path template, then folds it into the
+// statistics. It returns whether the entry is an outlier and why.
+func detectAnomaly(logEntry RequestLog, duration time.Duration) (bool, string) {
+	if anomalySigma <= 0 {
+		return false, ""
+	}
+
+	key := logEntry.Method + " " + pathTemplate(logEntry.URL)
+	durationMs := float64(duration) / float64(time.Millisecond)
+	size := float64(logEntry.ResponseBodySize)
+
+	anomalyMu.Lock()
+	defer anomalyMu.Unlock()
+
+	stats, ok := anomalyStats[key]
+	if !ok {
+		if len(anomalyStats) >= anomalyMaxTemplates {
+			return false, ""
+		}
+		stats = &templateStats{}
+		anomalyStats[key] = stats
+	}
+
+	var reasons []string
+	if stats.durationMs.isOutlier(durationMs) {
+		reasons = append(reasons, fmt.Sprintf("response time %.0fms vs mean %.0fms (stddev %.0fms)", durationMs, stats.durationMs.mean, stats.durationMs.stddev()))
+	}
+	if stats.size.isOutlier(size) {
+		reasons = append(reasons, fmt.Sprintf("response size %.0f bytes vs mean %.0f bytes (stddev %.0f)", size, stats.size.mean, stats.size.stddev()))
+	}
+
+	stats.durationMs.add(durationMs)
+	stats.size.add(size)
+
+	return len(reasons) > 0, strings.Join(reasons, "; ")
+}
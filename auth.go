@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// authTrustedHeader names a header set by a fronting SSO proxy that carries the
+// authenticated user; empty disables header based authentication
+var authTrustedHeader string
+
+// authTrustedProxies lists the networks allowed to assert authTrustedHeader
+var authTrustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a comma-separated list of IPs and CIDR ranges
+func parseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy reports whether the request comes directly from a trusted proxy
+func isTrustedProxy(r *http.Request) bool {
+	ip := net.ParseIP(remoteIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range authTrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedHeaderUser returns the user asserted by a trusted SSO proxy, if any
+func trustedHeaderUser(r *http.Request) (string, bool) {
+	if authTrustedHeader == "" {
+		return "", false
+	}
+	user := strings.TrimSpace(r.Header.Get(authTrustedHeader))
+	if user == "" || !isTrustedProxy(r) {
+		return "", false
+	}
+	return user, true
+}
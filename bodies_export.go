@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// commonExtensions maps frequent content types to their preferred file extension
+var commonExtensions = map[string]string{
+	"application/json":         "json",
+	"application/xml":          "xml",
+	"text/xml":                 "xml",
+	"text/html":                "html",
+	"text/plain":               "txt",
+	"text/css":                 "css",
+	"text/csv":                 "csv",
+	"application/javascript":   "js",
+	"text/javascript":          "js",
+	"application/pdf":          "pdf",
+	"application/zip":          "zip",
+	"application/x-protobuf":   "pb",
+	"application/octet-stream": "bin",
+	"image/png":                "png",
+	"image/jpeg":               "jpg",
+	"image/gif":                "gif",
+	"image/webp":               "webp",
+	"image/svg+xml":            "svg",
+}
+
+// extensionForContentType infers a file extension (without the dot) from a content type
+func extensionForContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "bin"
+	}
+	if ext, ok := commonExtensions[mediaType]; ok {
+		return ext
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return "json"
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return "xml"
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return strings.TrimPrefix(exts[0], ".")
+	}
+	return "bin"
+}
+
+// exportBodiesZipHandler handles GET /api/export/bodies.zip, streaming the stored
+// response bodies of every request matching the list filters as a ZIP archive
+func exportBodiesZipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	where, args := buildRequestFilter(r.URL.Query())
+	rows, err := db.Query("SELECT id, timestamp, response_headers, response_body FROM requests WHERE 1=1"+where+" ORDER BY id", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
+		log.Printf("Error fetching requests: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"dgateway-bodies.zip\"")
+
+	// Entries are written one row at a time so memory use stays bounded
+	zipWriter := zip.NewWriter(w)
+	for rows.Next() {
+		var id int
+		var timestamp time.Time
+		var respHeaders string
+		var respBody []byte
+		if err := rows.Scan(&id, &timestamp, &respHeaders, &respBody); err != nil {
+			log.Printf("Error scanning request: %v", err)
+			continue
+		}
+
+		name := fmt.Sprintf("%d.%s", id, extensionForContentType(getContentTypeFromHeaders(respHeaders)))
+		entry, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: timestamp,
+		})
+		if err != nil {
+			log.Printf("Error creating zip entry %s: %v", name, err)
+			return
+		}
+		if _, err := entry.Write(respBody); err != nil {
+			log.Printf("Error writing zip entry %s: %v", name, err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating requests: %v", err)
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		log.Printf("Error finalizing zip archive: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Limits on the bodies the gateway buffers, set by -max-request-body and
+// -max-response-body; 0 is unlimited
+var (
+	maxRequestBody  int64
+	maxResponseBody int64
+)
+
+// errResponseTooLarge is returned by ModifyResponse for upstream responses
+// over -max-response-body
+var errResponseTooLarge = errors.New("upstream response body exceeds -max-response-body")
+
+// readRequestBody reads the body of a proxied request, failing with an
+// *http.MaxBytesError when it is longer than -max-request-body. A
+// Content-Length over the limit is rejected without reading the body.
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if maxRequestBody <= 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+	if r.ContentLength > maxRequestBody {
+		return nil, &http.MaxBytesError{Limit: maxRequestBody}
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+}
+
+// readResponseBody reads a buffered upstream response body, failing with
+// errResponseTooLarge when it is longer than -max-response-body
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	if maxResponseBody <= 0 {
+		return ioutil.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > maxResponseBody {
+		return nil, fmt.Errorf("%w: Content-Length %d over %d", errResponseTooLarge, resp.ContentLength, maxResponseBody)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody+1))
+	if err == nil && int64(len(body)) > maxResponseBody {
+		return nil, fmt.Errorf("%w: more than %d bytes", errResponseTooLarge, maxResponseBody)
+	}
+	return body, err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	bodySearchMaxMatches = 100 // Maximum number of matches returned
+	bodySearchContext    = 40  // Bytes of context on each side of a match
+)
+
+// bodyMatch is one match of a body search. Offsets are in bytes of the body
+// served by the body endpoints, i.e. after decompression.
+type bodyMatch struct {
+	Offset        int    `json:"offset"`
+	Length        int    `json:"length"`
+	Snippet       string `json:"snippet"`        // The match with up to bodySearchContext bytes around it
+	SnippetOffset int    `json:"snippet_offset"` // Offset of the snippet in the body
+}
+
+// findBodyMatches returns the byte ranges of up to limit non-overlapping
+// matches of a literal query or a regular expression
+func findBodyMatches(body []byte, query string, pattern *regexp.Regexp, limit int) [][]int {
+	if pattern != nil {
+		return pattern.FindAllIndex(body, limit)
+	}
+	var matches [][]int
+	needle := []byte(query)
+	for start := 0; len(matches) < limit; {
+		i := bytes.Index(body[start:], needle)
+		if i < 0 {
+			break
+		}
+		matches = append(matches, []int{start + i, start + i + len(needle)})
+		start += i + len(needle)
+	}
+	return matches
+}
+
+// matchSnippet cuts the context of a match out of the body, keeping multi-byte
+// UTF-8 characters at its edges whole
+func matchSnippet(body []byte, start, end int) ([]byte, int) {
+	from := start - bodySearchContext
+	if from < 0 {
+		from = 0
+	}
+	for from > 0 && from < start && !utf8.RuneStart(body[from]) {
+		from++
+	}
+	to := end + bodySearchContext
+	if to > len(body) {
+		to = len(body)
+	}
+	for to < len(body) && to > end && !utf8.RuneStart(body[to]) {
+		to--
+	}
+	return body[from:to], from
+}
+
+// loadSearchBody loads a stored request or response body, decoding bodies
+// stored still compressed as the body endpoints do
+func loadSearchBody(id int, isResponse bool) ([]byte, error) {
+	var body []byte
+	var headers string
+	var wireSize int
+	query := "SELECT request_body, request_headers, -1 FROM requests WHERE id = ?"
+	if isResponse {
+		query = "SELECT response_body, response_headers, COALESCE(response_wire_size, 0) FROM requests WHERE id = ?"
+	}
+	if err := db.QueryRow(query, id).Scan(&body, &headers, &wireSize); err != nil {
+		return nil, err
+	}
+	if !isResponse || len(body) == wireSize {
+		if _, decoded := storedEncoding(body, getHeaderFromJSON(headers, "Content-Encoding")); decoded != nil {
+			body = decoded
+		}
+	}
+	return body, nil
+}
+
+// searchBodyHandler handles GET /api/requests/{id}/search?q=...&in=request|response,
+// returning the byte offsets of the matches of q in a stored body (the
+// response body by default) with a snippet of context around each, so large
+// bodies can be navigated without downloading them. regex=1 treats q as a
+// regular expression. At most bodySearchMaxMatches matches are returned;
+// more_matches tells whether there were others.
+func searchBodyHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+	in := r.URL.Query().Get("in")
+	switch in {
+	case "":
+		in = "response"
+	case "request", "response":
+	default:
+		http.Error(w, "in must be request or response", http.StatusBadRequest)
+		return
+	}
+	var pattern *regexp.Regexp
+	if r.URL.Query().Get("regex") == "1" {
+		var err error
+		if pattern, err = regexp.Compile(query); err != nil {
+			http.Error(w, "Invalid regular expression: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := loadSearchBody(id, in == "response")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Request not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch body", http.StatusInternalServerError)
+		log.Printf("Error fetching %s body for ID %d: %v", in, id, err)
+		return
+	}
+
+	// Look for one match more than returned to tell whether there are others
+	found := findBodyMatches(body, query, pattern, bodySearchMaxMatches+1)
+	result := struct {
+		ID          int         `json:"id"`
+		In          string      `json:"in"`
+		Query       string      `json:"query"`
+		Regex       bool        `json:"regex"`
+		BodySize    int         `json:"body_size"`
+		Matches     []bodyMatch `json:"matches"`
+		MoreMatches bool        `json:"more_matches"`
+	}{ID: id, In: in, Query: query, Regex: pattern != nil, BodySize: len(body), Matches: []bodyMatch{}}
+	if len(found) > bodySearchMaxMatches {
+		found = found[:bodySearchMaxMatches]
+		result.MoreMatches = true
+	}
+	for _, match := range found {
+		snippet, snippetOffset := matchSnippet(body, match[0], match[1])
+		result.Matches = append(result.Matches, bodyMatch{
+			Offset:        match[0],
+			Length:        match[1] - match[0],
+			Snippet:       string(snippet),
+			SnippetOffset: snippetOffset,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// gzipMinBodySize is the smallest served body worth gzipping on the fly
+const gzipMinBodySize = 1024
+
+// isInlineMediaType reports whether browsers can display a media type
+// themselves, so its bodies are served inline rather than downloaded
+func isInlineMediaType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/pdf":
+		return true
+	}
+	return false
+}
+
+// bodyFilename names a downloaded body after the filename of its recorded
+// Content-Disposition, else the last segment of the request path when it has
+// an extension, e.g. report.pdf, and otherwise after the request, e.g.
+// 42-response.json
+func bodyFilename(id int, reqURL, headersJSON string, isResponse bool) string {
+	if _, params, err := mime.ParseMediaType(getHeaderFromJSON(headersJSON, "Content-Disposition")); err == nil && params["filename"] != "" {
+		return path.Base(params["filename"])
+	}
+	if parsed, err := url.Parse(reqURL); err == nil {
+		if base := path.Base(parsed.Path); path.Ext(base) != "" {
+			return base
+		}
+	}
+	kind := "request"
+	if isResponse {
+		kind = "response"
+	}
+	return fmt.Sprintf("%d-%s.%s", id, kind, extensionForContentType(getContentTypeFromHeaders(headersJSON)))
+}
+
+// setBodyHeaders sets the Content-Type of a served body and its
+// Content-Disposition: inline for types browsers display, attachment with a
+// filename for the others or when ?download=1 asks for a download. Inline
+// bodies are sandboxed, so recorded pages cannot run scripts on the admin
+// origin.
+func setBodyHeaders(w http.ResponseWriter, r *http.Request, contentType, filename string) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if r.URL.Query().Get("download") != "1" && isInlineMediaType(mediaType) {
+		w.Header().Set("Content-Disposition", "inline")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// declareCharset adds charset=utf-8 to a textual content type that names no
+// charset when the body is valid UTF-8, so browsers do not guess another one.
+// Declared charsets are kept as recorded.
+func declareCharset(contentType string, body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || parseCharset(contentType) != "" || !isTextMediaType(mediaType) || !utf8.Valid(body) {
+		return contentType
+	}
+	return withCharset(contentType, "utf-8")
+}
+
+// isTextMediaType reports whether a media type carries text that a charset applies to
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/json" || mediaType == "application/xml" || mediaType == "application/javascript"
+}
+
+// storedEncoding reports the content coding a stored body is still in, given
+// the Content-Encoding recorded with it, along with the decoded body when it
+// can be decoded. Bodies are decoded at capture time except partial
+// responses and encodings that were not supported; bodies that do not decode
+// now either were decoded then or cannot be, and count as not encoded.
+func storedEncoding(body []byte, recorded string) (string, []byte) {
+	if len(contentCodings(recorded)) == 0 {
+		return "", nil
+	}
+	if !isSupportedEncoding(recorded) {
+		return recorded, nil
+	}
+	decoded, err := decompressBody(body, recorded)
+	if err != nil {
+		return "", nil
+	}
+	return recorded, decoded
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, listing
+// it or * without q=0
+func acceptsGzip(header string) bool {
+	star := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		accepted := true
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if weight, err := strconv.ParseFloat(params[len("q="):], 64); err == nil && weight == 0 {
+				accepted = false
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			return accepted
+		case "*":
+			star = accepted
+		}
+	}
+	return star
+}
+
+// writeBody writes a served body with a Content-Encoding matching the bytes
+// sent, never the one recorded with them: bytes still in a content coding
+// are labelled with it, and decoded bodies are gzipped on the fly for
+// clients accepting gzip unless ?raw=1 asks for the stored bytes
+func writeBody(w http.ResponseWriter, r *http.Request, body []byte, encoding string) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	switch {
+	case encoding != "":
+		w.Header().Set("Content-Encoding", encoding)
+	case r.URL.Query().Get("raw") != "1" && len(body) >= gzipMinBodySize && acceptsGzip(r.Header.Get("Accept-Encoding")):
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(body)
+		writer.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		body = compressed.Bytes()
+	}
+	w.Write(body)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestResponseBodyContentEncoding checks that the Content-Encoding served with
+// a stored response body always matches the bytes served, whether the body
+// recorded with Content-Encoding: gzip was stored decompressed or still
+// compressed, and whether or not the client accepts gzip
+func TestResponseBodyContentEncoding(t *testing.T) {
+	setupTestDB(t)
+
+	plain := []byte(strings.Repeat(`{"message":"hello"}`, 200)) // Over gzipMinBodySize
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(plain)
+	writer.Close()
+
+	for _, row := range []struct {
+		name     string
+		body     []byte
+		wireSize int
+	}{
+		{"stored decompressed", plain, compressed.Len()},
+		{"stored compressed", compressed.Bytes(), compressed.Len()},
+		// Decompressed although as long as the body received
+		{"stored decompressed, wire size equal", plain, len(plain)},
+	} {
+		entries := store.LogRequests([]RequestLog{{
+			Method:           "GET",
+			URL:              "/data",
+			StatusCode:       http.StatusOK,
+			ResponseHeaders:  HeadersToJSON(http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}}),
+			ResponseBody:     row.body,
+			ResponseWireSize: row.wireSize,
+		}})
+		if len(entries) != 1 {
+			t.Fatalf("%s: row not stored", row.name)
+		}
+		id := entries[0].ID
+
+		for _, acceptEncoding := range []string{"", "gzip"} {
+			t.Run(fmt.Sprintf("%s, Accept-Encoding %q", row.name, acceptEncoding), func(t *testing.T) {
+				req := httptest.NewRequest("GET", fmt.Sprintf("/api/requests/body/response/%d", id), nil)
+				if acceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", acceptEncoding)
+				}
+				rec := httptest.NewRecorder()
+				getResponseBodyHandler(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body)
+				}
+
+				served := rec.Body.Bytes()
+				switch encoding := rec.Header().Get("Content-Encoding"); encoding {
+				case "":
+					if acceptEncoding == "gzip" {
+						t.Errorf("body not gzipped for a client accepting gzip")
+					}
+				case "gzip":
+					if acceptEncoding == "" {
+						t.Errorf("gzipped body served to a client not accepting gzip")
+					}
+					reader, err := gzip.NewReader(bytes.NewReader(served))
+					if err != nil {
+						t.Fatalf("served Content-Encoding gzip with bytes that are not gzip: %v", err)
+					}
+					if served, err = ioutil.ReadAll(reader); err != nil {
+						t.Fatalf("decompressing the served body: %v", err)
+					}
+				default:
+					t.Fatalf("unexpected Content-Encoding %q", encoding)
+				}
+				if !bytes.Equal(served, plain) {
+					t.Errorf("served body decodes to %d bytes, want the %d bytes of the original body", len(served), len(plain))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// parseCharset extracts the lowercased charset parameter from a Content-Type value
+func parseCharset(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(params["charset"])
+}
+
+// isUTF8Charset reports whether the charset is UTF-8 or plain ASCII, which need no transcoding
+func isUTF8Charset(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return true
+	}
+	return false
+}
+
+// transcodeToUTF8 converts data from the given charset to UTF-8
+func transcodeToUTF8(data []byte, charset string) ([]byte, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %s: %v", charset, err)
+	}
+	return enc.NewDecoder().Bytes(data)
+}
+
+// withCharset returns the content type with its charset parameter replaced
+func withCharset(contentType, charset string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	params["charset"] = charset
+	return mime.FormatMediaType(mediaType, params)
+}
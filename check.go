@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkOptions carries the flag values validated by -check
+type checkOptions struct {
+	Port            int
+	Target          string
+	Targets         string
+	DBPath          string
+	DBDriver        string
+	DBMaxOpenConns  int
+	DBMaxIdleConns  int
+	DBConnLifetime  time.Duration
+	EnableHTTPS     bool
+	MITM            bool
+	CACert          string
+	CAKey           string
+	BodySampleRate  float64
+	TextThreshold   float64
+	TextSampleBytes int
+	StreamCapture   int
+	SessionTTL      time.Duration
+	LoginRateLimit  int
+	LogBuffer       int
+	LogWorkers      int
+	LogBatchSize    int
+	LogFlush        time.Duration
+	MaxBodySize     int
+	MaxRequestBody  int64
+	MaxResponseBody int64
+	UpstreamTimeout time.Duration
+	Retries         int
+	Retention       string
+	RetentionEvery  time.Duration
+	ProtoDescriptor string
+	ProtoMaps       []string
+	Rules           ruleConfig
+	ConfigPath      string
+	Listens         []string
+	IndexJSONFields []string
+	Notifies        []string
+	TrustedHeader   string
+	TrustedProxies  string
+	AuthFile        string
+}
+
+// configCheck collects the outcome of each validation step
+type configCheck struct {
+	failures int
+}
+
+func (c *configCheck) pass(format string, args ...interface{}) {
+	fmt.Printf("OK    "+format+"\n", args...)
+}
+
+func (c *configCheck) fail(format string, args ...interface{}) {
+	c.failures++
+	fmt.Printf("FAIL  "+format+"\n", args...)
+}
+
+// runConfigCheck validates the configuration without starting any server,
+// printing one line per check. It reports whether every check passed.
+func runConfigCheck(opts checkOptions) bool {
+	c := &configCheck{}
+
+	// Rule flags may come from the -config file, -routes included
+	rules := opts.Rules
+	if opts.ConfigPath != "" {
+		loaded, err := loadRuleConfig(opts.Rules, opts.ConfigPath)
+		if err != nil {
+			c.fail("-config: %v", err)
+		} else {
+			c.pass("-config %s loaded", opts.ConfigPath)
+			rules = loaded
+		}
+	}
+
+	if rules.routes != "" {
+		if routes, err := loadRoutes(rules.routes); err != nil {
+			c.fail("-routes: %v", err)
+		} else {
+			for _, route := range routes {
+				c.checkTarget("-routes "+route.Prefix, route.Target)
+			}
+		}
+		if opts.Targets != "" {
+			c.fail("-routes and -targets cannot be combined")
+		}
+	} else if opts.Targets != "" {
+		if pool, err := parseUpstreamPool(opts.Targets); err != nil {
+			c.fail("-targets: %v", err)
+		} else {
+			for _, target := range pool.targets {
+				c.checkTarget("-targets", target.URL.String())
+			}
+		}
+	} else {
+		c.checkTarget("-target", opts.Target)
+	}
+	if rules.headerRules != "" {
+		if _, err := loadHeaderRules(rules.headerRules); err != nil {
+			c.fail("-header-rules: %v", err)
+		}
+	}
+	switch opts.DBDriver {
+	case dbDriverSQLite:
+		c.checkDBPath(opts.DBPath)
+	case dbDriverPostgres:
+		c.checkPostgres(opts.DBPath)
+	default:
+		c.fail("-db-driver %q must be sqlite or postgres", opts.DBDriver)
+	}
+	if opts.MITM {
+		if !opts.EnableHTTPS {
+			c.fail("-mitm requires -enable-https")
+		}
+		c.checkMITMCA(opts.CACert, opts.CAKey)
+	} else if opts.EnableHTTPS {
+		c.checkCertificates()
+	}
+
+	if opts.BodySampleRate < 0 || opts.BodySampleRate > 1 {
+		c.fail("-body-sample-rate %v is outside 0-1", opts.BodySampleRate)
+	}
+	if opts.TextThreshold < 0 || opts.TextThreshold > 1 {
+		c.fail("-text-threshold %v is outside 0-1", opts.TextThreshold)
+	}
+	if opts.TextSampleBytes <= 0 {
+		c.fail("-text-sample-bytes %d must be positive", opts.TextSampleBytes)
+	}
+	if opts.StreamCapture < 0 {
+		c.fail("-stream-capture-bytes %d is negative", opts.StreamCapture)
+	}
+	if opts.Retention != "" {
+		if _, err := parseRetention(opts.Retention); err != nil {
+			c.fail("-retention: %v", err)
+		}
+		if opts.RetentionEvery <= 0 {
+			c.fail("-retention-interval %v must be positive", opts.RetentionEvery)
+		}
+	}
+	if opts.MaxBodySize < 0 {
+		c.fail("-max-body-size %d is negative", opts.MaxBodySize)
+	}
+	if opts.MaxRequestBody < 0 {
+		c.fail("-max-request-body %d is negative", opts.MaxRequestBody)
+	}
+	if opts.MaxResponseBody < 0 {
+		c.fail("-max-response-body %d is negative", opts.MaxResponseBody)
+	}
+	if opts.UpstreamTimeout < 0 {
+		c.fail("-upstream-timeout %v is negative", opts.UpstreamTimeout)
+	}
+	if opts.Retries < 0 {
+		c.fail("-retries %d is negative", opts.Retries)
+	}
+	if opts.SessionTTL <= 0 {
+		c.fail("-session-ttl %v must be positive", opts.SessionTTL)
+	}
+	if opts.DBMaxOpenConns < 0 {
+		c.fail("-db-max-open-conns %d is negative", opts.DBMaxOpenConns)
+	}
+	if opts.DBMaxIdleConns < 0 {
+		c.fail("-db-max-idle-conns %d is negative", opts.DBMaxIdleConns)
+	}
+	if opts.DBConnLifetime < 0 {
+		c.fail("-db-conn-max-lifetime %v is negative", opts.DBConnLifetime)
+	}
+	if opts.LogBuffer < 0 {
+		c.fail("-log-buffer %d is negative", opts.LogBuffer)
+	}
+	if opts.LogWorkers <= 0 {
+		c.fail("-log-workers %d must be positive", opts.LogWorkers)
+	}
+	if opts.LogBatchSize <= 0 {
+		c.fail("-log-batch-size %d must be positive", opts.LogBatchSize)
+	}
+	if opts.LogFlush <= 0 {
+		c.fail("-log-flush-interval %v must be positive", opts.LogFlush)
+	}
+	if opts.LoginRateLimit < 0 {
+		c.fail("-login-rate-limit %d is negative", opts.LoginRateLimit)
+	}
+
+	if opts.ProtoDescriptor != "" {
+		if err := loadProtoDescriptor(opts.ProtoDescriptor); err != nil {
+			c.fail("-proto-descriptor: %v", err)
+		} else {
+			c.pass("-proto-descriptor %s loaded", opts.ProtoDescriptor)
+		}
+	}
+	for _, spec := range opts.ProtoMaps {
+		if _, err := parseProtoMapping(spec); err != nil {
+			c.fail("-proto-map: %v", err)
+		}
+	}
+
+	for _, spec := range rules.recordIfHeaders {
+		if _, err := parseHeaderRecordRule(spec); err != nil {
+			c.fail("-record-if-header: %v", err)
+		}
+	}
+	if _, err := parsePathPatterns(rules.recordInclude); err != nil {
+		c.fail("-record-include: %v", err)
+	}
+	if _, err := parsePathPatterns(rules.recordExclude); err != nil {
+		c.fail("-record-exclude: %v", err)
+	}
+	if _, err := parseContentTypePatterns(rules.captureContentTypes); err != nil {
+		c.fail("-capture-content-types: %v", err)
+	}
+	if _, err := parseContentTypePatterns(rules.skipContentTypes); err != nil {
+		c.fail("-skip-content-types: %v", err)
+	}
+	for _, spec := range rules.mapStatuses {
+		if _, _, err := parseStatusMapping(spec); err != nil {
+			c.fail("-map-status: %v", err)
+		}
+	}
+
+	seenPorts := map[int]bool{opts.Port: true, opts.Port + 1: true}
+	for _, spec := range opts.Listens {
+		listener, err := parseProxyListener(spec)
+		if err != nil {
+			c.fail("-listen: %v", err)
+			continue
+		}
+		if seenPorts[listener.Port] {
+			c.fail("-listen port %d conflicts with another listener or the admin port", listener.Port)
+		}
+		seenPorts[listener.Port] = true
+		c.checkTarget(fmt.Sprintf("-listen %d", listener.Port), listener.Target.String())
+	}
+
+	seenFields := map[string]bool{}
+	for _, spec := range opts.IndexJSONFields {
+		field, err := parseJSONFieldIndex(spec)
+		if err != nil {
+			c.fail("-index-json-field: %v", err)
+			continue
+		}
+		if seenFields[field.Name] {
+			c.fail("-index-json-field: duplicate name %q", field.Name)
+		}
+		seenFields[field.Name] = true
+	}
+
+	if _, err := parseBodyMaskPreset(rules.maskPreset); err != nil {
+		c.fail("-mask-preset: %v", err)
+	}
+	for _, spec := range rules.maskBodies {
+		if _, err := parseBodyMask(spec); err != nil {
+			c.fail("-mask-body: %v", err)
+		}
+	}
+	if _, err := parseMaskHeaders(rules.maskHeaders); err != nil {
+		c.fail("-mask-headers: %v", err)
+	}
+
+	for _, spec := range opts.Notifies {
+		if _, err := parseNotifyRule(spec); err != nil {
+			c.fail("-notify: %v", err)
+		}
+	}
+
+	if opts.AuthFile != "" {
+		if hashes, err := loadAuthFile(opts.AuthFile); err != nil {
+			c.fail("-auth-file: %v", err)
+		} else {
+			c.pass("-auth-file %s has %d user(s)", opts.AuthFile, len(hashes))
+		}
+	}
+	if opts.TrustedHeader != "" {
+		if strings.TrimSpace(opts.TrustedProxies) == "" {
+			c.fail("-auth-trusted-header requires -auth-trusted-proxies")
+		} else if _, err := parseTrustedProxies(opts.TrustedProxies); err != nil {
+			c.fail("-auth-trusted-proxies: %v", err)
+		}
+	}
+
+	if c.failures > 0 {
+		fmt.Printf("\n%d problem(s) found\n", c.failures)
+		return false
+	}
+	fmt.Println("\nConfiguration OK")
+	return true
+}
+
+// checkPostgres verifies that the -db DSN reaches a PostgreSQL server
+func (c *configCheck) checkPostgres(dsn string) {
+	conn, err := sql.Open(postgresDriverName, dsn)
+	if err != nil {
+		c.fail("-db is not a valid PostgreSQL DSN: %v", err)
+		return
+	}
+	defer conn.Close()
+	if err := conn.Ping(); err != nil {
+		c.fail("-db PostgreSQL server is not reachable: %v", err)
+		return
+	}
+	c.pass("-db PostgreSQL server is reachable")
+}
+
+// checkTarget verifies that a proxy target is an absolute URL whose host resolves
+func (c *configCheck) checkTarget(name, target string) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		c.fail("%s %q is not an absolute URL", name, target)
+		return
+	}
+	if _, err := net.LookupHost(parsed.Hostname()); err != nil {
+		c.fail("%s host %s does not resolve: %v", name, parsed.Hostname(), err)
+		return
+	}
+	c.pass("%s %s resolves", name, target)
+}
+
+// checkDBPath verifies the database file (or its directory, if it does not
+// exist yet) is writable without modifying an existing database
+func (c *configCheck) checkDBPath(dbPath string) {
+	if isInMemoryDSN(dbPath) {
+		c.pass("-db uses an in-memory database")
+		return
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		file, err := os.OpenFile(dbPath, os.O_WRONLY, 0)
+		if err != nil {
+			c.fail("-db %s is not writable: %v", dbPath, err)
+			return
+		}
+		file.Close()
+		c.pass("-db %s is writable", dbPath)
+		return
+	}
+
+	probe, err := os.CreateTemp(filepath.Dir(dbPath), ".dgateway-check-*")
+	if err != nil {
+		c.fail("-db directory %s is not writable: %v", filepath.Dir(dbPath), err)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	c.pass("-db %s can be created", dbPath)
+}
+
+// checkCertificates verifies the HTTPS certificate and key exist and form a valid pair
+func (c *configCheck) checkCertificates() {
+	certFile, keyFile := proxyCertFiles()
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		c.fail("HTTPS certificate %s / %s: %v", certFile, keyFile, err)
+		return
+	}
+	c.pass("HTTPS certificate %s / %s loaded", certFile, keyFile)
+}
+
+func (c *configCheck) checkMITMCA(certFile, keyFile string) {
+	if certFile == "" {
+		certFile, keyFile = defaultCACertFile, defaultCAKeyFile
+	}
+	if _, err := loadLeafCertCache(certFile, keyFile); err != nil {
+		c.fail("-mitm CA %s / %s: %v", certFile, keyFile, err)
+		return
+	}
+	c.pass("-mitm CA %s / %s loaded", certFile, keyFile)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionVacuumInterval is how often the retention loop vacuums the database
+// to hand the space of pruned rows back to the file system
+const retentionVacuumInterval = 24 * time.Hour
+
+// parseRetention parses a retention period such as 72h or 30d; days are not
+// understood by time.ParseDuration
+func parseRetention(value string) (time.Duration, error) {
+	var retention time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention %q", value)
+		}
+		retention = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if retention, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if retention <= 0 {
+		return 0, fmt.Errorf("retention %q must be positive", value)
+	}
+	return retention, nil
+}
+
+// startRetention deletes requests older than retention now and then every
+// interval, vacuuming the database once a day
+func startRetention(retention, interval time.Duration) {
+	go func() {
+		lastVacuum := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			cutoff := time.Now().Add(-retention).Format("2006-01-02 15:04:05")
+			pruned, err := clearRequests(" AND timestamp < ?", cutoff)
+			if err != nil {
+				log.Printf("Error pruning requests older than %s: %v", cutoff, err)
+			} else {
+				log.Printf("Retention: pruned %d requests older than %s", pruned, cutoff)
+			}
+
+			if time.Since(lastVacuum) >= retentionVacuumInterval {
+				if _, err := db.Exec("VACUUM"); err != nil {
+					log.Printf("Error vacuuming database: %v", err)
+				}
+				lastVacuum = time.Now()
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// clearRequests deletes the requests matching the WHERE conditions (each
+// prefixed with " AND "), returning how many were deleted
+func clearRequests(where string, args ...interface{}) (int64, error) {
+	result, err := db.Exec("DELETE FROM requests WHERE 1=1"+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// parseClearBefore accepts a YYYY-MM-DD date or a YYYY-MM-DD HH:MM:SS time and
+// returns it in the form timestamps are compared in
+func parseClearBefore(before string) (string, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, before); err == nil {
+			return t.Format("2006-01-02 15:04:05"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid before %q, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS", before)
+}
+
+// clearRequestsHandler handles POST /api/requests/clear. An optional JSON body
+// {"url": "...", "before": "2024-01-01"} limits the deletion to requests whose
+// URL contains url and that were recorded before the given time; without
+// filters every request is deleted and the database is vacuumed.
+func clearRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var filters struct {
+		URL    string `json:"url"`
+		Before string `json:"before"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filters); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	where, args := buildRequestFilter(url.Values{"url": {filters.URL}})
+	if filters.Before != "" {
+		before, err := parseClearBefore(filters.Before)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		where += " AND timestamp < ?"
+		args = append(args, before)
+	}
+
+	deleted, err := clearRequests(where, args...)
+	if err != nil {
+		http.Error(w, "Failed to clear requests", http.StatusInternalServerError)
+		log.Printf("Error clearing requests: %v", err)
+		return
+	}
+	log.Printf("Cleared %d requests", deleted)
+
+	// Reclaim the space of a full wipe; filtered deletions leave it for reuse
+	if where == "" {
+		if _, err := db.Exec("VACUUM"); err != nil {
+			log.Printf("Error vacuuming database: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Deleted int64 `json:"deleted"`
+	}{deleted})
+}
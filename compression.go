@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decompressGzip decompresses a gzip compressed byte slice.
+func decompressGzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// decompressBrotli decompresses a brotli (br) compressed byte slice.
+func decompressBrotli(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+}
+
+// decompressDeflate decompresses a deflate compressed byte slice. HTTP deflate
+// is zlib-wrapped, but some servers send raw deflate, which is tried next.
+func decompressDeflate(data []byte) ([]byte, error) {
+	if reader, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		defer reader.Close()
+		if decompressed, err := ioutil.ReadAll(reader); err == nil {
+			return decompressed, nil
+		}
+	}
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// contentCodings splits a Content-Encoding value into its codings in the
+// order they were applied, leaving out identity
+func contentCodings(encoding string) []string {
+	var codings []string
+	for _, coding := range strings.Split(encoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// isSupportedEncoding reports whether decompressBody can decode a
+// Content-Encoding value; identity (or no encoding) needs no decoding
+func isSupportedEncoding(encoding string) bool {
+	codings := contentCodings(encoding)
+	if len(codings) == 0 {
+		return false
+	}
+	for _, coding := range codings {
+		switch coding {
+		case "gzip", "x-gzip", "br", "deflate":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// decompressBody decodes data according to a Content-Encoding value such as
+// gzip, br, deflate or "deflate, gzip", undoing the codings in reverse order
+func decompressBody(data []byte, encoding string) ([]byte, error) {
+	codings := contentCodings(encoding)
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			data, err = decompressGzip(data)
+		case "br":
+			data, err = decompressBrotli(data)
+		case "deflate":
+			data, err = decompressDeflate(data)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", codings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", codings[i], err)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// conditionalReplay holds the cache validators sent with a conditional replay
+// and whether the upstream answered 304 Not Modified
+type conditionalReplay struct {
+	IfNoneMatch     string `json:"if_none_match,omitempty"`
+	IfModifiedSince string `json:"if_modified_since,omitempty"`
+	NotModified     bool   `json:"not_modified"`
+}
+
+// loadConditionalReplay reads the ETag and Last-Modified of a stored response
+func loadConditionalReplay(id int) (*conditionalReplay, error) {
+	if id <= 0 {
+		return nil, fmt.Errorf("conditional replay needs the id of the original request")
+	}
+
+	var respHeadersJSON string
+	err := db.QueryRow("SELECT response_headers FROM requests WHERE id = ?", id).Scan(&respHeadersJSON)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("request %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load request %d: %v", id, err)
+	}
+
+	var respHeaders http.Header
+	if err := json.Unmarshal([]byte(respHeadersJSON), &respHeaders); err != nil {
+		return nil, fmt.Errorf("stored response headers of request %d are malformed", id)
+	}
+
+	conditional := &conditionalReplay{
+		IfNoneMatch:     respHeaders.Get("ETag"),
+		IfModifiedSince: respHeaders.Get("Last-Modified"),
+	}
+	if conditional.IfNoneMatch == "" && conditional.IfModifiedSince == "" {
+		return nil, fmt.Errorf("the original response of request %d has no ETag or Last-Modified", id)
+	}
+	return conditional, nil
+}
+
+// apply sets the conditional request headers, replacing any the replay already carries
+func (c *conditionalReplay) apply(req *http.Request) {
+	if c.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", c.IfNoneMatch)
+	}
+	if c.IfModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", c.IfModifiedSince)
+	}
+}
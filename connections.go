@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ActiveConnection describes a proxied request that is currently in flight
+type ActiveConnection struct {
+	ID        int64     `json:"id"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	StartTime time.Time `json:"start_time"`
+	ClientIP  string    `json:"client_ip"`
+
+	cancel context.CancelFunc
+}
+
+// connectionRegistry keeps track of in-flight proxied requests
+type connectionRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	conns  map[int64]*ActiveConnection
+}
+
+var activeConnections = &connectionRegistry{conns: make(map[int64]*ActiveConnection)}
+
+// add registers a new in-flight request and returns its ID
+func (reg *connectionRegistry) add(r *http.Request, cancel context.CancelFunc) int64 {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.nextID++
+	reg.conns[reg.nextID] = &ActiveConnection{
+		ID:        reg.nextID,
+		Method:    r.Method,
+		URL:       r.URL.String(),
+		StartTime: time.Now(),
+		ClientIP:  remoteIP(r),
+		cancel:    cancel,
+	}
+	return reg.nextID
+}
+
+// remove drops a request from the registry once it has completed
+func (reg *connectionRegistry) remove(id int64) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.conns, id)
+}
+
+// cancel aborts an in-flight request via its context, reporting whether it was found
+func (reg *connectionRegistry) cancel(id int64) bool {
+	reg.mu.Lock()
+	conn, ok := reg.conns[id]
+	reg.mu.Unlock()
+	if !ok {
+		return false
+	}
+	conn.cancel()
+	return true
+}
+
+// list returns a snapshot of all in-flight requests ordered by start time
+func (reg *connectionRegistry) list() []ActiveConnection {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	conns := make([]ActiveConnection, 0, len(reg.conns))
+	for _, conn := range reg.conns {
+		conns = append(conns, *conn)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+	return conns
+}
+
+// clientConn identifies a client connection and numbers the requests sent over it
+type clientConn struct {
+	id  int64
+	seq atomic.Int64
+}
+
+type clientConnKey struct{}
+
+var nextClientConnID atomic.Int64
+
+// connContext is used as http.Server.ConnContext to tag each accepted
+// connection with an ID shared by all requests sent over it
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, clientConnKey{}, &clientConn{id: nextClientConnID.Add(1)})
+}
+
+// nextRequestSeq returns the connection ID of the request and its 1-based
+// position among the requests sent over that connection
+func nextRequestSeq(r *http.Request) (connID, seq int64) {
+	conn, ok := r.Context().Value(clientConnKey{}).(*clientConn)
+	if !ok {
+		return 0, 0
+	}
+	return conn.id, conn.seq.Add(1)
+}
+
+// remoteIP returns the IP portion of the request's remote address
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustProxyHeaders makes clientIP believe X-Forwarded-For and X-Real-IP,
+// for deployments where dGateway sits behind a load balancer
+var trustProxyHeaders bool
+
+// clientIP returns the address of the client that made the request: the
+// leftmost X-Forwarded-For entry or X-Real-IP with -trust-proxy-headers, and
+// the remote address of the connection otherwise
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+	}
+	return remoteIP(r)
+}
+
+// forwardHeaders tells upstreams about the original request through
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host
+var forwardHeaders = true
+
+// setForwardedHeaders prepares the headers of the request handed to the proxy.
+// ReverseProxy itself appends the connection address to X-Forwarded-For; the
+// protocol and host the client used are added here, keeping values set by a
+// trusted proxy in front. With -forward-headers=false the client's headers
+// are passed on as received.
+func setForwardedHeaders(r *http.Request) {
+	r.Header = r.Header.Clone()
+	if !forwardHeaders {
+		// ReverseProxy only appends to X-Forwarded-For when it can parse the
+		// remote address, and the WebSocket tunnel skips an empty one
+		r.RemoteAddr = ""
+		return
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if !trustProxyHeaders || r.Header.Get("X-Forwarded-Proto") == "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if !trustProxyHeaders || r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+}
+
+func getConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := struct {
+		Connections []ActiveConnection `json:"connections"`
+		Count       int                `json:"count"`
+	}{
+		Connections: activeConnections.list(),
+	}
+	response.Count = len(response.Connections)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// cancelConnectionHandler handles POST /api/connections/{id}/cancel
+func cancelConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := r.URL.Path[len("/api/connections/"):]
+	if !strings.HasSuffix(rest, "/cancel") {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(rest, "/cancel"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+		return
+	}
+
+	if !activeConnections.cancel(id) {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"message": "Connection cancelled"}`))
+}
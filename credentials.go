@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// adminCredentials verifies admin logins. When any bcrypt hash is configured,
+// through ADMIN_PASSWORD_HASH or -auth-file, only hashed credentials are
+// accepted; otherwise the plaintext ADMIN_USERNAME/ADMIN_PASSWORD pair is used.
+type adminCredentials struct {
+	username string
+	password string
+	hashes   map[string][]byte // bcrypt hashes keyed by username
+}
+
+// loadAuthFile reads username:bcrypt-hash lines, skipping blank lines and # comments
+func loadAuthFile(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.Index(line, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected username:bcrypt-hash", path, lineNum)
+		}
+		username, hash := line[:sep], []byte(line[sep+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid bcrypt hash for %s: %v", path, lineNum, username, err)
+		}
+		if _, ok := hashes[username]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user %s", path, lineNum, username)
+		}
+		hashes[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("%s has no credentials", path)
+	}
+	return hashes, nil
+}
+
+// newAdminCredentials combines the plaintext pair with the optional
+// ADMIN_PASSWORD_HASH (for username) and -auth-file hashes. The environment
+// hash overrides a file entry for the same user.
+func newAdminCredentials(username, password, passwordHash, authFile string) (*adminCredentials, error) {
+	creds := &adminCredentials{username: username, password: password}
+	if authFile != "" {
+		hashes, err := loadAuthFile(authFile)
+		if err != nil {
+			return nil, err
+		}
+		creds.hashes = hashes
+	}
+	if passwordHash != "" {
+		if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_PASSWORD_HASH: %v", err)
+		}
+		if creds.hashes == nil {
+			creds.hashes = make(map[string][]byte)
+		}
+		creds.hashes[username] = []byte(passwordHash)
+	}
+	return creds, nil
+}
+
+// hashed reports whether logins are checked against bcrypt hashes
+func (c *adminCredentials) hashed() bool {
+	return len(c.hashes) > 0
+}
+
+// verify reports whether the username and password are valid
+func (c *adminCredentials) verify(username, password string) bool {
+	if c.hashed() {
+		hash, ok := c.hashes[username]
+		if !ok {
+			return false
+		}
+		return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(c.username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(c.password)) == 1
+	return userOK && passwordOK
+}
@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http" // Added for http.Header
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -27,18 +31,114 @@ type RequestLog struct {
 	ResponseBody   []byte
 	ResponseBodySize int // New field
 	IsResponseBodyText bool // New field
+	ResponseWireSize int // Response body size as received from upstream, before decompression
+	ConnID int64 // ID of the client connection the request arrived on
+	Seq int64 // Position of the request among those sent over its connection
+	RequestHeaderSize int // Total size of the request header lines in bytes
+	RequestHeadersOversized bool // Header size exceeded the -header-size-warn threshold
+	RequestCharset string // Charset declared by the request Content-Type
+	ResponseCharset string // Charset declared by the response Content-Type
+	ClientStatusCode int // Status sent to the client after -map-status rewriting
+	ListenerPort int // Proxy port that received the request
+	ClientBytesSent int64 // Response body bytes actually written to the client
+	BodyError string // Errors hit while reading or decompressing the bodies
+	Anomaly bool // Response time or size is an outlier for the path template
+	AnomalyReason string // Which measurement was an outlier and by how much
+	HandledBy string // What produced the response: upstream, block, ratelimit, mock or maintenance
+	MasksApplied string // Comma-separated names of the -mask-body/-mask-preset masks that matched
+	TLSSNI string // Server name the client sent in the TLS handshake, empty for plain HTTP
+	RequestRange string // Range header sent by the client
+	ContentRange string // Content-Range of a partial (206) or unsatisfiable (416) response
+	Upstream string // Backend the request was sent to, one of -targets or the listener's target
+	UpstreamURL string // URL the request was (or would have been) forwarded to
+	Route string // Prefix of the -routes entry that matched the request path
+	DurationMs int64 // Total time to handle the request in milliseconds, -1 when unknown
+	SendMs int64 // Time until the request was written to the upstream, -1 when unknown
+	TTFBMs int64 // Time until the first upstream response byte, -1 when unknown
+	DNSMs int64 // Time resolving the upstream host, -1 when unknown or no lookup was made
+	ConnectMs int64 // Time opening the TCP connection, -1 when unknown or a connection was reused
+	TLSMs int64 // Time of the upstream TLS handshake, -1 when unknown or none was made
+	Truncated bool // Only the first -stream-capture-bytes of a streamed response body were recorded
+	Protocol string // http for request/response exchanges, websocket for a single WebSocket frame
+	RequestBodyTruncated bool // Only the first -max-body-size bytes of the request body were stored
+	ResponseBodyTruncated bool // Only the first -max-body-size bytes of the response body were stored
+	ClientIP string // Address of the client, from proxy headers with -trust-proxy-headers
+	Decisions []decision // Handling decisions, returned by the explain endpoint
+	JSONFields map[string]string // Values of the -index-json-field columns, keyed by field name
+	Tags []string // Labels added through the tags endpoints
+	RetryCount int // Times the upstream request was resent by -retries
+	RequestProto string // Protocol of the client request, e.g. HTTP/1.1 or HTTP/2.0
+	ResponseProto string // Protocol of the upstream response, empty for gateway-generated responses
+	Origin string // How the row entered the database: proxy, replay or import
+
+	record       bool          // Set by ModifyResponse when the exchange should be logged
+	pathExcluded bool          // The URL path is ruled out by -record-include/-record-exclude
+	routeRecord  *bool         // record attribute of the matched -routes entry, if set
+	duration     time.Duration // Time from forwarding the request to finishing the response
 }
 
+// Values of the origin column, telling how a row entered the database
+const (
+	originProxy  = "proxy"  // Captured by the proxy
+	originReplay = "replay" // Replayed through /api/replay with "record": true
+	originImport = "import" // Imported from a HAR file
+)
+
+// originColumn selects the origin of a row. Rows stored before the column
+// existed were captured by the proxy, or imported when handled_by says so.
+const originColumn = "COALESCE(origin, CASE WHEN handled_by = 'import' THEN 'import' ELSE 'proxy' END)"
+
 var db *sql.DB
 
+// maxBodySize caps the bytes stored of each body; 0 stores bodies whole
+var maxBodySize = 0
+
+// BodySampleRate is the fraction (0-1) of requests whose full bodies are stored
+var BodySampleRate = 1.0
+
+var (
+	sampleRandMu sync.Mutex
+	sampleRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// sqliteBusyTimeoutMs is how long a SQLite write waits for the database lock
+const sqliteBusyTimeoutMs = 5000
+
+// Connection pool limits, set by -db-max-open-conns, -db-max-idle-conns and
+// -db-conn-max-lifetime; in-memory databases always use a single connection
+var (
+	dbMaxOpenConns    = 10
+	dbMaxIdleConns    = 5
+	dbConnMaxLifetime = 30 * time.Minute
+)
+
+// InitDB opens the -db-driver store, creating or migrating its schema
 func InitDB(dataSourceName string) {
-	var err error
-	db, err = sql.Open("sqlite", dataSourceName)
-	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+	if dbDriver == dbDriverPostgres {
+		pg := openPostgresStore(dataSourceName)
+		store, db = pg, pg.db
+		log.Println("Database initialized successfully.")
+		return
 	}
 
-	createTableSQL := `
+	inMemory := isInMemoryDSN(dataSourceName)
+	sqlite := openSQLiteStore(dataSourceName, inMemory)
+	store, db = sqlite, sqlite.db
+	if inMemory {
+		log.Println("In-memory database initialized; captured requests are lost on exit.")
+	} else {
+		log.Println("Database initialized successfully.")
+	}
+}
+
+// sqliteStore stores the requests in a SQLite database, the default -db-driver
+type sqliteStore struct {
+	sqlStore
+}
+
+// sqliteCreateTableSQL creates the requests table as first released; the
+// columns added since are created by migrateSchema
+const sqliteCreateTableSQL = `
 	CREATE TABLE IF NOT EXISTS requests (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		timestamp DATETIME,
@@ -52,41 +152,49 @@ func InitDB(dataSourceName string) {
 	);
 	`
 
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+// sqliteURLIndexSQL indexes url for the LIKE 'x%' lookups of the url_prefix
+// filter. LIKE is case-insensitive in SQLite, so the index must be NOCASE
+// for it to be used.
+const sqliteURLIndexSQL = "CREATE INDEX IF NOT EXISTS idx_requests_url ON requests(url COLLATE NOCASE);"
+
+// openSQLiteStore opens a SQLite database file, or an in-memory database
+func openSQLiteStore(dataSourceName string, inMemory bool) *sqliteStore {
+	if inMemory && dataSourceName == ":memory:" {
+		// Use a named shared-cache DSN so every pooled connection sees the same database
+		dataSourceName = "file::memory:?cache=shared"
 	}
 
-	// Add new columns if they don't exist
-	// SQLite doesn't have IF NOT EXISTS for ADD COLUMN, so we check manually
-	// Start a transaction for schema modifications
-	tx, err := db.Begin()
-	if err != nil {
-		log.Fatalf("Failed to begin transaction for schema migration: %v", err)
+	if !strings.Contains(dataSourceName, "busy_timeout") {
+		// Wait for the write lock held by another connection, e.g. of another
+		// -log-workers writer, instead of failing with SQLITE_BUSY
+		separator := "?"
+		if strings.Contains(dataSourceName, "?") {
+			separator = "&"
+		}
+		dataSourceName += separator + "_pragma=busy_timeout(" + strconv.Itoa(sqliteBusyTimeoutMs) + ")"
 	}
-	defer tx.Rollback() // Rollback on error or if not committed
 
-	addColumnIfNotExists(tx, "requests", "request_body_size", "INTEGER")
-	addColumnIfNotExists(tx, "requests", "is_request_body_text", "BOOLEAN")
-	addColumnIfNotExists(tx, "requests", "response_body_size", "INTEGER")
-	addColumnIfNotExists(tx, "requests", "is_response_body_text", "BOOLEAN")
+	s := &sqliteStore{sqlStore{db: openDB("sqlite", dataSourceName, inMemory)}}
+	migrateSchema(s.db, s, sqliteCreateTableSQL, sqliteURLIndexSQL)
 
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("Failed to commit schema migration: %v", err)
+	// Enable WAL mode for better concurrency; it does not apply to in-memory databases
+	if !inMemory {
+		if _, err := s.db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
+			log.Printf("Failed to enable WAL mode: %v", err)
+		}
 	}
 
-	// Enable WAL mode for better concurrency
-	_, err = db.Exec("PRAGMA journal_mode=WAL;")
-	if err != nil {
-		log.Printf("Failed to enable WAL mode: %v", err)
-	}
+	s.prepareInsert()
+	return s
+}
 
-	log.Println("Database initialized successfully.")
+// isInMemoryDSN reports whether the data source names an in-memory SQLite database
+func isInMemoryDSN(dataSourceName string) bool {
+	return dataSourceName == ":memory:" || strings.HasPrefix(dataSourceName, "file::memory:") || strings.Contains(dataSourceName, "mode=memory")
 }
 
-// addColumnIfNotExists checks if a column exists and adds it if not.
-// It assumes it's called within a transaction.
-func addColumnIfNotExists(tx *sql.Tx, tableName, columnName, columnType string) {
+// columnExists checks PRAGMA table_info, SQLite having no IF NOT EXISTS for ADD COLUMN
+func (s *sqliteStore) columnExists(tx *sql.Tx, tableName, columnName string) bool {
 	query := fmt.Sprintf("PRAGMA table_info(%s);", tableName)
 	rows, err := tx.Query(query)
 	if err != nil {
@@ -94,7 +202,6 @@ func addColumnIfNotExists(tx *sql.Tx, tableName, columnName, columnType string)
 	}
 	defer rows.Close()
 
-	columnExists := false
 	for rows.Next() {
 		var cid int
 		var name string
@@ -106,42 +213,129 @@ func addColumnIfNotExists(tx *sql.Tx, tableName, columnName, columnType string)
 			log.Fatalf("Failed to scan table info row: %v", err)
 		}
 		if name == columnName {
-			columnExists = true
-			break
+			return true
 		}
 	}
+	return false
+}
 
-	if !columnExists {
-		alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", tableName, columnName, columnType)
-		_, err := tx.Exec(alterSQL)
-		if err != nil {
-			log.Fatalf("Failed to add column %s to table %s: %v", columnName, tableName, err)
-		}
-		log.Printf("Added column %s to table %s.", columnName, tableName)
+func (s *sqliteStore) columnType(columnType string) string {
+	return columnType
+}
+
+func (s *sqliteStore) likeOperator() string {
+	return "LIKE"
+}
+
+func (s *sqliteStore) urlPrefixCondition() string {
+	return "url LIKE ? ESCAPE '\\'"
+}
+
+func (s *sqliteStore) errorCondition() string {
+	return requestErrorCondition
+}
+
+// jsonFieldNumber is the column itself, whose NUMERIC affinity stores
+// numbers as numbers
+func (s *sqliteStore) jsonFieldNumber(column string) string {
+	return column
+}
+
+// jsonFieldOrder relies on the NUMERIC affinity too, which sorts numbers
+// numerically and before text
+func (s *sqliteStore) jsonFieldOrder(column, direction string) string {
+	return column + " " + direction + " NULLS LAST"
+}
+
+// pendingInsert is a log entry ready to be stored and the arguments of the
+// store's INSERT storing it
+type pendingInsert struct {
+	entry RequestLog
+	args  []interface{}
+}
+
+// stored runs what follows storing an entry
+func (p *pendingInsert) stored() {
+	if len(notifyRules) > 0 {
+		notifyMatchingRules(p.entry)
 	}
 }
 
-func LogRequest(logEntry RequestLog) {
+// prepareLogEntry fills in the derived fields of a log entry, applies the
+// masking and body capture rules and builds the INSERT storing it
+func prepareLogEntry(logEntry RequestLog) pendingInsert {
 	// Populate size and text/binary info
 	logEntry.RequestBodySize = len(logEntry.RequestBody)
-	logEntry.IsRequestBodyText = isTextData(logEntry.RequestBody, getContentTypeFromHeaders(logEntry.RequestHeaders))
+	logEntry.IsRequestBodyText = isTextData(logEntry.RequestBody, getContentTypeFromHeaders(logEntry.RequestHeaders), textThreshold, textSampleBytes)
 	logEntry.ResponseBodySize = len(logEntry.ResponseBody)
-	logEntry.IsResponseBodyText = isTextData(logEntry.ResponseBody, getContentTypeFromHeaders(logEntry.ResponseHeaders))
-
-	stmt, err := db.Prepare(`
-	INSERT INTO requests(
-		timestamp, method, url, request_headers, request_body, request_body_size, is_request_body_text,
-		status_code, response_headers, response_body, response_body_size, is_response_body_text
-	)
-	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		log.Printf("Failed to prepare statement: %v", err)
-		return
+	logEntry.IsResponseBodyText = isTextData(logEntry.ResponseBody, getContentTypeFromHeaders(logEntry.ResponseHeaders), textThreshold, textSampleBytes)
+	logEntry.RequestCharset = parseCharset(getContentTypeFromHeaders(logEntry.RequestHeaders))
+	logEntry.ResponseCharset = parseCharset(getContentTypeFromHeaders(logEntry.ResponseHeaders))
+	if logEntry.HandledBy == "" {
+		logEntry.HandledBy = handledByUpstream
+	}
+	if logEntry.Protocol == "" {
+		logEntry.Protocol = protocolHTTP
+	}
+	if logEntry.Origin == "" {
+		logEntry.Origin = originProxy
+	}
+	if logEntry.ClientStatusCode == 0 {
+		logEntry.ClientStatusCode = logEntry.StatusCode
+	}
+	logEntry.DurationMs = logEntry.duration.Milliseconds()
+	if logEntry.ResponseWireSize == 0 {
+		// Uncompressed responses travel over the wire as stored
+		logEntry.ResponseWireSize = logEntry.ResponseBodySize
+	}
+
+	// WebSocket frames have no response time to compare
+	if logEntry.Protocol == protocolHTTP {
+		logEntry.Anomaly, logEntry.AnomalyReason = detectAnomaly(logEntry, logEntry.duration)
+	}
+	if logEntry.Anomaly {
+		logEntry.addDecision(decisionAnomaly, "%s", logEntry.AnomalyReason)
+	}
+
+	// Mask sensitive data before anything derived from the bodies is stored
+	logEntry.MasksApplied = maskLogEntry(&logEntry)
+	if logEntry.MasksApplied != "" {
+		logEntry.addDecision(decisionMask, "masks applied to stored bodies: %s", logEntry.MasksApplied)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(
+	// Extract the derived JSON columns before the bodies may be dropped
+	_, jsonValues := jsonFieldValues(logEntry)
+
+	// Drop the bodies of requests not selected by the sampler, keeping their sizes
+	if !shouldStoreBodies(logEntry) {
+		logEntry.RequestBody = nil
+		logEntry.ResponseBody = nil
+		logEntry.addDecision(decisionBodyCapture, "bodies not stored, request not selected by -body-sample-rate %g", BodySampleRate)
+	}
+
+	// Drop bodies whose content type is excluded from capture, keeping their sizes
+	if contentType := getContentTypeFromHeaders(logEntry.RequestHeaders); !shouldCaptureBody(contentType) {
+		logEntry.RequestBody = nil
+		logEntry.addDecision(decisionBodyCapture, "request body of type %q not stored by the content type capture rules", contentType)
+	}
+	if contentType := getContentTypeFromHeaders(logEntry.ResponseHeaders); !shouldCaptureBody(contentType) {
+		logEntry.ResponseBody = nil
+		logEntry.addDecision(decisionBodyCapture, "response body of type %q not stored by the content type capture rules", contentType)
+	}
+
+	// Store only the first -max-body-size bytes of large bodies, keeping their sizes
+	if maxBodySize > 0 && len(logEntry.RequestBody) > maxBodySize {
+		logEntry.RequestBody = logEntry.RequestBody[:maxBodySize]
+		logEntry.RequestBodyTruncated = true
+		logEntry.addDecision(decisionBodyCapture, "request body of %d bytes cut to -max-body-size %d", logEntry.RequestBodySize, maxBodySize)
+	}
+	if maxBodySize > 0 && len(logEntry.ResponseBody) > maxBodySize {
+		logEntry.ResponseBody = logEntry.ResponseBody[:maxBodySize]
+		logEntry.ResponseBodyTruncated = true
+		logEntry.addDecision(decisionBodyCapture, "response body of %d bytes cut to -max-body-size %d", logEntry.ResponseBodySize, maxBodySize)
+	}
+
+	args := []interface{}{
 		logEntry.Timestamp,
 		logEntry.Method,
 		logEntry.URL,
@@ -154,10 +348,283 @@ func LogRequest(logEntry RequestLog) {
 		logEntry.ResponseBody,
 		logEntry.ResponseBodySize,
 		logEntry.IsResponseBodyText,
-	)
+		logEntry.ResponseWireSize,
+		logEntry.ConnID,
+		logEntry.Seq,
+		logEntry.RequestHeaderSize,
+		logEntry.RequestHeadersOversized,
+		logEntry.RequestCharset,
+		logEntry.ResponseCharset,
+		logEntry.ClientStatusCode,
+		logEntry.ListenerPort,
+		logEntry.ClientBytesSent,
+		logEntry.BodyError,
+		logEntry.Anomaly,
+		logEntry.AnomalyReason,
+		logEntry.HandledBy,
+		logEntry.MasksApplied,
+		logEntry.TLSSNI,
+		logEntry.RequestRange,
+		logEntry.ContentRange,
+		logEntry.UpstreamURL,
+		decisionsToJSON(logEntry.Decisions),
+		logEntry.Upstream,
+		logEntry.Route,
+		logEntry.DurationMs,
+		logEntry.SendMs,
+		logEntry.TTFBMs,
+		logEntry.Truncated,
+		logEntry.Protocol,
+		logEntry.RequestBodyTruncated,
+		logEntry.ResponseBodyTruncated,
+		logEntry.ClientIP,
+		logEntry.RetryCount,
+		logEntry.RequestProto,
+		logEntry.ResponseProto,
+		logEntry.DNSMs,
+		logEntry.ConnectMs,
+		logEntry.TLSMs,
+		logEntry.Origin,
+	}
+	args = append(args, jsonValues...)
+	return pendingInsert{entry: logEntry, args: args}
+}
+
+// requestInsertColumns are the columns set by the INSERT storing log
+// entries, in the order of the arguments built by prepareLogEntry
+const requestInsertColumns = `timestamp, method, url, request_headers, request_body, request_body_size, is_request_body_text,
+		status_code, response_headers, response_body, response_body_size, is_response_body_text,
+		response_wire_size, conn_id, seq, request_header_size, request_headers_oversized,
+		request_charset, response_charset, client_status_code, listener_port, client_bytes_sent,
+		body_error, anomaly, anomaly_reason, handled_by, masks_applied, tls_sni, request_range, content_range,
+		upstream_url, decisions, upstream, route,
+		duration_ms, send_ms, ttfb_ms, truncated, protocol, request_body_truncated, response_body_truncated, client_ip, retry_count, request_proto, response_proto, dns_ms, connect_ms, tls_ms, origin`
+
+// buildRequestFilter builds the WHERE conditions (each prefixed with " AND ")
+// for the url, url_prefix, start_date, end_date, method, status, status_class,
+// tag, listener_port, client_ip, origin, handled_by, anomaly, has_errors and derived
+// JSON field list filters. The list and its count, the exports, the stats and
+// the clear endpoint all use it, so each filter means the same everywhere.
+func buildRequestFilter(params url.Values) (whereClause string, args []interface{}) {
+	// URL filter
+	if urlFilter := params.Get("url"); urlFilter != "" {
+		whereClause += " AND url " + store.likeOperator() + " ?"
+		args = append(args, "%"+urlFilter+"%")
+	}
+
+	// URL prefix filter, which unlike the url filter can use the url index
+	if urlPrefix := params.Get("url_prefix"); urlPrefix != "" {
+		whereClause += " AND " + store.urlPrefixCondition()
+		args = append(args, escapeLike(urlPrefix)+"%")
+	}
+
+	// Date filters - convert date strings to datetime format
+	if startDate := params.Get("start_date"); startDate != "" {
+		// Convert YYYY-MM-DD to datetime format with start of day
+		whereClause += " AND timestamp >= ?"
+		args = append(args, startDate+" 00:00:00")
+	}
+	if endDate := params.Get("end_date"); endDate != "" {
+		// Convert YYYY-MM-DD to datetime format with end of day
+		whereClause += " AND timestamp <= ?"
+		args = append(args, endDate+" 23:59:59")
+	}
+
+	// HTTP method, e.g. method=POST
+	if method := params.Get("method"); method != "" {
+		whereClause += " AND method = ?"
+		args = append(args, strings.ToUpper(method))
+	}
+
+	// Exact status code, e.g. status=404
+	if status, err := strconv.Atoi(params.Get("status")); err == nil {
+		whereClause += " AND status_code = ?"
+		args = append(args, status)
+	}
+
+	// Status class, e.g. status_class=4xx for 400 to 499
+	if class := strings.ToLower(params.Get("status_class")); len(class) == 3 && class[0] >= '1' && class[0] <= '5' && class[1:] == "xx" {
+		low := int(class[0]-'0') * 100
+		whereClause += " AND status_code >= ? AND status_code < ?"
+		args = append(args, low, low+100)
+	}
+
+	// Requests labelled with a tag, e.g. tag=bug-123
+	if tag := params.Get("tag"); tag != "" {
+		condition, arg := tagFilter(tag)
+		whereClause += condition
+		args = append(args, arg)
+	}
+
+	// Listener port filter for multi-listener setups
+	if port, err := strconv.Atoi(params.Get("listener_port")); err == nil {
+		whereClause += " AND listener_port = ?"
+		args = append(args, port)
+	}
+
+	// How the row entered the database, e.g. origin=replay
+	if origin := params.Get("origin"); origin != "" {
+		whereClause += " AND " + originColumn + " = ?"
+		args = append(args, origin)
+	}
+
+	// What produced the response, e.g. handled_by=ratelimit
+	if handledBy := params.Get("handled_by"); handledBy != "" {
+		whereClause += " AND COALESCE(handled_by, 'upstream') = ?"
+		args = append(args, handledBy)
+	}
+
+	// http exchanges or websocket frames
+	if protocol := params.Get("protocol"); protocol != "" {
+		whereClause += " AND COALESCE(protocol, 'http') = ?"
+		args = append(args, protocol)
+	}
+
+	// Client address, e.g. client_ip=10.0.0.12
+	if ip := params.Get("client_ip"); ip != "" {
+		whereClause += " AND client_ip = ?"
+		args = append(args, ip)
+	}
+
+	// Path prefix route that matched, e.g. route=/api/users
+	if route := params.Get("route"); route != "" {
+		whereClause += " AND route = ?"
+		args = append(args, route)
+	}
+
+	// Statistical outliers flagged on insert
+	if anomaly, err := strconv.ParseBool(params.Get("anomaly")); err == nil {
+		whereClause += " AND COALESCE(anomaly, 0) = ?"
+		args = append(args, anomaly)
+	}
+
+	// Rows with malformed stored headers or recorded body errors
+	if hasErrors, err := strconv.ParseBool(params.Get("has_errors")); err == nil {
+		if hasErrors {
+			whereClause += " AND " + store.errorCondition()
+		} else {
+			whereClause += " AND NOT " + store.errorCondition()
+		}
+	}
+
+	// Derived JSON field filters, e.g. json_userId=42
+	for _, field := range jsonFieldIndexes {
+		if value := params.Get(field.column()); value != "" {
+			whereClause += " AND " + field.column() + " = ?"
+			args = append(args, value)
+		}
+	}
+
+	return whereClause, args
+}
+
+// getRequestLogs loads full request logs (including bodies) matching the given
+// WHERE conditions, ordered by timestamp
+func getRequestLogs(where string, args ...interface{}) ([]RequestLog, error) {
+	var requests []RequestLog
+	err := eachRequestLog(where, args, func(req RequestLog) error {
+		requests = append(requests, req)
+		return nil
+	})
+	return requests, err
+}
+
+// eachRequestLog passes the full request logs matching the given WHERE
+// conditions to fn one at a time, ordered by timestamp, so exports of large
+// databases need not hold every row in memory. An error from fn stops the
+// iteration and is returned.
+func eachRequestLog(where string, args []interface{}, fn func(RequestLog) error) error {
+	rows, err := db.Query("SELECT id, timestamp, method, url, request_headers, request_body, status_code, response_headers, response_body, COALESCE(response_wire_size, 0), COALESCE(listener_port, 0), COALESCE(tls_sni, ''), COALESCE(duration_ms, -1), COALESCE(send_ms, -1), COALESCE(ttfb_ms, -1), COALESCE(request_proto, ''), COALESCE(response_proto, ''), COALESCE(dns_ms, -1), COALESCE(connect_ms, -1), COALESCE(tls_ms, -1), COALESCE(response_body_size, 0), COALESCE(response_body_truncated, 0), COALESCE(truncated, 0), COALESCE(masks_applied, '') FROM requests WHERE 1=1"+where+" ORDER BY timestamp", args...)
 	if err != nil {
-		log.Printf("Failed to insert log entry: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var req RequestLog
+		if err := rows.Scan(&req.ID, &req.Timestamp, &req.Method, &req.URL, &req.RequestHeaders, &req.RequestBody, &req.StatusCode, &req.ResponseHeaders, &req.ResponseBody, &req.ResponseWireSize, &req.ListenerPort, &req.TLSSNI, &req.DurationMs, &req.SendMs, &req.TTFBMs, &req.RequestProto, &req.ResponseProto, &req.DNSMs, &req.ConnectMs, &req.TLSMs, &req.ResponseBodySize, &req.ResponseBodyTruncated, &req.Truncated, &req.MasksApplied); err != nil {
+			log.Printf("Error scanning request: %v", err)
+			continue
+		}
+		if err := fn(req); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// requestSummaryColumns lists the columns returned by the request list
+// endpoints, including any -index-json-field columns
+func requestSummaryColumns() string {
+	columns := "id, timestamp, method, url, status_code, COALESCE(duration_ms, -1), COALESCE(client_ip, ''), COALESCE(tags, ''), " + originColumn
+	for _, field := range jsonFieldIndexes {
+		columns += ", " + field.column()
+	}
+	return columns
+}
+
+// scanRequestSummaries reads rows selected with requestSummaryColumns
+func scanRequestSummaries(rows *sql.Rows) []RequestLog {
+	var requests []RequestLog
+	for rows.Next() {
+		var req RequestLog
+		jsonValues := make([]sql.NullString, len(jsonFieldIndexes))
+		var tags string
+		dest := []interface{}{&req.ID, &req.Timestamp, &req.Method, &req.URL, &req.StatusCode, &req.DurationMs, &req.ClientIP, &tags, &req.Origin}
+		for i := range jsonValues {
+			dest = append(dest, &jsonValues[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.Printf("Error scanning request: %v", err)
+			continue
+		}
+		req.Tags = parseTags(tags)
+		if len(jsonFieldIndexes) > 0 {
+			req.JSONFields = make(map[string]string)
+			for i, field := range jsonFieldIndexes {
+				if jsonValues[i].Valid {
+					req.JSONFields[field.Name] = jsonValues[i].String
+				}
+			}
+		}
+		requests = append(requests, req)
 	}
+	return requests
+}
+
+// compressionRatio returns how many times larger the decompressed body is than
+// the bytes received over the wire, or 0 when unknown
+func compressionRatio(bodySize, wireSize int) float64 {
+	if wireSize <= 0 {
+		return 0
+	}
+	return float64(bodySize) / float64(wireSize)
+}
+
+// shouldStoreBodies decides whether the full bodies of an entry are persisted.
+// Error responses are always kept in full regardless of the sample rate.
+func shouldStoreBodies(logEntry RequestLog) bool {
+	if BodySampleRate >= 1 || logEntry.StatusCode >= 400 {
+		return true
+	}
+	if BodySampleRate <= 0 {
+		return false
+	}
+	sampleRandMu.Lock()
+	defer sampleRandMu.Unlock()
+	return sampleRand.Float64() < BodySampleRate
+}
+
+// headerSize returns the size in bytes of the header lines as sent on the
+// wire ("Name: value\r\n" for each value)
+func headerSize(headers http.Header) int {
+	size := 0
+	for name, values := range headers {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
 }
 
 // Helper to convert http.Header to JSON string
@@ -170,8 +637,16 @@ func HeadersToJSON(headers http.Header) string {
 	return string(jsonBytes)
 }
 
-// isTextData determines if the given data is text or binary
-func isTextData(data []byte, contentType string) bool {
+// Body text detection settings (-text-threshold and -text-sample-bytes)
+var (
+	textThreshold   = 0.7 // Fraction of printable bytes above which a body is text
+	textSampleBytes = 512 // Number of leading bytes inspected
+)
+
+// isTextData determines if the given data is text or binary. Without a text
+// content type, data is text when more than threshold of its first
+// sampleBytes bytes are printable ASCII or whitespace.
+func isTextData(data []byte, contentType string, threshold float64, sampleBytes int) bool {
 	// If content type indicates text, treat as text
 	if strings.HasPrefix(contentType, "text/") ||
 		strings.Contains(contentType, "application/json") ||
@@ -187,24 +662,29 @@ func isTextData(data []byte, contentType string) bool {
 	}
 
 	// Check if data contains mostly printable characters
+	sample := data[:min(len(data), sampleBytes)]
 	textChars := 0
-	for _, b := range data[:min(len(data), 512)] { // Check first 512 bytes or less
+	for _, b := range sample {
 		if b == 0x09 || b == 0x0A || b == 0x0D || (b >= 0x20 && b <= 0x7E) {
 			textChars++
 		}
 	}
-	
-	// If more than 70% of characters are printable, treat as text
-	return float64(textChars)/float64(min(len(data), 512)) > 0.7
+
+	return float64(textChars)/float64(len(sample)) > threshold
 }
 
 // getContentTypeFromHeaders extracts content type from JSON headers string
 func getContentTypeFromHeaders(headersJSON string) string {
+	return getHeaderFromJSON(headersJSON, "Content-Type")
+}
+
+// getHeaderFromJSON returns a header value from headers stored as JSON
+func getHeaderFromJSON(headersJSON, name string) string {
 	var headers http.Header
 	if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil {
 		return ""
 	}
-	return headers.Get("Content-Type")
+	return headers.Get(name)
 }
 
 // min returns the smaller of two integers
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testDBOnce sync.Once
+
+// setupTestDB opens the shared in-memory database once and empties it, so
+// every test starts without stored requests
+func setupTestDB(tb testing.TB) {
+	tb.Helper()
+	testDBOnce.Do(func() { InitDB(":memory:") })
+	if _, err := db.Exec("DELETE FROM requests"); err != nil {
+		tb.Fatalf("emptying the requests table: %v", err)
+	}
+}
+
+// testRequestLogs builds n captured exchanges with small JSON bodies
+func testRequestLogs(n int) []RequestLog {
+	header := http.Header{"Content-Type": {"application/json"}}
+	entries := make([]RequestLog, n)
+	for i := range entries {
+		entries[i] = RequestLog{
+			Timestamp:       time.Now(),
+			Method:          "POST",
+			URL:             fmt.Sprintf("/api/items/%d", i),
+			RequestHeaders:  HeadersToJSON(header),
+			RequestBody:     []byte(fmt.Sprintf(`{"item":%d}`, i)),
+			StatusCode:      http.StatusOK,
+			ResponseHeaders: HeadersToJSON(header),
+			ResponseBody:    []byte(`{"ok":true}`),
+		}
+	}
+	return entries
+}
+
+func TestLogRequestsBatch(t *testing.T) {
+	setupTestDB(t)
+	const n = 10000
+
+	stored := store.LogRequests(testRequestLogs(n))
+	if len(stored) != n {
+		t.Fatalf("LogRequests stored %d entries, want %d", len(stored), n)
+	}
+	ids := make(map[int]bool, n)
+	for _, entry := range stored {
+		if entry.ID == 0 || ids[entry.ID] {
+			t.Fatalf("entry %q got ID %d, want a new non-zero ID", entry.URL, entry.ID)
+		}
+		ids[entry.ID] = true
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM requests").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("requests table has %d rows, want %d", count, n)
+	}
+}
+
+func BenchmarkLogRequests(b *testing.B) {
+	setupTestDB(b)
+	entries := testRequestLogs(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if stored := store.LogRequests(entries); len(stored) != len(entries) {
+			b.Fatalf("LogRequests stored %d entries, want %d", len(stored), len(entries))
+		}
+	}
+}
+
+func TestBuildRequestFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		where string
+		args  []interface{}
+	}{
+		{"no filters", "", "", nil},
+		{"url", "url=/users", " AND url LIKE ?", []interface{}{"%/users%"}},
+		{"start date", "start_date=2024-01-02", " AND timestamp >= ?", []interface{}{"2024-01-02 00:00:00"}},
+		{"date range", "end_date=2024-01-03&start_date=2024-01-02",
+			" AND timestamp >= ? AND timestamp <= ?", []interface{}{"2024-01-02 00:00:00", "2024-01-03 23:59:59"}},
+		{"method is upper-cased", "method=post", " AND method = ?", []interface{}{"POST"}},
+		{"status", "status=404", " AND status_code = ?", []interface{}{404}},
+		{"invalid status is ignored", "status=abc", "", nil},
+		{"status class", "status_class=5XX", " AND status_code >= ? AND status_code < ?", []interface{}{500, 600}},
+		{"invalid status class is ignored", "status_class=6xx", "", nil},
+		{"url and dates", "url=/users&start_date=2024-01-02&end_date=2024-01-03",
+			" AND url LIKE ? AND timestamp >= ? AND timestamp <= ?",
+			[]interface{}{"%/users%", "2024-01-02 00:00:00", "2024-01-03 23:59:59"}},
+		{"all combined", "status_class=4xx&method=GET&status=404&end_date=2024-01-03&url=/users&start_date=2024-01-02",
+			" AND url LIKE ? AND timestamp >= ? AND timestamp <= ? AND method = ? AND status_code = ? AND status_code >= ? AND status_code < ?",
+			[]interface{}{"%/users%", "2024-01-02 00:00:00", "2024-01-03 23:59:59", "GET", 404, 400, 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, args := buildRequestFilter(params)
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestIsTextData(t *testing.T) {
+	// printable followed by binary bytes
+	mixed := func(printable, binary int) []byte {
+		return append(bytes.Repeat([]byte("a"), printable), bytes.Repeat([]byte{0x00}, binary)...)
+	}
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		threshold   float64
+		sampleBytes int
+		want        bool
+	}{
+		{"empty", nil, "", 0.7, 512, true},
+		{"text content type wins", mixed(0, 10), "text/plain", 0.7, 512, true},
+		{"json content type wins", mixed(0, 10), "application/json; charset=utf-8", 0.7, 512, true},
+		{"binary content type checks the data", mixed(0, 10), "application/octet-stream", 0.7, 512, false},
+		{"whitespace is text", []byte("a\tb\r\nc"), "", 0.7, 512, true},
+		{"bytes above 0x7e are not text", []byte{0x80, 0xff, 0x7f}, "", 0.7, 512, false},
+		{"just above threshold", mixed(8, 2), "", 0.7, 512, true},
+		{"at threshold", mixed(7, 3), "", 0.7, 512, false},
+		{"below threshold", mixed(6, 4), "", 0.7, 512, false},
+		{"zero threshold needs one printable byte", mixed(1, 9), "", 0, 512, true},
+		{"zero threshold all binary", mixed(0, 10), "", 0, 512, false},
+		{"threshold one is never reached", mixed(10, 0), "", 1, 512, false},
+		{"binary after the sample is ignored", mixed(4, 4), "", 0.7, 4, true},
+		{"sample one byte into the binary", mixed(4, 4), "", 0.7, 5, true},
+		{"sample two bytes into the binary", mixed(4, 4), "", 0.7, 6, false},
+		{"sample larger than the data", mixed(4, 4), "", 0.7, 512, false},
+		{"sample of one byte", mixed(1, 100), "", 0.7, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTextData(tt.data, tt.contentType, tt.threshold, tt.sampleBytes); got != tt.want {
+				t.Errorf("isTextData(%d bytes, %q, %v, %d) = %v, want %v", len(tt.data), tt.contentType, tt.threshold, tt.sampleBytes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreRequestDetailAndDelete(t *testing.T) {
+	setupTestDB(t)
+	stored, err := store.LogRequest(testRequestLogs(1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := store.GetRequestDetail(stored.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL != stored.URL || req.RequestBodySize != len(stored.RequestBody) || req.Origin != originProxy {
+		t.Errorf("GetRequestDetail = %q with a %d byte body from %q, want %q with %d bytes from %q",
+			req.URL, req.RequestBodySize, req.Origin, stored.URL, len(stored.RequestBody), originProxy)
+	}
+
+	if err := store.DeleteRequest(stored.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetRequestDetail(stored.ID); err != sql.ErrNoRows {
+		t.Errorf("GetRequestDetail after DeleteRequest: err = %v, want sql.ErrNoRows", err)
+	}
+	if err := store.DeleteRequest(stored.ID); err != sql.ErrNoRows {
+		t.Errorf("second DeleteRequest: err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Stages of the handling decisions recorded with each request
+const (
+	decisionRoute       = "route"          // Listener and resolved upstream
+	decisionRateLimit   = "rate_limit"     // -max-concurrent rejected the request
+	decisionBlock       = "block"          // The gateway refused the request
+	decisionHeaderSize  = "header_size"    // -header-size-warn flagged the request
+	decisionStatus      = "status_rewrite" // -map-status changed the client status
+	decisionHeaders     = "headers"        // -header-rules edited request or response headers
+	decisionBody        = "body"           // How a body was transformed on its way through
+	decisionRecord      = "record"         // Why the exchange was recorded
+	decisionBodyCapture = "body_capture"   // Why a stored body was dropped
+	decisionMask        = "mask"           // Which body masks matched
+	decisionAnomaly     = "anomaly"        // The response was an outlier
+	decisionUpstream    = "upstream"       // The upstream could not be reached or timed out
+)
+
+// decision is one step of the trace returned by the explain endpoint
+type decision struct {
+	Stage  string `json:"stage"`
+	Detail string `json:"detail"`
+}
+
+// addDecision appends a handling decision to the entry's trace
+func (l *RequestLog) addDecision(stage, format string, args ...interface{}) {
+	l.Decisions = append(l.Decisions, decision{Stage: stage, Detail: fmt.Sprintf(format, args...)})
+}
+
+// decisionsToJSON encodes a trace for the decisions column
+func decisionsToJSON(decisions []decision) string {
+	if len(decisions) == 0 {
+		return "[]"
+	}
+	encoded, err := json.Marshal(decisions)
+	if err != nil {
+		log.Printf("Error marshalling decisions to JSON: %v", err)
+		return "[]"
+	}
+	return string(encoded)
+}
+
+// upstreamURL returns the URL a request is forwarded to, joining the target
+// and request paths and queries the way httputil's single host proxy does
+func upstreamURL(target *url.URL, r *http.Request) string {
+	resolved := *target
+	resolved.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(r.URL.Path, "/")
+	resolved.RawPath = ""
+	if target.RawQuery == "" || r.URL.RawQuery == "" {
+		resolved.RawQuery = target.RawQuery + r.URL.RawQuery
+	} else {
+		resolved.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+	}
+	return resolved.String()
+}
+
+// explainRequestHandler handles GET /api/requests/{id}/explain, returning the
+// decisions recorded while the request was handled. Requests captured before
+// decisions were recorded have an empty trace.
+func explainRequestHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var explanation struct {
+		ID               int        `json:"id"`
+		Method           string     `json:"method"`
+		URL              string     `json:"url"`
+		ListenerPort     int        `json:"listener_port"`
+		Upstream         string     `json:"upstream,omitempty"`
+		UpstreamURL      string     `json:"upstream_url,omitempty"`
+		HandledBy        string     `json:"handled_by"`
+		StatusCode       int        `json:"status_code"`
+		ClientStatusCode int        `json:"client_status_code"`
+		Decisions        []decision `json:"decisions"`
+	}
+	var decisionsJSON string
+	err := db.QueryRow("SELECT id, method, url, COALESCE(listener_port, 0), COALESCE(upstream, ''), COALESCE(upstream_url, ''), COALESCE(handled_by, 'upstream'), status_code, COALESCE(client_status_code, status_code), COALESCE(decisions, '[]') FROM requests WHERE id = ?", id).Scan(
+		&explanation.ID, &explanation.Method, &explanation.URL, &explanation.ListenerPort, &explanation.Upstream, &explanation.UpstreamURL,
+		&explanation.HandledBy, &explanation.StatusCode, &explanation.ClientStatusCode, &decisionsJSON)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch request", http.StatusInternalServerError)
+		log.Printf("Error fetching request for explain: %v", err)
+		return
+	}
+
+	explanation.Decisions = []decision{}
+	if err := json.Unmarshal([]byte(decisionsJSON), &explanation.Decisions); err != nil {
+		log.Printf("Error unmarshalling decisions of request %d: %v", id, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(explanation)
+}
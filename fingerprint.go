@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// fingerprintAlgorithm names the hash and input layout of fingerprints; it
+// changes whenever requestFingerprint does so stale baselines are detectable
+const fingerprintAlgorithm = "sha256-v1"
+
+// requestFingerprint returns the line one request contributes to a capture
+// fingerprint. Fields are NUL separated and each request ends with a newline, so no two
+// different captures produce the same input.
+func requestFingerprint(method, url string, statusCode int, requestBody, responseBody []byte) string {
+	requestHash := sha256.Sum256(requestBody)
+	responseHash := sha256.Sum256(responseBody)
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\n", method, url, statusCode, hex.EncodeToString(requestHash[:]), hex.EncodeToString(responseHash[:]))
+}
+
+// fingerprintRequestsHandler handles GET /api/requests/fingerprint, hashing
+// every request matching the list filters into one stable fingerprint that
+// changes whenever a request, its status or a body does
+func fingerprintRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	where, args := buildRequestFilter(r.URL.Query())
+	rows, err := db.Query("SELECT method, url, status_code, request_body, response_body FROM requests WHERE 1=1"+where+" ORDER BY id", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
+		log.Printf("Error fetching requests for fingerprint: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	// Rows are hashed one at a time so memory use stays bounded
+	hash := sha256.New()
+	count := 0
+	for rows.Next() {
+		var method, url string
+		var statusCode int
+		var requestBody, responseBody []byte
+		if err := rows.Scan(&method, &url, &statusCode, &requestBody, &responseBody); err != nil {
+			// Skipping a row would silently change the fingerprint
+			http.Error(w, "Failed to read requests", http.StatusInternalServerError)
+			log.Printf("Error scanning request for fingerprint: %v", err)
+			return
+		}
+		hash.Write([]byte(requestFingerprint(method, url, statusCode, requestBody, responseBody)))
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to read requests", http.StatusInternalServerError)
+		log.Printf("Error iterating requests for fingerprint: %v", err)
+		return
+	}
+
+	response := struct {
+		Fingerprint string `json:"fingerprint"`
+		Algorithm   string `json:"algorithm"`
+		Count       int    `json:"count"`
+	}{
+		Fingerprint: hex.EncodeToString(hash.Sum(nil)),
+		Algorithm:   fingerprintAlgorithm,
+		Count:       count,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
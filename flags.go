@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// multiFlag is a flag.Value that collects every occurrence of a repeatable flag
+type multiFlag []string
+
+func (f *multiFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *multiFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
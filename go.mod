@@ -3,7 +3,12 @@ module dgateway
 go 1.19
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/google/uuid v1.3.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.11.0
+	golang.org/x/text v0.13.0
+	google.golang.org/protobuf v1.33.0
 	modernc.org/sqlite v1.20.0
 )
 
@@ -11,9 +16,9 @@ require (
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
-	golang.org/x/mod v0.3.0 // indirect
-	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
-	golang.org/x/tools v0.0.0-20201124115921-2c860bdd6e78 // indirect
+	golang.org/x/mod v0.8.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	lukechampine.com/uint128 v1.2.0 // indirect
 	modernc.org/cc/v3 v3.40.0 // indirect
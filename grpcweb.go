@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	grpcWebCompressedFlag = 0x01 // Frame payload is compressed with the grpc-encoding
+	grpcWebTrailerFlag    = 0x80 // Frame carries the trailers instead of a message
+)
+
+// grpcWebContentType reports whether the content type denotes a gRPC-Web body
+// and whether it uses the base64 (-text) variant of the framing
+func grpcWebContentType(contentType string) (isGRPCWeb, isText bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, false
+	}
+	switch {
+	case mediaType == "application/grpc-web-text" || strings.HasPrefix(mediaType, "application/grpc-web-text+"):
+		return true, true
+	case mediaType == "application/grpc-web" || strings.HasPrefix(mediaType, "application/grpc-web+"):
+		return true, false
+	}
+	return false, false
+}
+
+// decodeGRPCWebText decodes a grpc-web-text body. Each message may have been
+// base64 encoded separately, so padded chunks are decoded one at a time.
+func decodeGRPCWebText(body []byte) ([]byte, error) {
+	text := strings.Join(strings.Fields(string(body)), "")
+	var decoded []byte
+	for text != "" {
+		end := strings.IndexByte(text, '=')
+		if end < 0 {
+			end = len(text)
+		} else {
+			for end < len(text) && text[end] == '=' {
+				end++
+			}
+		}
+		chunk, err := base64.StdEncoding.DecodeString(text[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid grpc-web-text base64: %v", err)
+		}
+		decoded = append(decoded, chunk...)
+		text = text[end:]
+	}
+	return decoded, nil
+}
+
+// grpcWebFrame is the display form of one length-prefixed gRPC-Web frame
+type grpcWebFrame struct {
+	Type        string            `json:"type"` // "message" or "trailers"
+	Compressed  bool              `json:"compressed,omitempty"`
+	Message     json.RawMessage   `json:"message,omitempty"`     // Decoded with the protobuf descriptor
+	Data        []byte            `json:"data_base64,omitempty"` // Raw payload when it could not be decoded
+	DecodeError string            `json:"decode_error,omitempty"`
+	Trailers    map[string]string `json:"trailers,omitempty"`
+}
+
+// parseGRPCWebFrames splits gRPC-Web framed data into frames, decoding
+// messages of messageType to JSON when a type and descriptor are available
+func parseGRPCWebFrames(data []byte, messageType string) ([]grpcWebFrame, error) {
+	frames := []grpcWebFrame{}
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return frames, fmt.Errorf("truncated frame header (%d bytes)", len(data))
+		}
+		flags := data[0]
+		length := binary.BigEndian.Uint32(data[1:5])
+		if uint64(length) > uint64(len(data)-5) {
+			return frames, fmt.Errorf("truncated frame: %d bytes declared, %d available", length, len(data)-5)
+		}
+		payload := data[5 : 5+length]
+		data = data[5+length:]
+
+		if flags&grpcWebTrailerFlag != 0 {
+			frames = append(frames, grpcWebFrame{Type: "trailers", Trailers: parseGRPCWebTrailers(payload)})
+			continue
+		}
+
+		frame := grpcWebFrame{Type: "message", Compressed: flags&grpcWebCompressedFlag != 0}
+		message := payload
+		if frame.Compressed {
+			// gzip is the only grpc-encoding in common use by gRPC-Web clients
+			reader, err := gzip.NewReader(bytes.NewReader(payload))
+			if err == nil {
+				message, err = ioutil.ReadAll(reader)
+			}
+			if err != nil {
+				frame.Data = payload
+				frame.DecodeError = fmt.Sprintf("failed to decompress frame: %v", err)
+				frames = append(frames, frame)
+				continue
+			}
+		}
+
+		if messageType != "" && protoFiles != nil {
+			decoded, err := decodeProtobufToJSON(message, messageType)
+			if err == nil {
+				frame.Message = decoded
+				frames = append(frames, frame)
+				continue
+			}
+			frame.DecodeError = err.Error()
+		}
+		frame.Data = message
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// parseGRPCWebTrailers parses the HTTP/1-style header block of a trailer frame
+func parseGRPCWebTrailers(payload []byte) map[string]string {
+	trailers := make(map[string]string)
+	for _, line := range strings.Split(string(payload), "\n") {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		trailers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return trailers
+}
+
+// grpcMethodMessageType resolves the input or output message type of the gRPC
+// method named by a /package.Service/Method URL path using the loaded descriptor
+func grpcMethodMessageType(rawURL string, isResponse bool) string {
+	if protoFiles == nil {
+		return ""
+	}
+	path := rawURL
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		path = parsedURL.Path
+	}
+	path = strings.Trim(path, "/")
+	idx := strings.LastIndexByte(path, '/')
+	if idx < 0 {
+		return ""
+	}
+	service, method := path[:idx], path[idx+1:]
+	// Allow a routing prefix in front of the service, e.g. /grpc/pkg.Service/Method
+	if idx := strings.LastIndexByte(service, '/'); idx >= 0 {
+		service = service[idx+1:]
+	}
+
+	desc, err := protoFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return ""
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return ""
+	}
+	methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		return ""
+	}
+	if isResponse {
+		return string(methodDesc.Output().FullName())
+	}
+	return string(methodDesc.Input().FullName())
+}
+
+// maybeDecodeGRPCWeb renders a stored gRPC-Web body as JSON listing its
+// frames. Messages are decoded when a protobuf descriptor is loaded and the
+// type is known from proto_type, -proto-map or the gRPC method in the URL;
+// otherwise their payloads are shown as base64. It returns false when the
+// body is not gRPC-Web.
+func maybeDecodeGRPCWeb(r *http.Request, body []byte, contentType, rawURL string, isResponse bool) ([]byte, bool) {
+	isGRPCWeb, isText := grpcWebContentType(contentType)
+	if !isGRPCWeb || len(body) == 0 {
+		return nil, false
+	}
+
+	data := body
+	if isText {
+		decoded, err := decodeGRPCWebText(body)
+		if err != nil {
+			log.Printf("Error decoding gRPC-Web body: %v", err)
+			return nil, false
+		}
+		data = decoded
+	}
+
+	messageType := r.URL.Query().Get("proto_type")
+	if messageType == "" {
+		messageType = protoMessageType(contentType, rawURL, isResponse)
+	}
+	if messageType == "" {
+		messageType = grpcMethodMessageType(rawURL, isResponse)
+	}
+
+	result := struct {
+		MessageType string         `json:"message_type,omitempty"`
+		Frames      []grpcWebFrame `json:"frames"`
+		Error       string         `json:"error,omitempty"`
+	}{MessageType: messageType}
+	frames, err := parseGRPCWebFrames(data, messageType)
+	result.Frames = frames
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	encoded, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding gRPC-Web frames: %v", err)
+		return nil, false
+	}
+	return encoded, true
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -43,6 +48,7 @@ type HAREntry struct {
 	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
 	Connection      string      `json:"connection,omitempty"`
 	Comment         string      `json:"comment,omitempty"`
+	TLSSNI          string      `json:"_tlsSni,omitempty"` // Custom field: TLS server name sent by the client
 }
 
 // HARRequest represents the request part of an entry
@@ -97,6 +103,7 @@ type HARPostData struct {
 	MimeType string             `json:"mimeType"`
 	Text     string             `json:"text,omitempty"`
 	Params   []HARPostDataParam `json:"params,omitempty"`
+	Encoding string             `json:"encoding,omitempty"` // Custom field: "base64" for binary bodies, as in HARContent
 	Comment  string             `json:"comment,omitempty"`
 }
 
@@ -122,12 +129,12 @@ type HARContent struct {
 // HARTimings represents timing information
 type HARTimings struct {
 	Blocked int64  `json:"blocked,omitempty"`
-	DNS     int64  `json:"dns,omitempty"`
-	Connect int64  `json:"connect,omitempty"`
+	DNS     int64  `json:"dns"`
+	Connect int64  `json:"connect"`
 	Send    int64  `json:"send"`
 	Wait    int64  `json:"wait"`
 	Receive int64  `json:"receive"`
-	SSL     int64  `json:"ssl,omitempty"`
+	SSL     int64  `json:"ssl"`
 	Comment string `json:"comment,omitempty"`
 }
 
@@ -147,142 +154,228 @@ type HARPageTimings struct {
 	Comment       string `json:"comment,omitempty"`
 }
 
-// exportRequestsToHAR exports requests to HAR format
-func exportRequestsToHAR(requests []RequestLog) (*HAR, error) {
-	har := &HAR{
-		Log: HARLog{
-			Version: "1.2",
-			Creator: HARCreator{
-				Name:    "dGateway",
-				Version: "1.0",
-			},
-			Entries: make([]HAREntry, len(requests)),
-		},
+// harHTTPVersion returns the first recorded protocol, or HTTP/1.1 for entries
+// recorded before protocols were stored. Gateway-generated responses have no
+// upstream protocol and fall back to the request's.
+func harHTTPVersion(protos ...string) string {
+	for _, proto := range protos {
+		if proto != "" {
+			return proto
+		}
 	}
+	return "HTTP/1.1"
+}
+
+// harText returns a body as HAR text, base64-encoding binary bodies so they
+// survive the JSON encoding unchanged
+func harText(body []byte, mimeType string) (string, string) {
+	if isTextData(body, mimeType, textThreshold, textSampleBytes) && utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
 
-	// Create a single page for all entries
+// newHARLog returns the log of a HAR export without its entries, with a
+// single page that the entries refer to
+func newHARLog() (HARLog, string) {
 	pageID := uuid.New().String()
-	har.Log.Pages = []HARPage{
-		{
-			StartedDateTime: time.Now(),
-			ID:              pageID,
-			Title:           "dGateway Export",
-			PageTimings:     HARPageTimings{},
+	return HARLog{
+		Version: "1.2",
+		Creator: HARCreator{
+			Name:    "dGateway",
+			Version: "1.0",
 		},
+		Entries: []HAREntry{},
+		Pages: []HARPage{
+			{
+				StartedDateTime: time.Now(),
+				ID:              pageID,
+				Title:           "dGateway Export",
+				PageTimings:     HARPageTimings{},
+			},
+		},
+	}, pageID
+}
+
+// harEntry converts a request log to a HAR entry of the page pageID
+func harEntry(req RequestLog, pageID string) (HAREntry, error) {
+	// Parse request headers
+	var reqHeaders http.Header
+	if err := json.Unmarshal([]byte(req.RequestHeaders), &reqHeaders); err != nil {
+		return HAREntry{}, fmt.Errorf("failed to parse request headers for request %d: %v", req.ID, err)
 	}
 
-	for i, req := range requests {
-		// Parse request headers
-		var reqHeaders http.Header
-		if err := json.Unmarshal([]byte(req.RequestHeaders), &reqHeaders); err != nil {
-			return nil, fmt.Errorf("failed to parse request headers for request %d: %v", req.ID, err)
-		}
+	// Parse response headers
+	var respHeaders http.Header
+	if err := json.Unmarshal([]byte(req.ResponseHeaders), &respHeaders); err != nil {
+		return HAREntry{}, fmt.Errorf("failed to parse response headers for request %d: %v", req.ID, err)
+	}
 
-		// Parse response headers
-		var respHeaders http.Header
-		if err := json.Unmarshal([]byte(req.ResponseHeaders), &respHeaders); err != nil {
-			return nil, fmt.Errorf("failed to parse response headers for request %d: %v", req.ID, err)
+	// Convert request headers to HAR format
+	var harReqHeaders []HARNameValuePair
+	for name, values := range reqHeaders {
+		for _, value := range values {
+			harReqHeaders = append(harReqHeaders, HARNameValuePair{
+				Name:  name,
+				Value: value,
+			})
 		}
+	}
 
-		// Convert request headers to HAR format
-		var harReqHeaders []HARNameValuePair
-		for name, values := range reqHeaders {
-			for _, value := range values {
-				harReqHeaders = append(harReqHeaders, HARNameValuePair{
-					Name:  name,
-					Value: value,
-				})
-			}
+	// Convert response headers to HAR format
+	var harRespHeaders []HARNameValuePair
+	for name, values := range respHeaders {
+		for _, value := range values {
+			harRespHeaders = append(harRespHeaders, HARNameValuePair{
+				Name:  name,
+				Value: value,
+			})
 		}
+	}
 
-		// Convert response headers to HAR format
-		var harRespHeaders []HARNameValuePair
-		for name, values := range respHeaders {
+	// Convert query parameters
+	var queryString []HARNameValuePair
+	if parsedURL, err := url.Parse(req.URL); err == nil {
+		queryParams := parsedURL.Query()
+		for name, values := range queryParams {
 			for _, value := range values {
-				harRespHeaders = append(harRespHeaders, HARNameValuePair{
+				queryString = append(queryString, HARNameValuePair{
 					Name:  name,
 					Value: value,
 				})
 			}
 		}
+	}
 
-		// Convert query parameters
-		var queryString []HARNameValuePair
-		if parsedURL, err := url.Parse(req.URL); err == nil {
-			queryParams := parsedURL.Query()
-			for name, values := range queryParams {
-				for _, value := range values {
-					queryString = append(queryString, HARNameValuePair{
-						Name:  name,
-						Value: value,
-					})
-				}
-			}
+	// Prepare request post data if exists
+	var postData *HARPostData
+	if len(req.RequestBody) > 0 {
+		mimeType := "application/octet-stream"
+		if contentType := reqHeaders.Get("Content-Type"); contentType != "" {
+			mimeType = contentType
 		}
 
-		// Prepare request post data if exists
-		var postData *HARPostData
-		if len(req.RequestBody) > 0 {
-			mimeType := "application/octet-stream"
-			if contentType := reqHeaders.Get("Content-Type"); contentType != "" {
-				mimeType = contentType
-			}
+		postData = &HARPostData{MimeType: mimeType}
+		postData.Text, postData.Encoding = harText(req.RequestBody, mimeType)
+	}
 
-			postData = &HARPostData{
-				MimeType: mimeType,
-				Text:     string(req.RequestBody),
-			}
-		}
+	// Prepare response content
+	mimeType := "application/octet-stream"
+	if contentType := respHeaders.Get("Content-Type"); contentType != "" {
+		mimeType = contentType
+	}
 
-		// Prepare response content
-		mimeType := "application/octet-stream"
-		if contentType := respHeaders.Get("Content-Type"); contentType != "" {
-			mimeType = contentType
-		}
+	content := HARContent{
+		Size:     int64(len(req.ResponseBody)),
+		MimeType: mimeType,
+	}
+	content.Text, content.Encoding = harText(req.ResponseBody, mimeType)
+	// Compression is the number of bytes saved by the upstream's content encoding
+	if req.ResponseWireSize > 0 && req.ResponseWireSize < len(req.ResponseBody) {
+		content.Compression = int64(len(req.ResponseBody) - req.ResponseWireSize)
+	}
+
+	entryTime, timings := harTimings(req)
+
+	return HAREntry{
+		Pageref:         pageID,
+		StartedDateTime: req.Timestamp,
+		Time:            entryTime,
+		Request: HARRequest{
+			Method:      req.Method,
+			URL:         req.URL,
+			HTTPVersion: harHTTPVersion(req.RequestProto),
+			Cookies:     []HARCookie{}, // We don't track cookies
+			Headers:     harReqHeaders,
+			QueryString: queryString,
+			PostData:    postData,
+			HeadersSize: int64(len(req.RequestHeaders)),
+			BodySize:    int64(len(req.RequestBody)),
+		},
+		Response: HARResponse{
+			Status:      req.StatusCode,
+			StatusText:  http.StatusText(req.StatusCode),
+			HTTPVersion: harHTTPVersion(req.ResponseProto, req.RequestProto),
+			Cookies:     []HARCookie{}, // We don't track cookies
+			Headers:     harRespHeaders,
+			Content:     content,
+			RedirectURL: "",
+			HeadersSize: int64(len(req.ResponseHeaders)),
+			BodySize:    int64(len(req.ResponseBody)),
+		},
+		Cache:   interface{}(struct{}{}), // Empty cache object
+		TLSSNI:  req.TLSSNI,
+		Timings: timings,
+	}, nil
+}
 
-		content := HARContent{
-			Size:     int64(len(req.ResponseBody)),
-			MimeType: mimeType,
-			Text:     string(req.ResponseBody),
+// streamHAR writes the requests matching the given WHERE conditions as a HAR
+// file, converting and writing one entry at a time rather than loading them
+// all, so large databases can be exported in constant memory. Rows that
+// cannot be converted are logged and skipped, and the entries array is closed
+// even when reading the database fails midway, so the output is valid HAR
+// once started. started tells whether anything was written before an error,
+// after which the output lacks entries.
+func streamHAR(w io.Writer, where string, args ...interface{}) (started bool, err error) {
+	harLog, pageID := newHARLog()
+	skeleton, err := json.MarshalIndent(HAR{Log: harLog}, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	// The entries are written between the halves of the empty entries array
+	head, tail, _ := bytes.Cut(skeleton, []byte(`"entries": []`))
+
+	// Nothing is written until the first entry, so a database error before it
+	// can still be answered with an error status
+	begin := func() error {
+		if started {
+			return nil
 		}
+		started = true
+		_, err := w.Write(append(head, `"entries": [`...))
+		return err
+	}
 
-		// Create HAR entry
-		entry := HAREntry{
-			Pageref:         pageID,
-			StartedDateTime: req.Timestamp,
-			Time:            0, // We don't have timing information
-			Request: HARRequest{
-				Method:      req.Method,
-				URL:         req.URL,
-				HTTPVersion: "HTTP/1.1",
-				Cookies:     []HARCookie{}, // We don't track cookies
-				Headers:     harReqHeaders,
-				QueryString: queryString,
-				PostData:    postData,
-				HeadersSize: int64(len(req.RequestHeaders)),
-				BodySize:    int64(len(req.RequestBody)),
-			},
-			Response: HARResponse{
-				Status:      req.StatusCode,
-				StatusText:  http.StatusText(req.StatusCode),
-				HTTPVersion: "HTTP/1.1",
-				Cookies:     []HARCookie{}, // We don't track cookies
-				Headers:     harRespHeaders,
-				Content:     content,
-				RedirectURL: "",
-				HeadersSize: int64(len(req.ResponseHeaders)),
-				BodySize:    int64(len(req.ResponseBody)),
-			},
-			Cache: interface{}(struct{}{}), // Empty cache object
-			Timings: HARTimings{
-				Send:    0,
-				Wait:    0,
-				Receive: 0,
-			},
+	count := 0
+	writeEntry := func(encoded []byte) error {
+		if err := begin(); err != nil {
+			return err
+		}
+		separator := ",\n      "
+		if count == 0 {
+			separator = "\n      "
 		}
+		count++
+		_, err := w.Write(append([]byte(separator), encoded...))
+		return err
+	}
 
-		har.Log.Entries[i] = entry
+	// Only write errors end the iteration
+	err = eachRequestLog(where, args, func(req RequestLog) error {
+		entry, err := harEntry(req, pageID)
+		if err == nil {
+			var encoded []byte
+			if encoded, err = json.MarshalIndent(entry, "      ", "  "); err == nil {
+				return writeEntry(encoded)
+			}
+		}
+		log.Printf("Skipping request %d in HAR export: %v", req.ID, err)
+		return nil
+	})
+	if err != nil && !started {
+		return false, err
 	}
 
-	return har, nil
+	// Close the document even after a failure, so what was written stays valid
+	if beginErr := begin(); beginErr != nil {
+		return started, beginErr
+	}
+	closing := "]"
+	if count > 0 {
+		closing = "\n    ]"
+	}
+	if _, closeErr := w.Write(append(append([]byte(closing), tail...), '\n')); err == nil {
+		err = closeErr
+	}
+	return started, err
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// harImport is the part of a HAR file read by the importer. Entries are
+// decoded separately from the export types because browsers write times as
+// fractional milliseconds and fields the importer does not need may not
+// follow the spec.
+type harImport struct {
+	Log struct {
+		Version string           `json:"version"`
+		Entries []harImportEntry `json:"entries"`
+	} `json:"log"`
+}
+
+// harImportEntry is a request/response pair of an imported HAR file
+type harImportEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"` // Total time in milliseconds
+	Request         struct {
+		Method      string             `json:"method"`
+		URL         string             `json:"url"`
+		HTTPVersion string             `json:"httpVersion"`
+		Headers     []HARNameValuePair `json:"headers"`
+		PostData    *HARPostData       `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status      int                `json:"status"`
+		HTTPVersion string             `json:"httpVersion"`
+		Headers     []HARNameValuePair `json:"headers"`
+		Content     HARContent         `json:"content"`
+	} `json:"response"`
+	Timings struct {
+		DNS     *float64 `json:"dns"` // Absent or -1 when unknown
+		Connect *float64 `json:"connect"`
+		SSL     *float64 `json:"ssl"`
+		Send    *float64 `json:"send"`
+		Wait    *float64 `json:"wait"`
+	} `json:"timings"`
+	TLSSNI string `json:"_tlsSni"`
+}
+
+// harPhaseMs converts an optional HAR timing to milliseconds, -1 when absent
+// or not applicable
+func harPhaseMs(value *float64) int64 {
+	if value == nil || *value < 0 {
+		return -1
+	}
+	return int64(*value)
+}
+
+// harHeadersToJSON converts HAR header pairs to the stored http.Header JSON
+// form, skipping HTTP/2 pseudo-headers such as :authority
+func harHeadersToJSON(pairs []HARNameValuePair) string {
+	header := make(http.Header)
+	for _, pair := range pairs {
+		if strings.HasPrefix(pair.Name, ":") {
+			continue
+		}
+		header.Add(pair.Name, pair.Value)
+	}
+	return HeadersToJSON(header)
+}
+
+// isHTTPToken reports whether s is a valid HTTP method, an RFC 7230 token
+func isHTTPToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c > unicode.MaxASCII || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}\x7f", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLog converts the entry to a log entry
+func (entry harImportEntry) requestLog() (RequestLog, error) {
+	if entry.Request.Method == "" || entry.Request.URL == "" {
+		return RequestLog{}, fmt.Errorf("request without method or URL")
+	}
+	// Imported requests end up in exported scripts, so a method or URL that
+	// could never have been proxied is refused rather than stored
+	if !isHTTPToken(entry.Request.Method) {
+		return RequestLog{}, fmt.Errorf("invalid method %q", entry.Request.Method)
+	}
+	if strings.IndexFunc(entry.Request.URL, unicode.IsControl) >= 0 {
+		return RequestLog{}, fmt.Errorf("URL %q contains control characters", entry.Request.URL)
+	}
+	if _, err := url.Parse(entry.Request.URL); err != nil {
+		return RequestLog{}, fmt.Errorf("invalid URL: %v", err)
+	}
+
+	reqLog := RequestLog{
+		Timestamp:       entry.StartedDateTime.Local(), // Stored like the times of proxied requests
+		Method:          entry.Request.Method,
+		URL:             entry.Request.URL,
+		RequestHeaders:  harHeadersToJSON(entry.Request.Headers),
+		StatusCode:      entry.Response.Status,
+		ResponseHeaders: harHeadersToJSON(entry.Response.Headers),
+		HandledBy:       handledByImport,
+		Origin:          originImport,
+		TLSSNI:          entry.TLSSNI,
+		RequestProto:    entry.Request.HTTPVersion,
+		ResponseProto:   entry.Response.HTTPVersion,
+		SendMs:          -1,
+		TTFBMs:          -1,
+		DNSMs:           harPhaseMs(entry.Timings.DNS),
+		ConnectMs:       harPhaseMs(entry.Timings.Connect),
+		TLSMs:           harPhaseMs(entry.Timings.SSL),
+	}
+	if reqLog.Timestamp.IsZero() {
+		reqLog.Timestamp = time.Now()
+	}
+	reqLog.duration = time.Duration(entry.Time * float64(time.Millisecond))
+	if send, wait := entry.Timings.Send, entry.Timings.Wait; send != nil && wait != nil && *send >= 0 && *wait >= 0 {
+		// Stored send and TTFB times count from the start, including the
+		// connection phases HAR lists separately; its connect includes ssl
+		reqLog.SendMs = int64(*send)
+		if reqLog.DNSMs >= 0 {
+			reqLog.SendMs += reqLog.DNSMs
+		}
+		if reqLog.ConnectMs >= 0 {
+			reqLog.SendMs += reqLog.ConnectMs
+		}
+		reqLog.TTFBMs = reqLog.SendMs + int64(*wait)
+	}
+	if reqLog.ConnectMs >= 0 && reqLog.TLSMs > 0 && reqLog.TLSMs <= reqLog.ConnectMs {
+		reqLog.ConnectMs -= reqLog.TLSMs
+	}
+
+	// Form posts may only list their parameters
+	if postData := entry.Request.PostData; postData != nil {
+		if postData.Encoding == "base64" {
+			body, err := base64.StdEncoding.DecodeString(postData.Text)
+			if err != nil {
+				return RequestLog{}, fmt.Errorf("invalid base64 request body: %v", err)
+			}
+			reqLog.RequestBody = body
+		} else if postData.Text != "" {
+			reqLog.RequestBody = []byte(postData.Text)
+		} else if len(postData.Params) > 0 {
+			form := url.Values{}
+			for _, param := range postData.Params {
+				form.Add(param.Name, param.Value)
+			}
+			reqLog.RequestBody = []byte(form.Encode())
+		}
+	}
+
+	content := entry.Response.Content
+	if content.Encoding == "base64" {
+		body, err := base64.StdEncoding.DecodeString(content.Text)
+		if err != nil {
+			return RequestLog{}, fmt.Errorf("invalid base64 response content: %v", err)
+		}
+		reqLog.ResponseBody = body
+	} else {
+		reqLog.ResponseBody = []byte(content.Text)
+	}
+
+	reqLog.addDecision(decisionRecord, "imported from a HAR file")
+	return reqLog, nil
+}
+
+// importHARHandler handles POST /api/import/har, storing every entry of the
+// HAR file sent as the body as a recorded request
+func importHARHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var har harImport
+	if err := json.NewDecoder(r.Body).Decode(&har); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid HAR file: %v", err), http.StatusBadRequest)
+		return
+	}
+	if har.Log.Version != "1.1" && har.Log.Version != "1.2" {
+		http.Error(w, fmt.Sprintf("Unsupported HAR version %q, expected 1.1 or 1.2", har.Log.Version), http.StatusBadRequest)
+		return
+	}
+
+	imported, skipped := 0, 0
+	for i, entry := range har.Log.Entries {
+		reqLog, err := entry.requestLog()
+		if err != nil {
+			log.Printf("Skipping HAR entry %d: %v", i, err)
+			skipped++
+			continue
+		}
+		if _, err := store.LogRequest(reqLog); err != nil {
+			skipped++
+			continue
+		}
+		imported++
+	}
+	log.Printf("Imported %d HAR entries, skipped %d", imported, skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Imported int `json:"imported"`
+		Skipped  int `json:"skipped"`
+	}{imported, skipped})
+}
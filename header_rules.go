@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// headerEdits removes and then sets headers of one direction of an exchange
+type headerEdits struct {
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+// headerRules holds the header edits loaded with -header-rules
+type headerRules struct {
+	Request  headerEdits `json:"request"`
+	Response headerEdits `json:"response"`
+}
+
+// loadHeaderRules reads a JSON file such as
+// {"request": {"set": {"Authorization": "Bearer x"}}, "response": {"remove": ["Set-Cookie"]}}
+func loadHeaderRules(path string) (*headerRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules headerRules
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("invalid header rules file %s: %v", path, err)
+	}
+	for direction, edits := range map[string]headerEdits{"request": rules.Request, "response": rules.Response} {
+		for _, name := range edits.names() {
+			if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+				return nil, fmt.Errorf("invalid %s header name %q in %s", direction, name, path)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// String describes the rules, e.g. "request: set Authorization; response: removed Set-Cookie"
+func (rules *headerRules) String() string {
+	if rules == nil {
+		return ""
+	}
+	var parts []string
+	if !rules.Request.empty() {
+		parts = append(parts, "request: "+rules.Request.String())
+	}
+	if !rules.Response.empty() {
+		parts = append(parts, "response: "+rules.Response.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// names returns every header name the edits touch
+func (e headerEdits) names() []string {
+	names := append([]string(nil), e.Remove...)
+	for name := range e.Set {
+		names = append(names, name)
+	}
+	return names
+}
+
+// empty reports whether the edits change nothing
+func (e headerEdits) empty() bool {
+	return len(e.Set) == 0 && len(e.Remove) == 0
+}
+
+// apply edits the headers in place
+func (e headerEdits) apply(header http.Header) {
+	for _, name := range e.Remove {
+		header.Del(name)
+	}
+	for name, value := range e.Set {
+		header.Set(name, value)
+	}
+}
+
+// String describes the edits for the decision trace, e.g. "set Authorization, removed Cookie"
+func (e headerEdits) String() string {
+	var parts []string
+	if len(e.Set) > 0 {
+		set := make([]string, 0, len(e.Set))
+		for name := range e.Set {
+			set = append(set, http.CanonicalHeaderKey(name))
+		}
+		sort.Strings(set)
+		parts = append(parts, "set "+strings.Join(set, ", "))
+	}
+	if len(e.Remove) > 0 {
+		removed := make([]string, len(e.Remove))
+		for i, name := range e.Remove {
+			removed[i] = http.CanonicalHeaderKey(name)
+		}
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// applyRequestHeaderRules replaces the headers of a request about to be
+// proxied with an edited copy. It reports whether any rule applies.
+func (rules *ruleSet) applyRequestHeaderRules(r *http.Request) bool {
+	if rules.headerRules == nil || rules.headerRules.Request.empty() {
+		return false
+	}
+	r.Header = r.Header.Clone()
+	rules.headerRules.Request.apply(r.Header)
+	return true
+}
+
+// applyResponseHeaderRules edits the headers of an upstream response and
+// notes the change in the entry's trace
+func (rules *ruleSet) applyResponseHeaderRules(resp *http.Response, reqLog *RequestLog) {
+	if rules.headerRules == nil || rules.headerRules.Response.empty() {
+		return
+	}
+	rules.headerRules.Response.apply(resp.Header)
+	reqLog.addDecision(decisionHeaders, "-header-rules %s in the response", rules.headerRules.Response)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Timeouts of the checks behind /healthz and /readyz, short enough for
+// orchestrator probes
+const (
+	healthDBTimeout       = 2 * time.Second
+	healthUpstreamTimeout = 2 * time.Second
+)
+
+// healthStatus is the body of /healthz and /readyz. The probes are
+// unauthenticated, so failures are only logged, never described here.
+type healthStatus struct {
+	Status    string `json:"status"` // ok, or unavailable when /readyz fails
+	Recording bool   `json:"recording"`
+	DB        string `json:"db"`                 // ok or error
+	Upstream  string `json:"upstream,omitempty"` // ok or error, /readyz only
+}
+
+// checkHealth reports the recording state and pings the database
+func checkHealth(ctx context.Context) healthStatus {
+	status := healthStatus{Status: "ok", Recording: IsRecording, DB: "ok"}
+	ctx, cancel := context.WithTimeout(ctx, healthDBTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		status.DB = "error"
+		log.Printf("Health check: database ping failed: %v", err)
+	}
+	return status
+}
+
+// healthTargets returns the backends the main proxy forwards to: the -routes
+// targets, the -targets backends or the -target
+func healthTargets() []*url.URL {
+	if routes := currentRules().routes; routes != nil {
+		targets := make([]*url.URL, len(routes))
+		for i, route := range routes {
+			targets[i] = route.target
+		}
+		return targets
+	}
+	if upstreamTargets != nil {
+		targets := make([]*url.URL, len(upstreamTargets.targets))
+		for i, target := range upstreamTargets.targets {
+			targets[i] = target.URL
+		}
+		return targets
+	}
+	return []*url.URL{targetBaseURL()}
+}
+
+// checkUpstream sends a HEAD request to every backend of the proxy at once,
+// reporting whether all of them answer. Any HTTP response counts as
+// reachable, whatever its status.
+func checkUpstream(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, healthUpstreamTimeout)
+	defer cancel()
+
+	targets := healthTargets()
+	failed := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *url.URL) {
+			defer wg.Done()
+			if err := probeUpstream(ctx, target); err != nil {
+				failed[i] = true
+				log.Printf("Health check: upstream %s unreachable: %v", target, err)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	for _, f := range failed {
+		if f {
+			return false
+		}
+	}
+	return true
+}
+
+// probeUpstream sends a single HEAD request to a backend, through the
+// transport of the proxy without its retries
+func probeUpstream(ctx context.Context, target *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", target.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := upstreamProbeTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// writeHealth writes a health check result
+func writeHealth(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// healthzHandler handles GET /healthz, the unauthenticated liveness probe.
+// It answers 200 while the gateway runs, reporting whether the database
+// responds without failing on it.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, http.StatusOK, checkHealth(r.Context()))
+}
+
+// readyzHandler handles GET /readyz, the unauthenticated readiness probe. It
+// answers 503 unless both the database and every backend of the proxy respond.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := checkHealth(r.Context())
+	status.Upstream = "ok"
+	if !checkUpstream(r.Context()) {
+		status.Upstream = "error"
+	}
+
+	code := http.StatusOK
+	if status.DB != "ok" || status.Upstream != "ok" {
+		status.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, status)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// generateHTTPFile renders the requests in the .http format understood by the
+// VS Code REST Client and JetBrains HTTP Client, separated by ### lines
+func generateHTTPFile(requests []RequestLog) string {
+	var sb strings.Builder
+	for i, req := range requests {
+		targetURL, err := resolveTargetURL(req.URL, req.ListenerPort)
+		if err != nil {
+			targetURL = req.URL
+		}
+
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "### Request %d (original status %d, %s)\n", req.ID, req.StatusCode, req.Timestamp.Format("2006-01-02 15:04:05"))
+		fmt.Fprintf(&sb, "%s %s\n", req.Method, targetURL)
+		names, headers := scriptHeaders(req)
+		for _, name := range names {
+			for _, value := range headers[name] {
+				fmt.Fprintf(&sb, "%s: %s\n", name, value)
+			}
+		}
+
+		if len(req.RequestBody) > 0 {
+			sb.WriteString("\n")
+			if isTextData(req.RequestBody, getContentTypeFromHeaders(req.RequestHeaders), textThreshold, textSampleBytes) {
+				sb.Write(req.RequestBody)
+				if !strings.HasSuffix(string(req.RequestBody), "\n") {
+					sb.WriteString("\n")
+				}
+			} else {
+				// .http files cannot embed binary data inline
+				fmt.Fprintf(&sb, "# Binary body of %d bytes omitted; download it from /api/requests/body/request/%d\n", len(req.RequestBody), req.ID)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// sendHTTPFile sends the requests as a .http file download
+func sendHTTPFile(w http.ResponseWriter, requests []RequestLog, filename string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write([]byte(generateHTTPFile(requests)))
+}
+
+// getRequestHTTPFileHandler handles GET /api/requests/{id}/httpfile
+func getRequestHTTPFileHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, err := getRequestLogs(" AND id = ?", id)
+	if err != nil {
+		http.Error(w, "Failed to fetch request", http.StatusInternalServerError)
+		log.Printf("Error fetching request %d: %v", id, err)
+		return
+	}
+	if len(requests) == 0 {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	sendHTTPFile(w, requests, fmt.Sprintf("request-%d.http", id))
+}
+
+// exportHTTPFileHandler handles GET /api/export/httpfile, emitting every
+// request matching the list filters in timestamp order
+func exportHTTPFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, ok := filteredRequests(w, r)
+	if !ok {
+		return
+	}
+
+	sendHTTPFile(w, requests, "dgateway-requests.http")
+}
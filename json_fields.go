@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonFieldIndex extracts a value from request or response JSON bodies into a
+// dedicated column so it can be used to filter and sort the request list
+type jsonFieldIndex struct {
+	Source string        // "request" or "response"
+	Path   string        // JSONPath as given on the command line, e.g. $.user.id
+	Name   string        // Column suffix and query parameter name
+	steps  []interface{} // Parsed path: string object keys and int array indexes
+}
+
+// jsonFieldIndexes holds the fields configured with -index-json-field
+var jsonFieldIndexes []jsonFieldIndex
+
+var jsonFieldNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseJSONFieldIndex parses a "source:$.path=name" spec, e.g. response:$.userId=userId
+func parseJSONFieldIndex(spec string) (jsonFieldIndex, error) {
+	source, rest, ok := strings.Cut(spec, ":")
+	if !ok || (source != "request" && source != "response") {
+		return jsonFieldIndex{}, fmt.Errorf("invalid JSON field %q, expected request:$.path=name or response:$.path=name", spec)
+	}
+	path, name, ok := strings.Cut(rest, "=")
+	if !ok || !jsonFieldNamePattern.MatchString(name) {
+		return jsonFieldIndex{}, fmt.Errorf("invalid JSON field %q, name may only contain letters, digits and underscores", spec)
+	}
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return jsonFieldIndex{}, err
+	}
+	return jsonFieldIndex{Source: source, Path: path, Name: name, steps: steps}, nil
+}
+
+// parseJSONPath parses the simple JSONPath subset $.a.b[0].c into its steps
+func parseJSONPath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %q, must start with $", path)
+	}
+	var steps []interface{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSONPath %q, empty key", path)
+			}
+			steps = append(steps, key)
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q, unclosed [", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q, bad array index %q", path, rest[1:end])
+			}
+			steps = append(steps, index)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q, unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// column returns the name of the column holding the extracted values
+func (field jsonFieldIndex) column() string {
+	return "json_" + field.Name
+}
+
+// extract returns the value at the field's path in body. Strings are stored
+// as-is and other values as their JSON encoding; nil means no value. The
+// column has NUMERIC affinity so numbers still sort numerically; PostgreSQL
+// stores them as text, cast back by jsonFieldNumber and jsonFieldOrder.
+func (field jsonFieldIndex) extract(body []byte) interface{} {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+
+	for _, step := range field.steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = object[step]
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || step >= len(array) {
+				return nil
+			}
+			value = array[step]
+		}
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return value
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		return string(encoded)
+	}
+}
+
+// jsonFieldValues returns the derived columns of a log entry as an SQL column
+// list fragment (each prefixed with ", ") and the matching values
+func jsonFieldValues(logEntry RequestLog) (string, []interface{}) {
+	var columns string
+	var values []interface{}
+	for _, field := range jsonFieldIndexes {
+		body := logEntry.ResponseBody
+		if field.Source == "request" {
+			body = logEntry.RequestBody
+		}
+		columns += ", " + field.column()
+		values = append(values, field.extract(body))
+	}
+	return columns, values
+}
+
+// jsonFieldByName looks up a configured field by its name
+func jsonFieldByName(name string) (jsonFieldIndex, bool) {
+	for _, field := range jsonFieldIndexes {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return jsonFieldIndex{}, false
+}
+
+// requestListOrder returns the ORDER BY clause for the list "sort" parameter,
+// given as <key>_asc or <key>_desc where key is duration or json_<name>.
+// Defaults to newest first.
+func requestListOrder(query url.Values) string {
+	sortParam := query.Get("sort")
+	key, direction := sortParam, "ASC"
+	if strings.HasSuffix(sortParam, "_desc") {
+		key, direction = strings.TrimSuffix(sortParam, "_desc"), "DESC"
+	} else {
+		key = strings.TrimSuffix(sortParam, "_asc")
+	}
+
+	// Rows without a value come last either way, whatever the database
+	// sorts NULL as by default
+	if key == "duration" {
+		return "duration_ms " + direction + " NULLS LAST, timestamp DESC"
+	}
+	if name := strings.TrimPrefix(key, "json_"); name != key {
+		if field, ok := jsonFieldByName(name); ok {
+			return store.jsonFieldOrder(field.column(), direction) + ", timestamp DESC"
+		}
+	}
+	return "timestamp DESC"
+}
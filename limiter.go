@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// inFlightRequests counts proxied requests currently being served
+var inFlightRequests atomic.Int64
+
+// acquireSlot waits for a free concurrency slot, reporting false on timeout
+func (rules *ruleSet) acquireSlot() bool {
+	if rules.concurrencyLimiter == nil {
+		return true
+	}
+
+	select {
+	case rules.concurrencyLimiter <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(rules.concurrencyWait)
+	defer timer.Stop()
+	select {
+	case rules.concurrencyLimiter <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// releaseSlot frees a slot taken by acquireSlot on the same rule set
+func (rules *ruleSet) releaseSlot() {
+	if rules.concurrencyLimiter != nil {
+		<-rules.concurrencyLimiter
+	}
+}
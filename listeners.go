@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// proxyListener is an additional proxy port forwarding to its own target
+type proxyListener struct {
+	Port   int
+	Target *url.URL
+	Record *bool // Overrides the global recording switch when set
+}
+
+// proxyListeners holds the listeners configured with -listen
+var proxyListeners []proxyListener
+
+// parseProxyListener parses a "port=url[,attr=value...]" spec, e.g.
+// 8082=http://127.0.0.1:9002,record=false
+func parseProxyListener(spec string) (proxyListener, error) {
+	parts := strings.Split(spec, ",")
+	portStr, targetStr, ok := strings.Cut(parts[0], "=")
+	if !ok {
+		return proxyListener{}, fmt.Errorf("invalid listener %q, expected PORT=URL", spec)
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(portStr))
+	if err != nil || port <= 0 || port > 65535 {
+		return proxyListener{}, fmt.Errorf("invalid listener %q, bad port %q", spec, portStr)
+	}
+	target, err := url.Parse(strings.TrimSpace(targetStr))
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return proxyListener{}, fmt.Errorf("invalid listener %q, bad target URL %q", spec, targetStr)
+	}
+	listener := proxyListener{Port: port, Target: target}
+
+	for _, attr := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch key {
+		case "record":
+			record, err := strconv.ParseBool(value)
+			if err != nil {
+				return proxyListener{}, fmt.Errorf("invalid listener %q, bad record value %q", spec, value)
+			}
+			listener.Record = &record
+		default:
+			return proxyListener{}, fmt.Errorf("invalid listener %q, unknown attribute %q", spec, key)
+		}
+	}
+	return listener, nil
+}
+
+// isRecordingPort reports whether traffic received on port should be recorded,
+// applying the listener's record attribute over the global IsRecording
+func isRecordingPort(port int) bool {
+	for _, listener := range proxyListeners {
+		if listener.Port == port && listener.Record != nil {
+			return *listener.Record
+		}
+	}
+	return IsRecording
+}
+
+// isListenerPort reports whether port belongs to a -listen proxy
+func isListenerPort(port int) bool {
+	for _, listener := range proxyListeners {
+		if listener.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// targetForPort returns the target of the proxy listening on port, falling
+// back to -target for the main listener and unknown ports
+func targetForPort(port int) *url.URL {
+	for _, listener := range proxyListeners {
+		if listener.Port == port {
+			return listener.Target
+		}
+	}
+	return targetBaseURL()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamSubscriberBuffer is the number of entries queued for a stream client;
+// further entries are dropped for that client until it catches up
+const streamSubscriberBuffer = 64
+
+// streamHeartbeat is the interval of the comments keeping idle streams open
+const streamHeartbeat = 15 * time.Second
+
+// streamEvent is the summary of a recorded request pushed to stream clients.
+// Headers and bodies are fetched from the detail and body endpoints by ID.
+type streamEvent struct {
+	ID               int       `json:"id"`
+	Timestamp        time.Time `json:"timestamp"`
+	Method           string    `json:"method"`
+	URL              string    `json:"url"`
+	StatusCode       int       `json:"status_code"`
+	ClientStatusCode int       `json:"client_status_code"`
+	HandledBy        string    `json:"handled_by"`
+	Protocol         string    `json:"protocol"`
+	ListenerPort     int       `json:"listener_port"`
+	ClientIP         string    `json:"client_ip"`
+	DurationMs       int64     `json:"duration_ms"`
+	RequestBodySize  int       `json:"request_body_size"`
+	ResponseBodySize int       `json:"response_body_size"`
+	Anomaly          bool      `json:"anomaly"`
+}
+
+// streamSubscriber is one connected client of /api/stream
+type streamSubscriber struct {
+	events  chan streamEvent
+	dropped int // Events not queued because the buffer was full, guarded by streamMu
+}
+
+var (
+	streamMu          sync.Mutex
+	streamSubscribers = make(map[*streamSubscriber]bool)
+)
+
+// subscribeStream registers a new stream client
+func subscribeStream() *streamSubscriber {
+	sub := &streamSubscriber{events: make(chan streamEvent, streamSubscriberBuffer)}
+	streamMu.Lock()
+	streamSubscribers[sub] = true
+	streamMu.Unlock()
+	return sub
+}
+
+// unsubscribeStream removes a stream client, returning how many events it missed
+func unsubscribeStream(sub *streamSubscriber) int {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	delete(streamSubscribers, sub)
+	return sub.dropped
+}
+
+// publishRequest fans a stored log entry out to every stream client. It
+// never blocks: a client whose buffer is full misses the entry, so a slow
+// client cannot hold up the database writer.
+func publishRequest(logEntry RequestLog) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if len(streamSubscribers) == 0 {
+		return
+	}
+
+	event := streamEvent{
+		ID:               logEntry.ID,
+		Timestamp:        logEntry.Timestamp,
+		Method:           logEntry.Method,
+		URL:              logEntry.URL,
+		StatusCode:       logEntry.StatusCode,
+		ClientStatusCode: logEntry.ClientStatusCode,
+		HandledBy:        logEntry.HandledBy,
+		Protocol:         logEntry.Protocol,
+		ListenerPort:     logEntry.ListenerPort,
+		ClientIP:         logEntry.ClientIP,
+		DurationMs:       logEntry.DurationMs,
+		RequestBodySize:  logEntry.RequestBodySize,
+		ResponseBodySize: logEntry.ResponseBodySize,
+		Anomaly:          logEntry.Anomaly,
+	}
+	for sub := range streamSubscribers {
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// streamRequestsHandler handles GET /api/stream, pushing a summary of each
+// newly recorded request as a Server-Sent Event until the client disconnects
+func streamRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := subscribeStream()
+	defer func() {
+		if dropped := unsubscribeStream(sub); dropped > 0 {
+			log.Printf("Stream client %s disconnected, %d events dropped while it was behind", r.RemoteAddr, dropped)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx in front of the admin UI from buffering events
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-sub.events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error encoding stream event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: request\ndata: %s\n\n", event.ID, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
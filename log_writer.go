@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// logWriters are the goroutines storing the entries queued on
+// requestLogChan, each in batches of up to batchSize entries that are
+// flushed when full or flushInterval after the first entry arrived
+type logWriters struct {
+	batchSize     int
+	flushInterval time.Duration
+	stopping      chan struct{}
+	done          sync.WaitGroup
+}
+
+// startLogWriters starts the given number of database writers
+func startLogWriters(workers, batchSize int, flushInterval time.Duration) *logWriters {
+	writers := &logWriters{
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		stopping:      make(chan struct{}),
+	}
+	writers.done.Add(workers)
+	for i := 0; i < workers; i++ {
+		go writers.run()
+	}
+	return writers
+}
+
+// stop stores the entries still queued and waits for the writers to finish
+func (w *logWriters) stop() {
+	close(w.stopping)
+	w.done.Wait()
+}
+
+// flush stores a batch, passing each stored entry on to the clients of
+// /api/stream
+func (w *logWriters) flush(batch []RequestLog) {
+	if len(batch) == 0 {
+		return
+	}
+	for _, stored := range store.LogRequests(batch) {
+		publishRequest(stored)
+	}
+}
+
+func (w *logWriters) run() {
+	defer w.done.Done()
+	batch := make([]RequestLog, 0, w.batchSize)
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+	flush := func() {
+		if !timer.Stop() {
+			// Discard a pending expiry so it does not cut the next batch short
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		w.flush(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case entry := <-requestLogChan:
+			if len(batch) == 0 {
+				timer.Reset(w.flushInterval)
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		case <-w.stopping:
+			// Take what is left in the queue, sharing it with the other writers
+		drain:
+			for {
+				select {
+				case entry := <-requestLogChan:
+					batch = append(batch, entry)
+					if len(batch) >= w.batchSize {
+						flush()
+					}
+				default:
+					break drain
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// stopOnSignal stores the queued entries and exits on SIGINT or SIGTERM, so
+// requests recorded just before shutting down are not lost
+func (w *logWriters) stopOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, storing the recorded requests not yet written before exiting", sig)
+		w.stop()
+		os.Exit(0)
+	}()
+}
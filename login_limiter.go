@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// loginLimiterCleanupInterval is how often buckets of clients that stopped
+// trying to log in are dropped
+const loginLimiterCleanupInterval = 5 * time.Minute
+
+// loginBucket is the token bucket of one client address
+type loginBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// loginLimiter throttles login attempts per client address with token
+// buckets holding perMinute attempts that refill at perMinute per minute
+type loginLimiter struct {
+	mu        sync.Mutex
+	perMinute int // 0 disables the limit
+	buckets   map[string]*loginBucket
+}
+
+var loginAttempts = &loginLimiter{buckets: make(map[string]*loginBucket)}
+
+// refill adds the tokens earned since the bucket was last updated
+func (l *loginLimiter) refill(bucket *loginBucket, now time.Time) {
+	earned := now.Sub(bucket.updated).Minutes() * float64(l.perMinute)
+	bucket.tokens = math.Min(bucket.tokens+earned, float64(l.perMinute))
+	bucket.updated = now
+}
+
+// allow takes an attempt from the client's bucket, returning how long to wait
+// for the next one when none is left
+func (l *loginLimiter) allow(addr string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	bucket, ok := l.buckets[addr]
+	if !ok {
+		bucket = &loginBucket{tokens: float64(l.perMinute), updated: now}
+		l.buckets[addr] = bucket
+	}
+	l.refill(bucket, now)
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / float64(l.perMinute) * float64(time.Minute))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// reset forgets the attempts of a client that logged in successfully
+func (l *loginLimiter) reset(addr string) {
+	l.mu.Lock()
+	delete(l.buckets, addr)
+	l.mu.Unlock()
+}
+
+// cleanup drops buckets that have refilled completely, which are no
+// different from having none
+func (l *loginLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for addr, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= float64(l.perMinute) {
+			delete(l.buckets, addr)
+		}
+	}
+}
+
+// startLoginLimiter enables the limit and periodically drops stale buckets
+func startLoginLimiter(perMinute int) {
+	loginAttempts.mu.Lock()
+	loginAttempts.perMinute = perMinute
+	loginAttempts.mu.Unlock()
+	if perMinute <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(loginLimiterCleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			loginAttempts.cleanup()
+		}
+	}()
+}
+
+// rejectLoginAttempt answers 429 with Retry-After when the client has used up
+// its login attempts, reporting whether it did
+func rejectLoginAttempt(w http.ResponseWriter, addr string) bool {
+	ok, wait := loginAttempts.allow(addr)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, `{"message": "Too many login attempts"}`, http.StatusTooManyRequests)
+	return true
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"database/sql"
@@ -13,13 +16,16 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
 	"os"
@@ -37,12 +43,85 @@ var requestLogChan chan RequestLog // Channel for logging requests asynchronousl
 
 // ProxyHandler holds the reverse proxy and handles logging
 type ProxyHandler struct {
-	proxy *httputil.ReverseProxy
+	proxy     *httputil.ReverseProxy
+	port      int           // Port the proxy listens on, recorded with each request
+	upstreams *upstreamPool // Backends to balance across; nil forwards to the port's target
+	routed    bool          // Whether the -routes rules apply; requests matching no route get a 502
 }
 
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Capture request details
-	requestBody, err := ioutil.ReadAll(r.Body)
+	rules := currentRules()
+	proxy, target := h.proxy, targetForPort(h.port)
+	// Never pass on an admin identity asserted by a proxy client: the
+	// upstream may be the admin server itself
+	if authTrustedHeader != "" {
+		r.Header.Del(authTrustedHeader)
+	}
+	var picked *weightedTarget
+	var route *proxyRoute
+	var routes routeTable
+	if h.routed {
+		routes = rules.routes
+	}
+	if routes != nil {
+		route = routes.match(r.URL.Path)
+		target = nil
+		if route != nil {
+			proxy, target = route.proxy, route.target
+		}
+	} else if h.upstreams != nil {
+		picked = h.upstreams.next()
+		target = picked.URL
+	}
+	// Edit headers before the entry is created so it records what the upstream receives
+	headersEdited := rules.applyRequestHeaderRules(r)
+	reqLog := newRequestLog(r, h.port, target, rules)
+	if headersEdited {
+		reqLog.addDecision(decisionHeaders, "-header-rules %s in the request", rules.headerRules.Request)
+	}
+	if picked != nil {
+		reqLog.addDecision(decisionRoute, "weighted round-robin picked %s (weight %d, %d backends)", picked.URL, picked.Weight, len(h.upstreams.targets))
+	}
+	if route != nil {
+		reqLog.Route = route.Prefix
+		reqLog.routeRecord = route.Record
+		reqLog.addDecision(decisionRoute, "path matched route %s", route.Prefix)
+	}
+
+	// Without a matching route there is nowhere to forward the request
+	if routes != nil && route == nil {
+		reqLog.addDecision(decisionRoute, "no -routes prefix matches %s", r.URL.Path)
+		recordSynthetic(w, reqLog, http.StatusBadGateway, fmt.Sprintf("No route matches %s\n", r.URL.Path), handledByNoRoute)
+		return
+	}
+
+	// Apply backpressure when the concurrency cap is reached
+	if !rules.acquireSlot() {
+		reqLog.addDecision(decisionRateLimit, "no free slot within -max-concurrent-wait %v (-max-concurrent %d)", rules.concurrencyWait, cap(rules.concurrencyLimiter))
+		recordSynthetic(w, reqLog, http.StatusServiceUnavailable, "Too many concurrent requests\n", handledByRateLimit)
+		return
+	}
+	defer rules.releaseSlot()
+	inFlightRequests.Add(1)
+	defer inFlightRequests.Add(-1)
+
+	// Reject requests whose headers exceed the hard limit
+	if rules.headerSizeLimit > 0 && reqLog.RequestHeaderSize > rules.headerSizeLimit {
+		log.Printf("Rejecting %s %s: request headers are %d bytes (limit %d)", r.Method, r.URL, reqLog.RequestHeaderSize, rules.headerSizeLimit)
+		reqLog.addDecision(decisionBlock, "request headers are %d bytes, over -header-size-limit %d", reqLog.RequestHeaderSize, rules.headerSizeLimit)
+		recordSynthetic(w, reqLog, http.StatusRequestHeaderFieldsTooLarge, "Request header fields too large\n", handledByBlock)
+		return
+	}
+
+	// Capture request details, rejecting bodies over -max-request-body
+	requestBody, err := readRequestBody(w, r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		log.Printf("Rejecting %s %s: request body over -max-request-body %d", r.Method, r.URL, maxRequestBody)
+		reqLog.addDecision(decisionBlock, "request body over -max-request-body %d", maxRequestBody)
+		recordSynthetic(w, reqLog, http.StatusRequestEntityTooLarge, "Request body too large\n", handledByBlock)
+		return
+	}
 	if err != nil {
 		log.Printf("Error reading request body: %v", err)
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
@@ -51,73 +130,208 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Restore body for proxy
 	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
 
-	// Decompress request body if gzipped
+	// Decompress request body if compressed (gzip, br or deflate)
 	decompressedReqBody := requestBody
-	if r.Header.Get("Content-Encoding") == "gzip" {
-		decompressedReqBody, err = decompressGzip(requestBody)
+	var bodyError string
+	if encoding := r.Header.Get("Content-Encoding"); isSupportedEncoding(encoding) {
+		decompressedReqBody, err = decompressBody(requestBody, encoding)
 		if err != nil {
 			log.Printf("Error decompressing request body: %v", err)
 			// Continue with compressed body if decompression fails
 			decompressedReqBody = requestBody
+			bodyError = fmt.Sprintf("decompressing request body: %v", err)
+		} else {
+			reqLog.addDecision(decisionBody, "%s request body decompressed for recording, forwarded compressed", encoding)
 		}
 	}
 
-	reqLog := RequestLog{
-		Timestamp:      time.Now(),
-		Method:         r.Method,
-		URL:            r.URL.String(),
-		RequestHeaders: HeadersToJSON(r.Header),
-		RequestBody:    decompressedReqBody,
-	}
+	reqLog.RequestBody = decompressedReqBody
+	reqLog.BodyError = bodyError
+
+	// Register the request as in flight so it can be listed and cancelled
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	connID := activeConnections.add(r, cancel)
+	defer activeConnections.remove(connID)
 
 	// Store request log in context for later use
-	ctx := context.WithValue(r.Context(), "reqLog", &reqLog)
+	ctx = context.WithValue(ctx, "reqLog", &reqLog)
+	if picked != nil {
+		ctx = context.WithValue(ctx, "upstream", picked)
+	}
 	newReq := r.WithContext(ctx)
+	setForwardedHeaders(newReq)
 
-	// Serve the request through the proxy
-	h.proxy.ServeHTTP(w, newReq)
-}
-
-// decompressGzip decompresses a gzip compressed byte slice.
-func decompressGzip(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
+	// WebSocket upgrades are tunnelled directly since the proxy buffers responses
+	if isWebSocketUpgrade(r) {
+		proxyWebSocket(ctx, w, newReq, target, &reqLog)
+		return
 	}
-	defer reader.Close()
-	return ioutil.ReadAll(reader)
+
+	// Log to database once the response has been sent, if ModifyResponse selected it.
+	// Deferred because ReverseProxy panics with http.ErrAbortHandler when the
+	// client goes away mid-transfer, which is exactly the case worth recording.
+	rec := &responseRecorder{ResponseWriter: w}
+	timing := newUpstreamTiming()
+	defer func() {
+		if !reqLog.record {
+			return
+		}
+		reqLog.ClientBytesSent = rec.bytesWritten
+		timing.apply(&reqLog, time.Now())
+		queueRequestLog(reqLog)
+	}()
+
+	// Serve the request through the proxy, counting the bytes delivered to the
+	// client and tracing when the upstream received it and started answering
+	proxy.ServeHTTP(rec, newReq.WithContext(httptrace.WithClientTrace(newReq.Context(), timing.clientTrace())))
 }
 
-// responseRecorder is a custom ResponseWriter to capture status code and body
+// responseRecorder is a custom ResponseWriter to capture status code and body.
+// It always counts the bytes written; body and headers are only captured when set.
 type responseRecorder struct {
 	http.ResponseWriter
-	statusCode int
-	body       *bytes.Buffer
-	headers    http.Header
+	statusCode   int
+	body         *bytes.Buffer
+	headers      http.Header
+	bytesWritten int64 // Body bytes actually accepted by the client connection
 }
 
 func (rec *responseRecorder) WriteHeader(statusCode int) {
 	rec.statusCode = statusCode
 	// Copy headers from the original response
-	for k, v := range rec.ResponseWriter.Header() {
-		rec.headers[k] = v
+	if rec.headers != nil {
+		for k, v := range rec.ResponseWriter.Header() {
+			rec.headers[k] = v
+		}
 	}
 	rec.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (rec *responseRecorder) Write(buf []byte) (int, error) {
-	rec.body.Write(buf) // Capture body
-	return rec.ResponseWriter.Write(buf)
+	if rec.body != nil {
+		rec.body.Write(buf) // Capture body
+	}
+	n, err := rec.ResponseWriter.Write(buf)
+	rec.bytesWritten += int64(n)
+	return n, err
+}
+
+// Flush forwards flushes so streamed responses are not buffered by the recorder
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
 }
 
 func (rec *responseRecorder) Header() http.Header {
+	if rec.headers == nil {
+		return rec.ResponseWriter.Header()
+	}
 	return rec.headers
 }
 
+// captureResponse is the ModifyResponse of every reverse proxy: it captures,
+// decompresses and ensures correct headers of upstream responses
+func captureResponse(resp *http.Response) error {
+	// Get the request log from context
+	reqLog, ok := resp.Request.Context().Value("reqLog").(*RequestLog)
+	if !ok {
+		log.Printf("Failed to get request log from context")
+		return nil // Not an error for the client, just for our logging
+	}
+
+	// Capture the upstream status code, then apply any -map-status rewrite
+	reqLog.StatusCode = resp.StatusCode
+	reqLog.ResponseProto = resp.Proto
+	reqLog.ClientStatusCode = rewriteStatus(resp)
+	if reqLog.ClientStatusCode != reqLog.StatusCode {
+		reqLog.addDecision(decisionStatus, "upstream status %d sent to the client as %d by -map-status", reqLog.StatusCode, reqLog.ClientStatusCode)
+	}
+
+	// Capture response headers (do this early to preserve original headers for logging),
+	// after -header-rules so the entry matches what the client receives
+	currentRules().applyResponseHeaderRules(resp, reqLog)
+	reqLog.ResponseHeaders = HeadersToJSON(resp.Header)
+
+	// Streams are forwarded as they arrive, keeping a capped copy for the log;
+	// ServeHTTP logs it once the stream ends
+	if isStreamingResponse(resp) {
+		resp.Body = newStreamCapture(resp.Body, reqLog, streamCaptureBytes, resp.Header.Get("Content-Encoding"))
+		reqLog.addDecision(decisionBody, "response streamed to the client, recording at most %d bytes", streamCaptureBytes)
+		markForRecording(reqLog, resp)
+		return nil
+	}
+
+	// Capture response body, refusing bodies over -max-response-body
+	body, err := readResponseBody(resp)
+	if errors.Is(err, errResponseTooLarge) {
+		resp.Body.Close()
+		reqLog.addDecision(decisionBlock, "upstream %d response not forwarded: %v", resp.StatusCode, err)
+		return err
+	}
+	if err != nil {
+		// Log the error and return it to potentially abort the response,
+		// still recording whatever part of the body was received
+		log.Printf("Error reading response body: %v", err)
+		reqLog.ResponseBody = body
+		reqLog.BodyError = appendBodyError(reqLog.BodyError, fmt.Sprintf("reading response body: %v", err))
+		reqLog.record = reqLog.recordable()
+		return err
+	}
+	resp.Body.Close() // Important: Close the original body
+
+	// Record the size received from upstream before any decompression
+	reqLog.ResponseWireSize = len(body)
+
+	// A partial response is a byte range of the encoded representation: it
+	// cannot be decompressed on its own and its Content-Length and
+	// Content-Range must reach the client unchanged
+	reqLog.ContentRange = resp.Header.Get("Content-Range")
+	isPartial := resp.StatusCode == http.StatusPartialContent || reqLog.ContentRange != ""
+	if isPartial {
+		reqLog.addDecision(decisionBody, "partial response forwarded unchanged (Content-Range %q)", reqLog.ContentRange)
+	}
+
+	// Decompress response body if compressed (gzip, br or deflate)
+	if encoding := resp.Header.Get("Content-Encoding"); isSupportedEncoding(encoding) && !isPartial {
+		decompressedBody, err := decompressBody(body, encoding)
+		if err != nil {
+			log.Printf("Error decompressing response body: %v", err)
+			// Continue with compressed body if decompression fails
+			// Do not modify headers in this case
+			reqLog.BodyError = appendBodyError(reqLog.BodyError, fmt.Sprintf("decompressing response body: %v", err))
+		} else {
+			body = decompressedBody
+			// Crucial: Remove the Content-Encoding header as the body is now decompressed
+			resp.Header.Del("Content-Encoding")
+			// Crucial: Update Content-Length header as the body size has changed
+			resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			reqLog.addDecision(decisionBody, "%s response decompressed, forwarded without Content-Encoding", encoding)
+		}
+	}
+
+	// Store potentially modified body for logging
+	reqLog.ResponseBody = body
+
+	// Update response with the (possibly modified) body
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Mark for logging if recording is enabled and the response opted in;
+	// ServeHTTP logs it after the body has been sent to the client
+	markForRecording(reqLog, resp)
+
+	return nil
+}
+
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil || cookie.Value != "valid_token" { // Simple check for now
+		if !isAuthenticated(r) {
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
@@ -129,9 +343,6 @@ func getRequests(w http.ResponseWriter, r *http.Request) {
 	// Get query parameters
 	pageStr := r.URL.Query().Get("page")
 	pageSizeStr := r.URL.Query().Get("page_size")
-	urlFilter := r.URL.Query().Get("url")
-	startDate := r.URL.Query().Get("start_date")
-	endDate := r.URL.Query().Get("end_date")
 
 	// Parse pagination parameters
 	page := 1
@@ -147,68 +358,12 @@ func getRequests(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Calculate offset
-	offset := (page - 1) * pageSize
-
-	// Build query with filters
-	query := "SELECT id, timestamp, method, url, status_code FROM requests WHERE 1=1"
-	countQuery := "SELECT COUNT(*) FROM requests WHERE 1=1"
-	var args []interface{}
-
-	// URL filter
-	if urlFilter != "" {
-		query += " AND url LIKE ?"
-		countQuery += " AND url LIKE ?"
-		args = append(args, "%"+urlFilter+"%")
-	}
-
-	// Date filters - convert date strings to datetime format
-	if startDate != "" {
-		// Convert YYYY-MM-DD to datetime format with start of day
-		startDateTime := startDate + " 00:00:00"
-		query += " AND timestamp >= ?"
-		countQuery += " AND timestamp >= ?"
-		args = append(args, startDateTime)
-	}
-	if endDate != "" {
-		// Convert YYYY-MM-DD to datetime format with end of day
-		endDateTime := endDate + " 23:59:59"
-		query += " AND timestamp <= ?"
-		countQuery += " AND timestamp <= ?"
-		args = append(args, endDateTime)
-	}
-
-	// Add ordering and pagination
-	query += " ORDER BY timestamp DESC LIMIT ? OFFSET ?"
-	args = append(args, pageSize, offset)
-
-	// Get total count
-	var totalCount int
-	err := db.QueryRow(countQuery, args[:len(args)-2]...).Scan(&totalCount)
-	if err != nil {
-		http.Error(w, "Failed to fetch request count", http.StatusInternalServerError)
-		log.Printf("Error fetching request count: %v", err)
-		return
-	}
-
-	// Execute query with pagination
-	rows, err := db.Query(query, args...)
+	requests, totalCount, err := store.GetRequests(r.URL.Query(), page, pageSize)
 	if err != nil {
 		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
 		log.Printf("Error fetching requests: %v", err)
 		return
 	}
-	defer rows.Close()
-
-	var requests []RequestLog
-	for rows.Next() {
-		var req RequestLog
-		if err := rows.Scan(&req.ID, &req.Timestamp, &req.Method, &req.URL, &req.StatusCode); err != nil {
-			log.Printf("Error scanning request: %v", err)
-			continue
-		}
-		requests = append(requests, req)
-	}
 
 	// Prepare response with pagination info
 	response := struct {
@@ -229,20 +384,47 @@ func getRequests(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func getRequestDetail(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Path[len("/api/requests/"):]
-	id, err := strconv.Atoi(idStr)
+// recentRequestsHandler handles GET /api/requests/recent?n=20, returning the
+// latest N requests (newest first, at most 100) without pagination
+func recentRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := 20
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		if parsed, err := strconv.Atoi(nStr); err == nil && parsed > 0 {
+			n = parsed
+		}
+	}
+	if n > 100 {
+		n = 100
+	}
+
+	rows, err := db.Query("SELECT "+requestSummaryColumns()+" FROM requests ORDER BY id DESC LIMIT ?", n)
 	if err != nil {
-		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
+		log.Printf("Error fetching recent requests: %v", err)
 		return
 	}
+	defer rows.Close()
+
+	response := struct {
+		Requests []RequestLog `json:"requests"`
+		Count    int          `json:"count"`
+	}{
+		Requests: scanRequestSummaries(rows),
+	}
+	response.Count = len(response.Requests)
 
-	// Modified SQL query to fetch metadata instead of full bodies
-	row := db.QueryRow("SELECT id, timestamp, method, url, request_headers, request_body_size, is_request_body_text, status_code, response_headers, response_body_size, is_response_body_text FROM requests WHERE id = ?", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	var req RequestLog
-	// Scan into the new metadata fields
-	if err := row.Scan(&req.ID, &req.Timestamp, &req.Method, &req.URL, &req.RequestHeaders, &req.RequestBodySize, &req.IsRequestBodyText, &req.StatusCode, &req.ResponseHeaders, &req.ResponseBodySize, &req.IsResponseBodyText); err != nil {
+func getRequestDetail(w http.ResponseWriter, r *http.Request, id int) {
+	req, err := store.GetRequestDetail(id)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Request not found", http.StatusNotFound)
 			return
@@ -265,6 +447,44 @@ func getRequestDetail(w http.ResponseWriter, r *http.Request) {
 		ResponseHeaders    string    `json:"response_headers"`
 		ResponseBodySize   int       `json:"response_body_size"`
 		IsResponseBodyText bool      `json:"is_response_body_text"`
+		ResponseWireSize   int       `json:"response_wire_size"`
+		CompressionRatio   float64   `json:"compression_ratio,omitempty"`
+		ConnID             int64     `json:"conn_id"`
+		Seq                int64     `json:"seq"`
+		RequestHeaderSize  int       `json:"request_header_size"`
+		HeadersOversized   bool      `json:"request_headers_oversized"`
+		RequestCharset     string    `json:"request_charset"`
+		ResponseCharset    string    `json:"response_charset"`
+		ClientStatusCode   int       `json:"client_status_code"`
+		ListenerPort       int       `json:"listener_port"`
+		ClientIP           string    `json:"client_ip"`
+		ClientBytesSent    int64     `json:"client_bytes_sent"`
+		BodyError          string    `json:"body_error,omitempty"`
+		Anomaly            bool      `json:"anomaly"`
+		AnomalyReason      string    `json:"anomaly_reason,omitempty"`
+		HandledBy          string    `json:"handled_by"`
+		MasksApplied       string    `json:"masks_applied,omitempty"`
+		TLSSNI             string    `json:"tls_sni,omitempty"`
+		RequestRange       string    `json:"request_range,omitempty"`
+		ContentRange       string    `json:"content_range,omitempty"`
+		Upstream           string    `json:"upstream,omitempty"`
+		Route              string    `json:"route,omitempty"`
+		DurationMs         int64     `json:"duration_ms"` // -1 when not measured
+		TTFBMs             int64     `json:"ttfb_ms"`     // -1 when not measured
+		DNSMs              int64     `json:"dns_ms"`      // -1 when not measured or no lookup was made
+		ConnectMs          int64     `json:"connect_ms"`  // -1 when not measured or a connection was reused
+		TLSMs              int64     `json:"tls_ms"`      // -1 when not measured or no handshake was made
+		Truncated          bool      `json:"truncated"`
+		Protocol           string    `json:"protocol"`
+		RetryCount         int       `json:"retry_count"`
+		RequestProto       string    `json:"request_proto,omitempty"`
+		ResponseProto      string    `json:"response_proto,omitempty"`
+		Origin             string    `json:"origin"` // proxy, replay or import
+
+		RequestBodyTruncated  bool `json:"request_body_truncated"`  // Cut to -max-body-size when stored
+		ResponseBodyTruncated bool `json:"response_body_truncated"` // Cut to -max-body-size when stored
+
+		Tags []string `json:"tags"`
 	}{
 		ID:                 req.ID,
 		Timestamp:          req.Timestamp,
@@ -277,12 +497,133 @@ func getRequestDetail(w http.ResponseWriter, r *http.Request) {
 		ResponseHeaders:    req.ResponseHeaders,
 		ResponseBodySize:   req.ResponseBodySize,
 		IsResponseBodyText: req.IsResponseBodyText,
+		ResponseWireSize:   req.ResponseWireSize,
+		CompressionRatio:   compressionRatio(req.ResponseBodySize, req.ResponseWireSize),
+		ConnID:             req.ConnID,
+		Seq:                req.Seq,
+		RequestHeaderSize:  req.RequestHeaderSize,
+		HeadersOversized:   req.RequestHeadersOversized,
+		RequestCharset:     req.RequestCharset,
+		ResponseCharset:    req.ResponseCharset,
+		ClientStatusCode:   req.ClientStatusCode,
+		ListenerPort:       req.ListenerPort,
+		ClientIP:           req.ClientIP,
+		ClientBytesSent:    req.ClientBytesSent,
+		BodyError:          req.BodyError,
+		Anomaly:            req.Anomaly,
+		AnomalyReason:      req.AnomalyReason,
+		HandledBy:          req.HandledBy,
+		MasksApplied:       req.MasksApplied,
+		TLSSNI:             req.TLSSNI,
+		RequestRange:       req.RequestRange,
+		ContentRange:       req.ContentRange,
+		Upstream:           req.Upstream,
+		Route:              req.Route,
+		DurationMs:         req.DurationMs,
+		TTFBMs:             req.TTFBMs,
+		DNSMs:              req.DNSMs,
+		ConnectMs:          req.ConnectMs,
+		TLSMs:              req.TLSMs,
+		Truncated:          req.Truncated,
+		Protocol:           req.Protocol,
+		RetryCount:         req.RetryCount,
+		RequestProto:       req.RequestProto,
+		ResponseProto:      req.ResponseProto,
+		Origin:             req.Origin,
+
+		RequestBodyTruncated:  req.RequestBodyTruncated,
+		ResponseBodyTruncated: req.ResponseBodyTruncated,
+		Tags:                  req.Tags,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// deleteRequestHandler handles DELETE /api/requests/{id}, removing one captured request
+func deleteRequestHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := store.DeleteRequest(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Request not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete request", http.StatusInternalServerError)
+		log.Printf("Error deleting request %d: %v", id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestItemHandler dispatches /api/requests/{id} and /api/requests/{id}/{action} routes
+func requestItemHandler(w http.ResponseWriter, r *http.Request) {
+	idStr, action, hasAction := strings.Cut(r.URL.Path[len("/api/requests/"):], "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	if !hasAction {
+		switch r.Method {
+		case "GET":
+			getRequestDetail(w, r, id)
+		case "DELETE":
+			deleteRequestHandler(w, r, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch action {
+	case "script":
+		getRequestScriptHandler(w, r, id)
+	case "httpfile":
+		getRequestHTTPFileHandler(w, r, id)
+	case "explain":
+		explainRequestHandler(w, r, id)
+	case "search":
+		searchBodyHandler(w, r, id)
+	case "tags":
+		requestTagsHandler(w, r, id, "")
+	default:
+		if strings.HasPrefix(action, "tags/") {
+			requestTagsHandler(w, r, id, strings.TrimPrefix(action, "tags/"))
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// jsonBodyError describes why a JSON request body was rejected, telling an
+// unknown field apart from malformed JSON and wrongly typed values. Only
+// field names and offsets are reported, never the body itself.
+func jsonBodyError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		return "Invalid request body: empty body"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Invalid request body: malformed JSON at offset %d", syntaxErr.Offset)
+	case err == io.ErrUnexpectedEOF:
+		return "Invalid request body: malformed JSON, unexpected end of body"
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return fmt.Sprintf("Invalid request body: expected a JSON object, not %s", typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("Invalid request body: field %q must be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for fields rejected by DisallowUnknownFields
+		return "Invalid request body: unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	return "Invalid request body"
+}
+
 func replayRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -294,84 +635,239 @@ func replayRequest(w http.ResponseWriter, r *http.Request) {
 		URL     string              `json:"url"`
 		Headers map[string][]string `json:"headers"`
 		Body    string              `json:"body"`
+
+		BodyBase64   bool `json:"body_base64"`   // Body is base64 encoded, for binary bodies such as gRPC-Web frames
+		ListenerPort int  `json:"listener_port"` // Port of the original request, selecting its target
+		ID           int  `json:"id"`            // ID of the original request, needed by Conditional
+		Conditional  bool `json:"conditional"`   // Send the original response's validators to test caching
+
+		TargetOverride string `json:"target_override"` // Scheme and host replacing those of the resolved URL, e.g. https://staging.example.com
+		Record         bool   `json:"record"`          // Store the replayed exchange, marked with origin replay
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&replayData); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		http.Error(w, jsonBodyError(err), http.StatusBadRequest)
 		log.Printf("Error decoding replay data: %v", err)
 		return
 	}
 
-	// --- Fix: Handle relative URLs ---
-	// Retrieve the target URL from the global flag variable
-	targetFlag := flag.Lookup("target")
-	var targetStr string
-	if targetFlag != nil {
-		targetStr = targetFlag.Value.String()
-	} else {
-		// Fallback if flag is not found (should not happen in normal operation)
-		targetStr = "http://localhost:8081"
-		log.Println("Warning: '-target' flag not found, using default fallback for replay URL resolution.")
-	}
-
-	parsedTarget, err := url.Parse(targetStr)
-	if err != nil {
-		// Final fallback if parsing fails
-		parsedTarget = &url.URL{Scheme: "http", Host: "localhost:8081"}
-		log.Printf("Warning: Failed to parse target URL '%s', using fallback: %v", targetStr, parsedTarget)
-	}
-
-	// Parse the URL from replay data. If it's relative, resolve it against the target.
-	parsedReplayURL, err := url.Parse(replayData.URL)
+	// Resolve relative URLs from replay data against the target
+	finalURL, err := resolveTargetURL(replayData.URL, replayData.ListenerPort)
 	if err != nil {
 		http.Error(w, "Invalid URL in replay data", http.StatusBadRequest)
 		log.Printf("Error parsing replay URL: %v", err)
 		return
 	}
+	if replayData.TargetOverride != "" {
+		if finalURL, err = overrideTargetHost(finalURL, replayData.TargetOverride); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	// If the URL from replay data is relative (no scheme), resolve it against the target
-	finalURL := parsedTarget.ResolveReference(parsedReplayURL).String()
+	body := []byte(replayData.Body)
+	if replayData.BodyBase64 {
+		body, err = base64.StdEncoding.DecodeString(replayData.Body)
+		if err != nil {
+			http.Error(w, "Invalid base64 body in replay data", http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Create a new HTTP request
-	replayReq, err := http.NewRequest(replayData.Method, finalURL, bytes.NewBuffer([]byte(replayData.Body)))
+	replayReq, err := http.NewRequest(replayData.Method, finalURL, bytes.NewBuffer(body))
 	if err != nil {
 		http.Error(w, "Failed to create replay request", http.StatusInternalServerError)
 		log.Printf("Error creating replay request to %s: %v", finalURL, err)
 		return
 	}
 
-	// Add headers
-	for k, v := range replayData.Headers {
-		// Join multiple values with comma (standard for HTTP headers)
-		// Special handling for Host header if needed, though Go usually handles it via req.Host
-		replayReq.Header.Set(k, strings.Join(v, ", "))
+	// Load the cache validators of the original response for a conditional replay
+	var conditional *conditionalReplay
+	if replayData.Conditional {
+		conditional, err = loadConditionalReplay(replayData.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(replayReq)
+	// Add headers, one header line per stored value so duplicate headers
+	// (e.g. several X-Forwarded-For hops) are reproduced as captured
+	// Special handling for Host header if needed, though Go usually handles it via req.Host
+	for k, values := range replayData.Headers {
+		for _, v := range values {
+			replayReq.Header.Add(k, v)
+		}
+	}
+	if conditional != nil {
+		conditional.apply(replayReq)
+	}
+
+	sendReplay(w, replayReq, finalURL, conditional, nil, replayData.Record)
+}
+
+// replayStoredRequestHandler handles POST /api/replay/{id}, replaying a stored
+// request as captured: method, URL resolved against the target of the listener
+// that received it, headers and body. With ?diff=1 the result includes how the
+// response differs from the recorded one.
+func replayStoredRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Path[len("/api/replay/"):])
 	if err != nil {
-		http.Error(w, "Failed to execute replayed request", http.StatusInternalServerError)
-		log.Printf("Error executing replayed request to %s: %v", finalURL, err)
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := getRequestLogs(" AND id = ?", id)
+	if err != nil {
+		http.Error(w, "Failed to fetch request", http.StatusInternalServerError)
+		log.Printf("Error fetching request %d: %v", id, err)
 		return
 	}
-	defer resp.Body.Close()
+	if len(requests) == 0 {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	stored := requests[0]
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+	replayReq, err := newStoredReplayRequest(stored, nil)
 	if err != nil {
-		http.Error(w, "Failed to read replayed response body", http.StatusInternalServerError)
-		log.Printf("Error reading replayed response body from %s: %v", finalURL, err)
+		http.Error(w, "Failed to create replay request", http.StatusInternalServerError)
+		log.Printf("Error creating replay request for request %d: %v", id, err)
 		return
 	}
 
+	var original *RequestLog
+	if r.URL.Query().Get("diff") == "1" {
+		original = &stored
+	}
+	sendReplay(w, replayReq, replayReq.URL.String(), nil, original, false)
+}
+
+// newStoredReplayRequest rebuilds a stored request for replaying. Its URL is
+// resolved against target, or when target is nil against the target of the
+// listener that received it.
+func newStoredReplayRequest(stored RequestLog, target *url.URL) (*http.Request, error) {
+	var finalURL string
+	if target != nil {
+		parsedURL, err := url.Parse(stored.URL)
+		if err != nil {
+			return nil, err
+		}
+		finalURL = target.ResolveReference(parsedURL).String()
+	} else {
+		var err error
+		if finalURL, err = resolveTargetURL(stored.URL, stored.ListenerPort); err != nil {
+			return nil, err
+		}
+	}
+
+	replayReq, err := http.NewRequest(stored.Method, finalURL, bytes.NewReader(stored.RequestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	// Headers the client sets itself, or describing the compressed body that
+	// was stored decompressed, are skipped as in exported scripts
+	names, headers := scriptHeaders(stored)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			replayReq.Header.Add(name, value)
+		}
+	}
+	return replayReq, nil
+}
+
+// replayResult is the outcome of a replayed request returned by the replay endpoints
+type replayResult struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+
+	Conditional *conditionalReplay `json:"conditional,omitempty"`
+	Diff        *replayDiff        `json:"diff,omitempty"`
+}
+
+// sendReplay executes a replayed request and writes its outcome as JSON,
+// compared with the recorded response of original when it is not nil
+func sendReplay(w http.ResponseWriter, replayReq *http.Request, finalURL string, conditional *conditionalReplay, original *RequestLog, record bool) {
+	result := executeReplay(replayReq, finalURL, conditional, original, record)
+
+	// Return the replayed response details
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(result); err != nil {
+		log.Printf("Error encoding replay response JSON: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// replayTimeout bounds a replayed request, from connecting to reading the
+// whole response body
+const replayTimeout = 60 * time.Second
+
+// executeReplay sends a replayed request. Failures of the replayed request
+// itself (DNS errors, timeouts...) are reported in the result rather than as
+// an admin API error. With record the exchange is stored as well.
+func executeReplay(replayReq *http.Request, finalURL string, conditional *conditionalReplay, original *RequestLog, record bool) replayResult {
+	result := replayResult{Conditional: conditional}
+
+	// Sent like proxied requests, through the upstream transport
+	client := &http.Client{Transport: upstreamTransport, Timeout: replayTimeout}
+	start := time.Now()
+	resp, err := client.Do(replayReq)
+	if err != nil {
+		log.Printf("Error executing replayed request to %s: %v", finalURL, err)
+		result.Error = err.Error()
+		if record {
+			recordReplay(replayReq, nil, nil, start, err)
+		}
+	} else {
+		defer resp.Body.Close()
+
+		// On a read error keep whatever part of the body was received
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			log.Printf("Error reading replayed response body from %s: %v", finalURL, err)
+			result.Error = fmt.Sprintf("failed to read response body: %v", err)
+		}
+
+		result.StatusCode = resp.StatusCode
+		result.Headers = resp.Header
+		result.Body = encodeReplayBody(resp.Header, respBody, finalURL)
+		if conditional != nil {
+			conditional.NotModified = resp.StatusCode == http.StatusNotModified
+		}
+		if original != nil {
+			result.Diff = diffReplay(*original, resp, respBody)
+		}
+		if record {
+			recordReplay(replayReq, resp, respBody, start, nil)
+		}
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// encodeReplayBody decompresses a replayed response body and returns it as
+// text, or base64-encoded for binary content
+func encodeReplayBody(header http.Header, respBody []byte, finalURL string) string {
 	// Decompress if necessary
 	bodyBytes := respBody
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		decompressedBody, err := decompressGzip(respBody)
+	if encoding := header.Get("Content-Encoding"); isSupportedEncoding(encoding) {
+		decompressedBody, err := decompressBody(respBody, encoding)
 		if err != nil {
-			log.Printf("Warning: Failed to decompress gzipped replay response from %s: %v", finalURL, err)
+			log.Printf("Warning: Failed to decompress replay response from %s: %v", finalURL, err)
 			// Keep original compressed body if decompression fails
 			bodyBytes = respBody
 		} else {
@@ -380,42 +876,85 @@ func replayRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Determine if the content is text or binary
-	contentType := resp.Header.Get("Content-Type")
+	contentType := header.Get("Content-Type")
 	isText := strings.HasPrefix(contentType, "text/") ||
 		strings.Contains(contentType, "json") ||
 		strings.Contains(contentType, "xml") ||
 		strings.Contains(contentType, "javascript")
 
-	var finalRespBody string
 	if isText {
 		// If it's text, convert to string directly
-		finalRespBody = string(bodyBytes)
+		return string(bodyBytes)
+	}
+	// If it's binary, Base64 encode it
+	return base64.StdEncoding.EncodeToString(bodyBytes)
+}
+
+// targetBaseURL returns the configured -target URL used to resolve relative URLs
+func targetBaseURL() *url.URL {
+	// With -targets, the first backend stands in for -target
+	if upstreamTargets != nil {
+		return upstreamTargets.targets[0].URL
+	}
+
+	// Retrieve the target URL from the global flag variable
+	targetFlag := flag.Lookup("target")
+	var targetStr string
+	if targetFlag != nil {
+		targetStr = targetFlag.Value.String()
 	} else {
-		// If it's binary, Base64 encode it
-		finalRespBody = base64.StdEncoding.EncodeToString(bodyBytes)
+		// Fallback if flag is not found (should not happen in normal operation)
+		targetStr = "http://localhost:8081"
+		log.Println("Warning: '-target' flag not found, using default fallback for replay URL resolution.")
 	}
 
-	// Return the replayed response details
-	result := struct {
-		StatusCode int         `json:"statusCode"`
-		Headers    http.Header `json:"headers"`
-		Body       string      `json:"body"`
-	}{
-		StatusCode: resp.StatusCode,
-		Headers:    resp.Header,
-		Body:       finalRespBody,
+	parsedTarget, err := url.Parse(targetStr)
+	if err != nil {
+		// Final fallback if parsing fails
+		parsedTarget = &url.URL{Scheme: "http", Host: "localhost:8081"}
+		log.Printf("Warning: Failed to parse target URL '%s', using fallback: %v", targetStr, parsedTarget)
 	}
+	return parsedTarget
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	encoder := json.NewEncoder(w)
-	if err := encoder.Encode(result); err != nil {
-		log.Printf("Error encoding replay response JSON: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
+// overrideTargetHost sends a resolved replay URL to another environment,
+// replacing its scheme and host with those of override while keeping the path and query
+func overrideTargetHost(finalURL, override string) (string, error) {
+	target, err := url.Parse(override)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return "", fmt.Errorf("invalid target_override %q, expected a scheme and host such as https://staging.example.com", override)
 	}
+	parsedURL, err := url.Parse(finalURL)
+	if err != nil {
+		return "", err
+	}
+	parsedURL.Scheme = target.Scheme
+	parsedURL.Host = target.Host
+	return parsedURL.String(), nil
+}
+
+// resolveTargetURL resolves a possibly relative URL (as stored for proxied
+// requests) against the target of the listener that received it
+func resolveTargetURL(rawURL string, listenerPort int) (string, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	// If the URL is relative (no scheme), resolve it against the target,
+	// or against the backend of the matching route on the routed main listener
+	target := targetForPort(listenerPort)
+	if routes := currentRules().routes; routes != nil && !isListenerPort(listenerPort) {
+		if route := routes.match(parsedURL.Path); route != nil {
+			target = route.target
+		}
+	}
+	return target.ResolveReference(parsedURL).String(), nil
 }
 
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		sessions.remove(cookie.Value)
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    "",
@@ -429,117 +968,229 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"message": "Logged out"}`))
 }
 
-// generateCertificates generates CA and server certificates
-func generateCertificates() {
-	log.Println("Generating Root CA certificate and key...")
+// certOptions controls the keys and server certificate made by -gen-certs
+type certOptions struct {
+	KeyType  string        // certKeyTypeRSA or certKeyTypeECDSA
+	KeyBits  int           // RSA key size
+	Validity time.Duration // Lifetime of the server certificate
+	DNSNames []string      // Names the server certificate is valid for
+	IPs      []net.IP      // Addresses the server certificate is valid for
+	CACert   string        // -ca-cert, or "" to generate a new CA in certs/
+	CAKey    string        // -ca-key, set together with CACert
+}
 
-	// CA certificate
-	ca := &x509.Certificate{
-		SerialNumber: big.NewInt(2024),
-		Subject: pkix.Name{
-			Organization: []string{"dGateway CA"},
-			CommonName:   "dGateway Root CA",
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10, 0, 0), // 10 years
-		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		BasicConstraintsValid: true,
+// Key types accepted by -cert-key-type
+const (
+	certKeyTypeRSA   = "rsa"
+	certKeyTypeECDSA = "ecdsa"
+)
+
+// parseCertHosts splits -cert-hosts into its DNS names and IP addresses
+func parseCertHosts(value string) ([]string, []net.IP, error) {
+	var dnsNames []string
+	var ips []net.IP
+	for _, host := range strings.Split(value, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
 	}
+	if len(dnsNames) == 0 && len(ips) == 0 {
+		return nil, nil, fmt.Errorf("no hosts given")
+	}
+	return dnsNames, ips, nil
+}
 
-	// CA private key
-	caPrivKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		log.Fatalf("Failed to generate CA private key: %v", err)
+// generateKey creates a private key of the configured type
+func generateKey(opts certOptions) (crypto.Signer, error) {
+	if opts.KeyType == certKeyTypeECDSA {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	}
+	return rsa.GenerateKey(rand.Reader, opts.KeyBits)
+}
 
-	// Self-signed CA certificate
-	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivKey.PublicKey, caPrivKey)
-	if err != nil {
-		log.Fatalf("Failed to create CA certificate: %v", err)
+// randomSerial returns a random certificate serial number, so certificates
+// reissued by the same CA never share one
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// keyPEMBlock encodes a private key generated by generateKey
+func keyPEMBlock(key crypto.Signer) (*pem.Block, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
 	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
 
-	// Save CA certificate
-	caCertFile, err := os.Create("certs/ca.crt")
+// loadCA loads a CA certificate and its private key
+func loadCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
-		log.Fatalf("Failed to create ca.crt: %v", err)
+		return nil, nil, err
 	}
-	defer caCertFile.Close()
-	err = pem.Encode(caCertFile, &pem.Block{Type: "CERTIFICATE", Bytes: caBytes})
+	caCert, err := x509.ParseCertificate(pair.Certificate[0])
 	if err != nil {
-		log.Fatalf("Failed to encode ca.crt: %v", err)
+		return nil, nil, err
+	}
+	if !caCert.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	caKey, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type in %s", keyFile)
 	}
-	log.Println("Generated certs/ca.crt")
+	return caCert, caKey, nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
 
-	// Save CA private key
-	caKeyFile, err := os.Create("certs/ca.key")
+// writePEM writes a single PEM block to path
+func writePEM(path string, block *pem.Block) {
+	file, err := os.Create(path)
 	if err != nil {
-		log.Fatalf("Failed to create ca.key: %v", err)
+		log.Fatalf("Failed to create %s: %v", path, err)
 	}
-	defer caKeyFile.Close()
-	err = pem.Encode(caKeyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caPrivKey)})
-	if err != nil {
-		log.Fatalf("Failed to encode ca.key: %v", err)
+	defer file.Close()
+	if err := pem.Encode(file, block); err != nil {
+		log.Fatalf("Failed to encode %s: %v", path, err)
+	}
+	log.Printf("Generated %s", path)
+}
+
+// generateCertificates generates the server certificate and the CA signing
+// it. With -ca-cert and -ca-key an existing CA is loaded from those files
+// instead, or generated into them the first time, so a CA that clients already
+// trust is kept; otherwise a new CA overwrites certs/ca.crt and certs/ca.key.
+func generateCertificates(opts certOptions) {
+	certExists, keyExists := false, false
+	if opts.CACert == "" {
+		opts.CACert, opts.CAKey = defaultCACertFile, defaultCAKeyFile
+	} else {
+		certExists, keyExists = fileExists(opts.CACert), fileExists(opts.CAKey)
+	}
+	if certExists != keyExists {
+		log.Fatalf("Only one of %s and %s exists; provide both to reuse the CA or neither to generate one", opts.CACert, opts.CAKey)
+	}
+	var ca *x509.Certificate
+	var caPrivKey crypto.Signer
+	if certExists {
+		var err error
+		ca, caPrivKey, err = loadCA(opts.CACert, opts.CAKey)
+		if err != nil {
+			log.Fatalf("Failed to load CA %s / %s: %v", opts.CACert, opts.CAKey, err)
+		}
+		log.Printf("Using existing CA %s", opts.CACert)
+	} else {
+		ca, caPrivKey = generateCA(opts)
 	}
-	log.Println("Generated certs/ca.key")
 
 	log.Println("Generating server certificate and key...")
 
-	// Server certificate
+	// Server certificate, named after its first DNS name or else its first IP address
+	commonName := ""
+	if len(opts.DNSNames) > 0 {
+		commonName = opts.DNSNames[0]
+	} else if len(opts.IPs) > 0 {
+		commonName = opts.IPs[0].String()
+	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if opts.KeyType == certKeyTypeRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment // ECDSA keys cannot encipher
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		log.Fatalf("Failed to generate server certificate serial number: %v", err)
+	}
 	serverCert := &x509.Certificate{
-		SerialNumber: big.NewInt(2025),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			Organization: []string{"dGateway Server"},
-			CommonName:   "localhost",
+			CommonName:   commonName,
 		},
-		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:    opts.DNSNames,
+		IPAddresses: opts.IPs,
 		NotBefore:   time.Now(),
-		NotAfter:    time.Now().AddDate(1, 0, 0), // 1 year
+		NotAfter:    time.Now().Add(opts.Validity),
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:    keyUsage,
 	}
 
 	// Server private key
-	serverPrivKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	serverPrivKey, err := generateKey(opts)
 	if err != nil {
 		log.Fatalf("Failed to generate server private key: %v", err)
 	}
 
 	// Create server certificate signed by CA
-	serverBytes, err := x509.CreateCertificate(rand.Reader, serverCert, ca, &serverPrivKey.PublicKey, caPrivKey)
+	serverBytes, err := x509.CreateCertificate(rand.Reader, serverCert, ca, serverPrivKey.Public(), caPrivKey)
 	if err != nil {
 		log.Fatalf("Failed to create server certificate: %v", err)
 	}
-
-	// Save server certificate
-	serverCertOut, err := os.Create("certs/server.crt")
+	serverKeyBlock, err := keyPEMBlock(serverPrivKey)
 	if err != nil {
-		log.Fatalf("Failed to create server.crt: %v", err)
+		log.Fatalf("Failed to encode server.key: %v", err)
 	}
-	defer serverCertOut.Close()
+	writePEM("certs/server.crt", &pem.Block{Type: "CERTIFICATE", Bytes: serverBytes})
+	writePEM("certs/server.key", serverKeyBlock)
 
-	err = pem.Encode(serverCertOut, &pem.Block{Type: "CERTIFICATE", Bytes: serverBytes})
-	if err != nil {
-		log.Fatalf("Failed to encode server.crt: %v", err)
+	log.Println("All certificates generated successfully.")
+	log.Printf("IMPORTANT: Install %s into your system/browser trust store to avoid certificate errors.", opts.CACert)
+}
+
+// generateCA creates a self-signed CA and writes it to opts.CACert and opts.CAKey
+func generateCA(opts certOptions) (*x509.Certificate, crypto.Signer) {
+	log.Println("Generating Root CA certificate and key...")
+
+	// CA certificate
+	ca := &x509.Certificate{
+		SerialNumber: big.NewInt(2024),
+		Subject: pkix.Name{
+			Organization: []string{"dGateway CA"},
+			CommonName:   "dGateway Root CA",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0), // 10 years
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
 	}
-	log.Println("Generated certs/server.crt")
 
-	// Save server private key
-	serverKeyOut, err := os.Create("certs/server.key")
+	// CA private key
+	caPrivKey, err := generateKey(opts)
 	if err != nil {
-		log.Fatalf("Failed to create server.key: %v", err)
+		log.Fatalf("Failed to generate CA private key: %v", err)
 	}
-	defer serverKeyOut.Close()
 
-	err = pem.Encode(serverKeyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(serverPrivKey)})
+	// Self-signed CA certificate
+	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, caPrivKey.Public(), caPrivKey)
 	if err != nil {
-		log.Fatalf("Failed to encode server.key: %v", err)
+		log.Fatalf("Failed to create CA certificate: %v", err)
 	}
-	log.Println("Generated certs/server.key")
-
-	log.Println("All certificates generated successfully.")
-	log.Println("IMPORTANT: Install certs/ca.crt into your system/browser trust store to avoid certificate errors.")
+	caKeyBlock, err := keyPEMBlock(caPrivKey)
+	if err != nil {
+		log.Fatalf("Failed to encode %s: %v", opts.CAKey, err)
+	}
+	writePEM(opts.CACert, &pem.Block{Type: "CERTIFICATE", Bytes: caBytes})
+	writePEM(opts.CAKey, caKeyBlock)
+	return ca, caPrivKey
 }
 
 func startRecordingHandler(w http.ResponseWriter, r *http.Request) {
@@ -587,8 +1238,10 @@ func getRequestBodyHandler(w http.ResponseWriter, r *http.Request) {
 
 	var reqBody []byte
 	var reqHeaders string
-	row := db.QueryRow("SELECT request_body, request_headers FROM requests WHERE id = ?", id)
-	if err := row.Scan(&reqBody, &reqHeaders); err != nil {
+	var reqURL string
+	var truncated bool
+	row := db.QueryRow("SELECT request_body, request_headers, url, COALESCE(request_body_truncated, 0) FROM requests WHERE id = ?", id)
+	if err := row.Scan(&reqBody, &reqHeaders, &reqURL, &truncated); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Request not found", http.StatusNotFound)
 			return
@@ -598,16 +1251,16 @@ func getRequestBodyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to set appropriate Content-Type
-	contentType := getContentTypeFromHeaders(reqHeaders)
-	if contentType != "" {
-		w.Header().Set("Content-Type", contentType)
-	} else {
-		// Default to plain text if content type is unknown
-		w.Header().Set("Content-Type", "application/octet-stream")
+	// Request bodies are stored decoded unless their encoding was unsupported
+	encoding, decoded := storedEncoding(reqBody, getHeaderFromJSON(reqHeaders, "Content-Encoding"))
+	if decoded != nil && r.URL.Query().Get("raw") != "1" {
+		reqBody, encoding = decoded, ""
 	}
 
-	w.Write(reqBody)
+	if truncated {
+		w.Header().Set("X-Body-Truncated", "true")
+	}
+	writeStoredBody(w, r, id, reqBody, encoding, reqHeaders, reqURL, false)
 }
 
 func getResponseBodyHandler(w http.ResponseWriter, r *http.Request) {
@@ -620,8 +1273,11 @@ func getResponseBodyHandler(w http.ResponseWriter, r *http.Request) {
 
 	var respBody []byte
 	var respHeaders string
-	row := db.QueryRow("SELECT response_body, response_headers FROM requests WHERE id = ?", id)
-	if err := row.Scan(&respBody, &respHeaders); err != nil {
+	var reqURL string
+	var wireSize int
+	var truncated, streamTruncated bool
+	row := db.QueryRow("SELECT response_body, response_headers, url, COALESCE(response_wire_size, 0), COALESCE(response_body_truncated, 0), COALESCE(truncated, 0) FROM requests WHERE id = ?", id)
+	if err := row.Scan(&respBody, &respHeaders, &reqURL, &wireSize, &truncated, &streamTruncated); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Request not found", http.StatusNotFound)
 			return
@@ -631,77 +1287,336 @@ func getResponseBodyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to set appropriate Content-Type
-	contentType := getContentTypeFromHeaders(respHeaders)
-	if contentType != "" {
-		w.Header().Set("Content-Type", contentType)
-	} else {
-		// Default to plain text if content type is unknown
-		w.Header().Set("Content-Type", "application/octet-stream")
+	// Bodies are stored decompressed, except where decompression was skipped
+	// or failed at capture time (partial responses, encodings unsupported
+	// when recorded); those still match the size received over the wire.
+	// ?raw=1 serves them still encoded, labelled with their Content-Encoding.
+	var encoding string
+	if len(respBody) == wireSize {
+		var decoded []byte
+		encoding, decoded = storedEncoding(respBody, getHeaderFromJSON(respHeaders, "Content-Encoding"))
+		if decoded != nil && r.URL.Query().Get("raw") != "1" {
+			respBody, encoding = decoded, ""
+		}
 	}
 
-	w.Write(respBody)
+	// Streamed bodies beyond -stream-capture-bytes are cut as well
+	if truncated || streamTruncated {
+		w.Header().Set("X-Body-Truncated", "true")
+	}
+	writeStoredBody(w, r, id, respBody, encoding, respHeaders, reqURL, true)
 }
 
-func exportHARHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// writeStoredBody writes a stored request or response body, applying the
+// read-time transformations requested through query parameters. The stored
+// bytes themselves are never modified. encoding is the content coding the
+// body is still in, if any.
+func writeStoredBody(w http.ResponseWriter, r *http.Request, id int, body []byte, encoding, headersJSON, reqURL string, isResponse bool) {
+	contentType := getContentTypeFromHeaders(headersJSON)
+	filename := bodyFilename(id, reqURL, headersJSON, isResponse)
+
+	// ?raw=1 returns the stored bytes untouched, e.g. to replay them
+	if r.URL.Query().Get("raw") == "1" {
+		setBodyHeaders(w, r, contentType, filename)
+		writeBody(w, r, body, encoding)
 		return
 	}
 
-	// Get all requests from database
-	rows, err := db.Query("SELECT id, timestamp, method, url, request_headers, request_body, status_code, response_headers, response_body FROM requests ORDER BY timestamp")
-	if err != nil {
-		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
-		log.Printf("Error fetching requests: %v", err)
+	// gRPC-Web bodies are split into their frames, decoding messages when possible
+	if decoded, ok := maybeDecodeGRPCWeb(r, body, contentType, reqURL, isResponse); ok {
+		setBodyHeaders(w, r, "application/json", filename)
+		writeBody(w, r, decoded, "")
 		return
 	}
-	defer rows.Close()
 
-	var requests []RequestLog
-	for rows.Next() {
-		var req RequestLog
-		if err := rows.Scan(&req.ID, &req.Timestamp, &req.Method, &req.URL, &req.RequestHeaders, &req.RequestBody, &req.StatusCode, &req.ResponseHeaders, &req.ResponseBody); err != nil {
-			log.Printf("Error scanning request: %v", err)
-			continue
+	// Protobuf bodies are decoded to JSON on demand when a descriptor is loaded
+	if decoded, ok := maybeDecodeProtobuf(r, body, contentType, reqURL, isResponse); ok {
+		setBodyHeaders(w, r, "application/json", filename)
+		writeBody(w, r, decoded, "")
+		return
+	}
+
+	// Transcode text in other charsets to UTF-8 when asked with ?charset=utf8
+	if r.URL.Query().Get("charset") == "utf8" && encoding == "" {
+		if charset := parseCharset(contentType); !isUTF8Charset(charset) {
+			converted, err := transcodeToUTF8(body, charset)
+			if err != nil {
+				log.Printf("Error transcoding body from %s: %v", charset, err)
+			} else {
+				body = converted
+				contentType = withCharset(contentType, "utf-8")
+			}
 		}
-		requests = append(requests, req)
 	}
 
-	// Convert to HAR format
-	har, err := exportRequestsToHAR(requests)
-	if err != nil {
-		http.Error(w, "Failed to export requests to HAR format", http.StatusInternalServerError)
-		log.Printf("Error exporting to HAR: %v", err)
+	// Re-indent JSON when asked with ?pretty=1, serving malformed JSON as stored
+	if r.URL.Query().Get("pretty") == "1" && encoding == "" && isJSONMediaType(contentType) {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err == nil {
+			body = indented.Bytes()
+		}
+	}
+
+	// Keep the recorded charset, declaring UTF-8 for text that names none
+	setBodyHeaders(w, r, declareCharset(contentType, body), filename)
+	writeBody(w, r, body, encoding)
+}
+
+func exportHARHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Set response headers for file download
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"dgateway-export.har\"")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", harExportFilename(r.URL.Query())))
 
-	// Encode and send HAR file
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(har); err != nil {
-		log.Printf("Error encoding HAR JSON: %v", err)
-		http.Error(w, "Failed to encode HAR file", http.StatusInternalServerError)
-		return
+	// Stream the requests matching the list filters entry by entry
+	where, args := buildRequestFilter(r.URL.Query())
+	if started, err := streamHAR(w, where, args...); err != nil {
+		log.Printf("Error exporting to HAR: %v", err)
+		if !started {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Failed to export requests to HAR format", http.StatusInternalServerError)
+		}
+	}
+}
+
+// harExportFilename names a HAR export after its start_date and end_date
+// filters, e.g. dgateway-export_2024-01-01_to_2024-01-31.har. Dates that are
+// not YYYY-MM-DD are left out of the name.
+func harExportFilename(query url.Values) string {
+	date := func(name string) string {
+		value := query.Get(name)
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return ""
+		}
+		return value
+	}
+
+	name := "dgateway-export"
+	switch start, end := date("start_date"), date("end_date"); {
+	case start != "" && end != "":
+		name += "_" + start + "_to_" + end
+	case start != "":
+		name += "_from_" + start
+	case end != "":
+		name += "_until_" + end
+	}
+	return name + ".har"
+}
+
+// proxyCertFiles returns the certificate and key used for HTTPS, falling back
+// to the CA pair when the server pair has not been generated
+func proxyCertFiles() (string, string) {
+	// Certificate files
+	certFile := "certs/server.crt"
+	keyFile := "certs/server.key"
+
+	// Check if certificate files exist
+	if _, err := os.Stat(certFile); os.IsNotExist(err) {
+		log.Println("Server certificate not found, using default certificates")
+		certFile = "certs/ca.crt"
+		keyFile = "certs/ca.key"
+	} else if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+		log.Println("Server key not found, using default certificates")
+		certFile = "certs/ca.crt"
+		keyFile = "certs/ca.key"
+	}
+	return certFile, keyFile
+}
+
+// serveProxy runs a proxy server on the given port until it fails
+func serveProxy(port int, target string, handler http.Handler, enableHTTPS bool) {
+	if enableHTTPS {
+		log.Printf("Proxy server listening on port %d with HTTPS support, forwarding to %s", port, target)
+
+		// Create server
+		server := &http.Server{
+			Addr:        ":" + strconv.Itoa(port),
+			Handler:     handler,
+			ConnContext: connContext,
+		}
+
+		// With -mitm every host gets its own certificate signed by the CA
+		certFile, keyFile := "", ""
+		if mitmCertificates != nil {
+			server.TLSConfig = &tls.Config{GetCertificate: mitmCertificates.getCertificate}
+		} else {
+			certFile, keyFile = proxyCertFiles()
+		}
+
+		// Start TLS server
+		log.Printf("Server is listening on port %d for HTTPS connections", port)
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
+			log.Fatalf("Failed to start HTTPS proxy server: %v", err)
+		}
+	} else {
+		log.Printf("Proxy server listening on port %d (HTTP only), forwarding to %s", port, target)
+		server := &http.Server{
+			Addr:        ":" + strconv.Itoa(port),
+			Handler:     handler,
+			ConnContext: connContext,
+		}
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("Failed to start HTTP proxy server: %v", err)
+		}
 	}
 }
+
 func main() {
 	port := flag.Int("port", 8080, "port to listen on for proxy")
 	target := flag.String("target", "http://127.0.0.1:8081", "target to forward requests to")
-	dbPath := flag.String("db", "requests.db", "path to SQLite database file")
+	targets := flag.String("targets", "", "comma-separated backends with optional weights balanced by weighted round-robin, e.g. http://a:8081=3,http://b:8081=1 (overrides -target)")
+	dbPath := flag.String("db", "requests.db", "path to SQLite database file, :memory: for a disposable in-memory database, or the DSN of a -db-driver postgres database")
+	dbDriverFlag := flag.String("db-driver", dbDriverSQLite, "storage backend: sqlite or postgres")
+	dbMaxOpenConnsFlag := flag.Int("db-max-open-conns", dbMaxOpenConns, "maximum number of open database connections (0 = unlimited)")
+	dbMaxIdleConnsFlag := flag.Int("db-max-idle-conns", dbMaxIdleConns, "maximum number of idle database connections kept for reuse")
+	dbConnMaxLifetimeFlag := flag.Duration("db-conn-max-lifetime", dbConnMaxLifetime, "close database connections after this long, e.g. to follow PostgreSQL failovers (0 = never)")
 	genCerts := flag.Bool("gen-certs", false, "generate CA and server certificates")
+	caCertFlag := flag.String("ca-cert", "", "CA certificate that -gen-certs signs the server certificate with and -mitm signs host certificates with; -gen-certs generates it when missing (default certs/ca.crt, regenerated by every -gen-certs)")
+	caKeyFlag := flag.String("ca-key", "", "private key of -ca-cert (default certs/ca.key)")
+	certKeyType := flag.String("cert-key-type", certKeyTypeRSA, "key type of the certificates made by -gen-certs: rsa or ecdsa (P-256)")
+	certKeyBits := flag.Int("cert-key-bits", 2048, "RSA key size of the certificates made by -gen-certs")
+	certValidity := flag.Duration("cert-validity", 365*24*time.Hour, "how long the server certificate made by -gen-certs is valid")
+	certHosts := flag.String("cert-hosts", "localhost,127.0.0.1", "comma-separated DNS names and IP addresses the server certificate made by -gen-certs is valid for; the first name is its common name")
 	enableHTTPS := flag.Bool("enable-https", false, "enable HTTPS support on the same port")
+	mitm := flag.Bool("mitm", false, "with -enable-https, serve each requested host a certificate generated on the fly and signed by -ca-cert instead of the single server certificate")
 	recordOnStart := flag.Bool("record-on-start", true, "start recording requests by default")
+	textThresholdFlag := flag.Float64("text-threshold", 0.7, "fraction (0-1) of printable bytes above which a body without a text content type is treated as text")
+	textSampleBytesFlag := flag.Int("text-sample-bytes", 512, "number of leading body bytes inspected when detecting text")
+	streamCaptureBytesFlag := flag.Int("stream-capture-bytes", 1<<20, "maximum number of bytes recorded of a streamed (Server-Sent Events or chunked) response body")
+	bodySampleRate := flag.Float64("body-sample-rate", 1.0, "fraction (0-1) of requests whose full bodies are stored; errors are always stored")
+	protoDescriptor := flag.String("proto-descriptor", "", "path to a protobuf FileDescriptorSet used to decode application/x-protobuf bodies")
+	var protoMaps multiFlag
+	flag.Var(&protoMaps, "proto-map", "map a URL path prefix to protobuf message types, e.g. /api/users=pkg.UserRequest,pkg.UserResponse (repeatable)")
+	authFile := flag.String("auth-file", "", "file of username:bcrypt-hash lines used to verify admin logins instead of ADMIN_PASSWORD")
+	trustedHeader := flag.String("auth-trusted-header", "", "header set by a fronting SSO proxy carrying the authenticated user, e.g. X-Authenticated-User")
+	trustedProxies := flag.String("auth-trusted-proxies", "", "comma-separated IPs/CIDRs allowed to set -auth-trusted-header; required with it")
+	ruleCfg := newRuleConfig()
+	ruleCfg.register(flag.CommandLine)
+	configPath := flag.String("config", "", "file of rule flags (one 'name value' per line) applied on top of the command line and re-read on SIGHUP")
+	var listens multiFlag
+	flag.Var(&listens, "listen", "start an additional proxy on a port forwarding to its own target, e.g. 8082=http://127.0.0.1:9002[,record=false] (repeatable)")
+	var notifies multiFlag
+	flag.Var(&notifies, "notify", "POST a JSON summary of recorded requests matching conditions to a webhook, e.g. status=5xx,url=/pay:https://hooks.example.com/T000 (repeatable)")
+	notifyIntervalFlag := flag.Duration("notify-interval", 10*time.Second, "minimum time between two notifications of the same -notify rule; matches in between are counted")
+	var indexJSONFields multiFlag
+	flag.Var(&indexJSONFields, "index-json-field", "extract a JSON body field into a filterable, sortable column, e.g. response:$.userId=userId (repeatable)")
+	retentionFlag := flag.String("retention", "", "delete requests older than this, e.g. 72h or 30d (default: keep everything)")
+	retentionInterval := flag.Duration("retention-interval", time.Hour, "how often -retention deletes old requests")
+	trustProxyHeadersFlag := flag.Bool("trust-proxy-headers", false, "take the recorded client IP from X-Forwarded-For or X-Real-IP instead of the connection address")
+	forwardHeadersFlag := flag.Bool("forward-headers", true, "add X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host to proxied requests; false forwards the client's headers unchanged")
+	retriesFlag := flag.Int("retries", 0, "resend idempotent requests (GET, HEAD, PUT, DELETE or with an idempotency key) this many times, with exponential backoff, when the upstream cannot be reached or answers 502, 503 or 504")
+	upstreamTimeoutFlag := flag.Duration("upstream-timeout", 0, "timeout for connecting to, TLS handshaking with and awaiting the response headers of the upstream, each; failures answer 504 (0 = wait indefinitely)")
+	maxRequestBodyFlag := flag.Int64("max-request-body", 0, "reject requests whose body exceeds this many bytes with 413 (0 = unlimited)")
+	maxResponseBodyFlag := flag.Int64("max-response-body", 0, "answer 502 instead of forwarding upstream responses whose body exceeds this many bytes; streamed responses are not limited (0 = unlimited)")
+	logBuffer := flag.Int("log-buffer", 100, "number of recorded requests queued for the database writers; requests recorded while the queue is full are dropped and counted in /api/stats")
+	logWorkers := flag.Int("log-workers", 1, "number of goroutines writing recorded requests to the database")
+	logBatchSize := flag.Int("log-batch-size", 100, "maximum number of recorded requests each database writer stores in one transaction")
+	logFlushInterval := flag.Duration("log-flush-interval", 100*time.Millisecond, "longest time a recorded request waits for its batch to fill before being stored")
+	maxBodySizeFlag := flag.Int("max-body-size", 0, "maximum number of bytes stored of each request and response body; longer bodies are cut (0 = unlimited)")
+	sessionTTLFlag := flag.Duration("session-ttl", 24*time.Hour, "how long an admin login stays valid")
+	loginRateLimit := flag.Int("login-rate-limit", 5, "login attempts allowed per client IP per minute before answering 429 (0 = unlimited)")
+	anomalySigmaFlag := flag.Float64("anomaly-sigma", 3, "flag requests whose response time or size is this many standard deviations above the mean for their path (0 = off)")
+	adminGzipFlag := flag.Bool("admin-gzip", true, "gzip the JSON and text responses of the admin API for clients that accept it")
+	check := flag.Bool("check", false, "validate the configuration, print a summary and exit without starting servers")
 	flag.Parse()
 
 	IsRecording = *recordOnStart
+	BodySampleRate = *bodySampleRate
+	anomalySigma = *anomalySigmaFlag
+	textThreshold = *textThresholdFlag
+	textSampleBytes = *textSampleBytesFlag
+	streamCaptureBytes = *streamCaptureBytesFlag
+	sessionTTL = *sessionTTLFlag
+	maxBodySize = *maxBodySizeFlag
+	maxRequestBody = *maxRequestBodyFlag
+	maxResponseBody = *maxResponseBodyFlag
+	trustProxyHeaders = *trustProxyHeadersFlag
+	forwardHeaders = *forwardHeadersFlag
+	adminGzip = *adminGzipFlag
+	dbDriver = *dbDriverFlag
+	dbMaxOpenConns = *dbMaxOpenConnsFlag
+	dbMaxIdleConns = *dbMaxIdleConnsFlag
+	dbConnMaxLifetime = *dbConnMaxLifetimeFlag
+
+	if (*caCertFlag == "") != (*caKeyFlag == "") {
+		log.Fatalf("-ca-cert and -ca-key must be given together")
+	}
 
 	if *genCerts {
-		generateCertificates()
+		if *certKeyType != certKeyTypeRSA && *certKeyType != certKeyTypeECDSA {
+			log.Fatalf("-cert-key-type %q must be rsa or ecdsa", *certKeyType)
+		}
+		if *certKeyType == certKeyTypeRSA && *certKeyBits < 2048 {
+			log.Fatalf("-cert-key-bits %d must be at least 2048", *certKeyBits)
+		}
+		if *certValidity <= 0 {
+			log.Fatalf("-cert-validity %v must be positive", *certValidity)
+		}
+		dnsNames, ips, err := parseCertHosts(*certHosts)
+		if err != nil {
+			log.Fatalf("Invalid -cert-hosts %q: %v", *certHosts, err)
+		}
+		generateCertificates(certOptions{
+			KeyType:  *certKeyType,
+			KeyBits:  *certKeyBits,
+			Validity: *certValidity,
+			DNSNames: dnsNames,
+			IPs:      ips,
+			CACert:   *caCertFlag,
+			CAKey:    *caKeyFlag,
+		})
+		return
+	}
+
+	if *check {
+		ok := runConfigCheck(checkOptions{
+			Port:            *port,
+			Target:          *target,
+			Targets:         *targets,
+			DBPath:          *dbPath,
+			DBDriver:        *dbDriverFlag,
+			DBMaxOpenConns:  *dbMaxOpenConnsFlag,
+			DBMaxIdleConns:  *dbMaxIdleConnsFlag,
+			DBConnLifetime:  *dbConnMaxLifetimeFlag,
+			EnableHTTPS:     *enableHTTPS,
+			MITM:            *mitm,
+			CACert:          *caCertFlag,
+			CAKey:           *caKeyFlag,
+			BodySampleRate:  *bodySampleRate,
+			TextThreshold:   *textThresholdFlag,
+			TextSampleBytes: *textSampleBytesFlag,
+			StreamCapture:   *streamCaptureBytesFlag,
+			SessionTTL:      *sessionTTLFlag,
+			LogBuffer:       *logBuffer,
+			LogWorkers:      *logWorkers,
+			LogBatchSize:    *logBatchSize,
+			LogFlush:        *logFlushInterval,
+			LoginRateLimit:  *loginRateLimit,
+			MaxBodySize:     *maxBodySizeFlag,
+			MaxRequestBody:  *maxRequestBodyFlag,
+			MaxResponseBody: *maxResponseBodyFlag,
+			UpstreamTimeout: *upstreamTimeoutFlag,
+			Retries:         *retriesFlag,
+			Retention:       *retentionFlag,
+			RetentionEvery:  *retentionInterval,
+			ProtoDescriptor: *protoDescriptor,
+			ProtoMaps:       protoMaps,
+			Rules:           ruleCfg,
+			ConfigPath:      *configPath,
+			Listens:         listens,
+			IndexJSONFields: indexJSONFields,
+			Notifies:        notifies,
+			TrustedHeader:   *trustedHeader,
+			TrustedProxies:  *trustedProxies,
+			AuthFile:        *authFile,
+		})
+		if !ok {
+			os.Exit(1)
+		}
 		return
 	}
 
@@ -713,127 +1628,230 @@ func main() {
 	if adminPassword == "" {
 		adminPassword = "admin"
 	}
+	credentials, err := newAdminCredentials(adminUsername, adminPassword, os.Getenv("ADMIN_PASSWORD_HASH"), *authFile)
+	if err != nil {
+		log.Fatalf("Failed to load admin credentials: %v", err)
+	}
+	if credentials.hashed() {
+		log.Printf("Verifying admin logins against %d bcrypt password hash(es)", len(credentials.hashes))
+	}
 
-	// Initialize database
-	InitDB(*dbPath)
+	if *protoDescriptor != "" {
+		if err := loadProtoDescriptor(*protoDescriptor); err != nil {
+			log.Fatalf("Failed to load protobuf descriptor: %v", err)
+		}
+		for _, spec := range protoMaps {
+			mapping, err := parseProtoMapping(spec)
+			if err != nil {
+				log.Fatalf("Failed to parse -proto-map: %v", err)
+			}
+			protoTypeMappings = append(protoTypeMappings, mapping)
+		}
+		log.Printf("Loaded protobuf descriptor %s with %d type mappings", *protoDescriptor, len(protoTypeMappings))
+	}
 
-	// Initialize the request log channel
-	requestLogChan = make(chan RequestLog, 100) // Buffer up to 100 requests
+	if textThreshold < 0 || textThreshold > 1 {
+		log.Fatalf("-text-threshold %v is outside 0-1", textThreshold)
+	}
+	if textSampleBytes <= 0 {
+		log.Fatalf("-text-sample-bytes %d must be positive", textSampleBytes)
+	}
+	if streamCaptureBytes < 0 {
+		log.Fatalf("-stream-capture-bytes %d is negative", streamCaptureBytes)
+	}
+	if maxBodySize < 0 {
+		log.Fatalf("-max-body-size %d is negative", maxBodySize)
+	}
+	if maxRequestBody < 0 {
+		log.Fatalf("-max-request-body %d is negative", maxRequestBody)
+	}
+	if maxResponseBody < 0 {
+		log.Fatalf("-max-response-body %d is negative", maxResponseBody)
+	}
+	if *mitm {
+		if !*enableHTTPS {
+			log.Fatalf("-mitm requires -enable-https")
+		}
+		caCertFile, caKeyFile := defaultCACertFile, defaultCAKeyFile
+		if *caCertFlag != "" {
+			caCertFile, caKeyFile = *caCertFlag, *caKeyFlag
+		}
+		certificates, err := loadLeafCertCache(caCertFile, caKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load the -mitm CA (run -gen-certs first): %v", err)
+		}
+		mitmCertificates = certificates
+	}
+	if *upstreamTimeoutFlag < 0 {
+		log.Fatalf("-upstream-timeout %v is negative", *upstreamTimeoutFlag)
+	}
+	if *retriesFlag < 0 {
+		log.Fatalf("-retries %d is negative", *retriesFlag)
+	}
+	upstreamDialer = newUpstreamDialer(*upstreamTimeoutFlag)
+	upstreamProbeTransport = newUpstreamTransport(*upstreamTimeoutFlag)
+	upstreamTransport = newRetryTransport(upstreamProbeTransport, *retriesFlag)
+	if dbDriver != dbDriverSQLite && dbDriver != dbDriverPostgres {
+		log.Fatalf("-db-driver %q must be sqlite or postgres", dbDriver)
+	}
+	if sessionTTL <= 0 {
+		log.Fatalf("-session-ttl %v must be positive", sessionTTL)
+	}
+	if dbMaxOpenConns < 0 {
+		log.Fatalf("-db-max-open-conns %d is negative", dbMaxOpenConns)
+	}
+	if dbMaxIdleConns < 0 {
+		log.Fatalf("-db-max-idle-conns %d is negative", dbMaxIdleConns)
+	}
+	if dbConnMaxLifetime < 0 {
+		log.Fatalf("-db-conn-max-lifetime %v is negative", dbConnMaxLifetime)
+	}
+	if *logBuffer < 0 {
+		log.Fatalf("-log-buffer %d is negative", *logBuffer)
+	}
+	if *logWorkers <= 0 {
+		log.Fatalf("-log-workers %d must be positive", *logWorkers)
+	}
+	if *logBatchSize <= 0 {
+		log.Fatalf("-log-batch-size %d must be positive", *logBatchSize)
+	}
+	if *logFlushInterval <= 0 {
+		log.Fatalf("-log-flush-interval %v must be positive", *logFlushInterval)
+	}
+	if *loginRateLimit < 0 {
+		log.Fatalf("-login-rate-limit %d is negative", *loginRateLimit)
+	}
 
-	// Start a goroutine to process log entries from the channel
-	go func() {
-		for logEntry := range requestLogChan {
-			LogRequest(logEntry)
+	if *targets != "" {
+		pool, err := parseUpstreamPool(*targets)
+		if err != nil {
+			log.Fatalf("Failed to parse -targets: %v", err)
 		}
-	}()
+		upstreamTargets = pool
+	}
 
-	// --- Proxy Server Setup ---
-	remote, err := url.Parse(*target)
+	effectiveRules := ruleCfg
+	if *configPath != "" {
+		loaded, err := loadRuleConfig(ruleCfg, *configPath)
+		if err != nil {
+			log.Fatalf("Failed to load -config: %v", err)
+		}
+		effectiveRules = loaded
+	}
+	ruleSet, err := buildRuleSet(effectiveRules, nil)
 	if err != nil {
-		log.Fatalf("Failed to parse target URL: %v", err)
+		log.Fatalf("Failed to parse rules: %v", err)
+	}
+	installRules(ruleSet)
+	if len(ruleSet.recordInclude)+len(ruleSet.recordExclude) > 0 {
+		log.Printf("Loaded %d -record-include and %d -record-exclude path patterns", len(ruleSet.recordInclude), len(ruleSet.recordExclude))
+	}
+	if *configPath != "" {
+		// Later edits to the file take effect on SIGHUP without losing captures
+		watchRuleReloads(ruleCfg, *configPath)
+		log.Printf("Send SIGHUP to reload rules from %s", *configPath)
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(remote)
-
-	// Custom response modifier to capture, decompress, and ensure correct headers
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		// Get the request log from context
-		reqLog, ok := resp.Request.Context().Value("reqLog").(*RequestLog)
-		if !ok {
-			log.Printf("Failed to get request log from context")
-			return nil // Not an error for the client, just for our logging
+	for _, spec := range listens {
+		listener, err := parseProxyListener(spec)
+		if err != nil {
+			log.Fatalf("Failed to parse -listen: %v", err)
 		}
+		if listener.Port == *port || listener.Port == *port+1 {
+			log.Fatalf("-listen port %d conflicts with the proxy or admin port", listener.Port)
+		}
+		proxyListeners = append(proxyListeners, listener)
+	}
 
-		// Capture response status code
-		reqLog.StatusCode = resp.StatusCode
-
-		// Capture response headers (do this early to preserve original headers for logging)
-		reqLog.ResponseHeaders = HeadersToJSON(resp.Header)
-
-		// Capture response body
-		body, err := ioutil.ReadAll(resp.Body)
+	for _, spec := range indexJSONFields {
+		field, err := parseJSONFieldIndex(spec)
 		if err != nil {
-			// Log the error and return it to potentially abort the response
-			log.Printf("Error reading response body: %v", err)
-			return err
+			log.Fatalf("Failed to parse -index-json-field: %v", err)
 		}
-		resp.Body.Close() // Important: Close the original body
+		if _, exists := jsonFieldByName(field.Name); exists {
+			log.Fatalf("Duplicate -index-json-field name %q", field.Name)
+		}
+		jsonFieldIndexes = append(jsonFieldIndexes, field)
+	}
 
-		// Decompress response body if gzipped
-		if resp.Header.Get("Content-Encoding") == "gzip" {
-			decompressedBody, err := decompressGzip(body)
-			if err != nil {
-				log.Printf("Error decompressing response body: %v", err)
-				// Continue with compressed body if decompression fails
-				// Do not modify headers in this case
-			} else {
-				body = decompressedBody
-				// Crucial: Remove the Content-Encoding header as the body is now decompressed
-				resp.Header.Del("Content-Encoding")
-				// Crucial: Update Content-Length header as the body size has changed
-				resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
-			}
+	for _, spec := range notifies {
+		rule, err := parseNotifyRule(spec)
+		if err != nil {
+			log.Fatalf("Failed to parse -notify: %v", err)
 		}
+		notifyRules = append(notifyRules, rule)
+	}
+	notifyInterval = *notifyIntervalFlag
 
-		// Store potentially modified body for logging
-		reqLog.ResponseBody = body
+	if *trustedHeader != "" {
+		// No default: the proxy itself connects from loopback, so trusting
+		// it would let any proxy client name its own admin user
+		if strings.TrimSpace(*trustedProxies) == "" {
+			log.Fatalf("-auth-trusted-header requires -auth-trusted-proxies listing the SSO proxy addresses")
+		}
+		proxies, err := parseTrustedProxies(*trustedProxies)
+		if err != nil {
+			log.Fatalf("Failed to parse -auth-trusted-proxies: %v", err)
+		}
+		authTrustedHeader = *trustedHeader
+		authTrustedProxies = proxies
+		log.Printf("Trusting %s header from %d proxy networks for admin authentication", authTrustedHeader, len(authTrustedProxies))
+	}
 
-		// Update response with the (possibly modified) body
-		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	// Initialize database
+	InitDB(*dbPath)
 
-		// Log to database if recording is enabled
-		if IsRecording {
-			select {
-			case requestLogChan <- *reqLog:
-				// Successfully sent to channel
-			default:
-				log.Println("Request log channel is full, dropping log entry.")
-			}
+	if *retentionFlag != "" {
+		retention, err := parseRetention(*retentionFlag)
+		if err != nil {
+			log.Fatalf("Failed to parse -retention: %v", err)
 		}
+		if *retentionInterval <= 0 {
+			log.Fatalf("-retention-interval %v must be positive", *retentionInterval)
+		}
+		log.Printf("Deleting requests older than %v every %v", retention, *retentionInterval)
+		startRetention(retention, *retentionInterval)
+	}
+	startLoginLimiter(*loginRateLimit)
 
-		return nil
+	// Initialize the request log channel
+	requestLogChan = make(chan RequestLog, *logBuffer)
+
+	// Start the goroutines storing log entries from the channel in batches
+	startLogWriters(*logWorkers, *logBatchSize, *logFlushInterval).stopOnSignal()
+
+	// --- Proxy Server Setup ---
+	remote, err := url.Parse(*target)
+	if err != nil {
+		log.Fatalf("Failed to parse target URL: %v", err)
 	}
 
-	proxyHandler := &ProxyHandler{proxy: proxy}
+	proxy := newUpstreamProxy(remote)
+	proxy.Transport = upstreamTransport
+	proxy.ErrorHandler = proxyErrorHandler
 
-	// Start server with HTTPS support if enabled
-	go func() {
-		if *enableHTTPS {
-			log.Printf("Proxy server listening on port %d with HTTPS support, forwarding to %s", *port, *target)
-
-			// Certificate files
-			certFile := "certs/server.crt"
-			keyFile := "certs/server.key"
-
-			// Check if certificate files exist
-			if _, err := os.Stat(certFile); os.IsNotExist(err) {
-				log.Println("Server certificate not found, using default certificates")
-				certFile = "certs/ca.crt"
-				keyFile = "certs/ca.key"
-			} else if _, err := os.Stat(keyFile); os.IsNotExist(err) {
-				log.Println("Server key not found, using default certificates")
-				certFile = "certs/ca.crt"
-				keyFile = "certs/ca.key"
-			}
+	proxy.ModifyResponse = captureResponse
 
-			// Create server
-			server := &http.Server{
-				Addr:    ":" + strconv.Itoa(*port),
-				Handler: proxyHandler,
-			}
+	proxyHandler := &ProxyHandler{proxy: proxy, port: *port, upstreams: upstreamTargets, routed: true}
+	forwardTo := *target
+	if upstreamTargets != nil {
+		forwardTo = upstreamTargets.String()
+	}
+	if ruleSet.routes != nil {
+		forwardTo = ruleSet.routes.String()
+	}
 
-			// Start TLS server
-			log.Printf("Server is listening on port %d for HTTPS connections", *port)
-			if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
-				log.Fatalf("Failed to start HTTPS proxy server: %v", err)
-			}
-		} else {
-			log.Printf("Proxy server listening on port %d (HTTP only), forwarding to %s", *port, *target)
-			if err := http.ListenAndServe(":"+strconv.Itoa(*port), proxyHandler); err != nil {
-				log.Fatalf("Failed to start HTTP proxy server: %v", err)
-			}
-		}
-	}()
+	// Start server with HTTPS support if enabled
+	go serveProxy(*port, forwardTo, proxyHandler, *enableHTTPS)
+
+	// Additional -listen proxies share the capture pipeline and database
+	for _, listener := range proxyListeners {
+		listenerProxy := httputil.NewSingleHostReverseProxy(listener.Target)
+		listenerProxy.ModifyResponse = proxy.ModifyResponse
+		listenerProxy.Transport = upstreamTransport
+		listenerProxy.ErrorHandler = proxyErrorHandler
+		go serveProxy(listener.Port, listener.Target.String(), &ProxyHandler{proxy: listenerProxy, port: listener.Port}, *enableHTTPS)
+	}
 
 	// --- Admin Server Setup ---
 	adminPort := *port + 1
@@ -927,6 +1945,14 @@ func main() {
 		}
 
 		if r.Method == "POST" {
+			// Keyed by the connection address rather than forwarding
+			// headers, which the client could vary to dodge the limit
+			addr := remoteIP(r)
+			if rejectLoginAttempt(w, addr) {
+				log.Printf("Login from %s rejected: too many attempts", addr)
+				return
+			}
+
 			var creds struct {
 				Username string `json:"username"`
 				Password string `json:"password"`
@@ -938,12 +1964,13 @@ func main() {
 			}
 
 			// Authenticate using environment variables or defaults
-			if creds.Username == adminUsername && creds.Password == adminPassword {
-				// In a real app, generate a secure token/session ID
+			if credentials.verify(creds.Username, creds.Password) {
+				loginAttempts.reset(addr)
 				http.SetCookie(w, &http.Cookie{
 					Name:     "session_token",
-					Value:    "valid_token", // Placeholder
+					Value:    sessions.create(sessionTTL),
 					Path:     "/",
+					MaxAge:   int(sessionTTL.Seconds()),
 					HttpOnly: true,
 					Secure:   false, // Set to true in production with HTTPS
 					SameSite: http.SameSiteLaxMode,
@@ -958,22 +1985,52 @@ func main() {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	})
 
+	// Liveness and readiness probes, open to orchestrators without a login
+	adminMux.HandleFunc("/healthz", healthzHandler)
+	adminMux.HandleFunc("/readyz", readyzHandler)
+
 	// Admin API endpoints (protected)
 	adminMux.HandleFunc("/api/requests", authMiddleware(getRequests))
+	adminMux.HandleFunc("/api/requests/recent", authMiddleware(recentRequestsHandler))
+	adminMux.HandleFunc("/api/requests/validate", authMiddleware(validateRequestsHandler))
+	adminMux.HandleFunc("/api/requests/search", authMiddleware(searchRequestsHandler))
+	adminMux.HandleFunc("/api/requests/clear", authMiddleware(clearRequestsHandler))
+	adminMux.HandleFunc("/api/requests/fingerprint", authMiddleware(fingerprintRequestsHandler))
 	adminMux.HandleFunc("/api/requests/body/request/", authMiddleware(getRequestBodyHandler))   // /api/requests/body/request/{id}
 	adminMux.HandleFunc("/api/requests/body/response/", authMiddleware(getResponseBodyHandler)) // /api/requests/body/response/{id}
-	adminMux.HandleFunc("/api/requests/", authMiddleware(getRequestDetail))                     // Trailing slash for ID
+	adminMux.HandleFunc("/api/requests/", authMiddleware(requestItemHandler))                   // /api/requests/{id}[/{action}]
 	adminMux.HandleFunc("/api/replay", authMiddleware(replayRequest))
+	adminMux.HandleFunc("/api/replay/batch", authMiddleware(batchReplayHandler))
+	adminMux.HandleFunc("/api/replay/", authMiddleware(replayStoredRequestHandler)) // /api/replay/{id}
 	adminMux.HandleFunc("/api/start-recording", authMiddleware(startRecordingHandler))
 	adminMux.HandleFunc("/api/stop-recording", authMiddleware(stopRecordingHandler))
 	adminMux.HandleFunc("/api/recording-status", authMiddleware(getRecordingStatusHandler))
+	adminMux.HandleFunc("/api/target/test", authMiddleware(targetTestHandler))
 	adminMux.HandleFunc("/api/export/har", authMiddleware(exportHARHandler))
+	adminMux.HandleFunc("/api/import/har", authMiddleware(importHARHandler))
+	adminMux.HandleFunc("/api/export/postman", authMiddleware(exportPostmanHandler))
+	adminMux.HandleFunc("/api/export/openapi", authMiddleware(exportOpenAPIHandler))
+	adminMux.HandleFunc("/api/export/script", authMiddleware(exportScriptHandler))
+	adminMux.HandleFunc("/api/export/curl", authMiddleware(exportCurlHandler))
+	// The curl form of /api/export/script, whatever lang is asked for
+	adminMux.HandleFunc("/api/export/curl.sh", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		query.Set("lang", "sh")
+		r.URL.RawQuery = query.Encode()
+		exportScriptHandler(w, r)
+	}))
+	adminMux.HandleFunc("/api/export/httpfile", authMiddleware(exportHTTPFileHandler))
+	adminMux.HandleFunc("/api/export/bodies.zip", authMiddleware(exportBodiesZipHandler))
+	adminMux.HandleFunc("/api/stats", authMiddleware(statsHandler))
+	adminMux.HandleFunc("/api/stream", authMiddleware(streamRequestsHandler))
+	adminMux.HandleFunc("/api/connections", authMiddleware(getConnectionsHandler))
+	adminMux.HandleFunc("/api/connections/", authMiddleware(cancelConnectionHandler)) // /api/connections/{id}/cancel
+	adminMux.HandleFunc("/api/sessions/revoke-all", authMiddleware(revokeAllSessionsHandler))
 	adminMux.HandleFunc("/logout", logoutHandler)
 
 	// Root handler for admin interface
 	adminMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil || cookie.Value != "valid_token" {
+		if !isAuthenticated(r) {
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
@@ -990,7 +2047,7 @@ func main() {
 
 	// Print startup information
 	log.Printf("dGateway Proxy Server listening on: http://localhost:%d", *port)
-	log.Printf("Forwarding requests to: %s", *target)
+	log.Printf("Forwarding requests to: %s", forwardTo)
 	log.Printf("dGateway Admin Panel available at: http://localhost:%d", adminPort)
 	if IsRecording {
 		log.Println("Recording mode: ON (requests will be logged)")
@@ -999,7 +2056,7 @@ func main() {
 	}
 
 	log.Printf("Admin server listening on port %d", adminPort)
-	if err := http.ListenAndServe(":"+strconv.Itoa(adminPort), adminMux); err != nil {
+	if err := http.ListenAndServe(":"+strconv.Itoa(adminPort), gzipAdminAPI(adminMux)); err != nil {
 		log.Fatalf("Failed to start admin server: %v", err)
 	}
 }
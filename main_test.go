@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestGetRequestsCountMatchesFilters checks that total_count applies the same
+// URL and date filters as the listed rows
+func TestGetRequestsCountMatchesFilters(t *testing.T) {
+	setupTestDB(t)
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.Local) }
+	var entries []RequestLog
+	for _, row := range []struct {
+		url string
+		at  time.Time
+	}{
+		{"/users/1", day(1)},
+		{"/users/2", day(2)},
+		{"/users/3", day(3)},
+		{"/users/4", day(4)},
+		{"/orders/1", day(2)},
+		{"/orders/2", day(3)},
+	} {
+		entries = append(entries, RequestLog{Timestamp: row.at, Method: "GET", URL: row.url, StatusCode: http.StatusOK})
+	}
+	if stored := store.LogRequests(entries); len(stored) != len(entries) {
+		t.Fatalf("LogRequests stored %d entries, want %d", len(stored), len(entries))
+	}
+
+	const filters = "url=/users&start_date=2024-01-02&end_date=2024-01-03"
+	for _, tt := range []struct {
+		query      string
+		listed     int
+		totalPages int
+	}{
+		{filters, 2, 1},
+		{filters + "&page_size=1&page=2", 1, 2},
+	} {
+		rec := httptest.NewRecorder()
+		getRequests(rec, httptest.NewRequest("GET", "/api/requests?"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		var response struct {
+			Requests   []RequestLog `json:"requests"`
+			TotalCount int          `json:"total_count"`
+			TotalPages int          `json:"total_pages"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Requests) != tt.listed {
+			t.Errorf("%s: listed %d requests, want %d", tt.query, len(response.Requests), tt.listed)
+		}
+		if response.TotalCount != 2 {
+			t.Errorf("%s: total_count = %d, want the 2 matching requests", tt.query, response.TotalCount)
+		}
+		if response.TotalPages != tt.totalPages {
+			t.Errorf("%s: total_pages = %d, want %d", tt.query, response.TotalPages, tt.totalPages)
+		}
+	}
+}
+
+// TestRecentRequestsLimit checks that n above the maximum returns the latest
+// 100 requests instead of the default 20
+func TestRecentRequestsLimit(t *testing.T) {
+	setupTestDB(t)
+	if stored := store.LogRequests(testRequestLogs(150)); len(stored) != 150 {
+		t.Fatalf("LogRequests stored %d entries, want 150", len(stored))
+	}
+
+	for _, tt := range []struct {
+		query string
+		count int
+	}{
+		{"", 20},
+		{"n=5", 5},
+		{"n=100", 100},
+		{"n=101", 100},
+		{"n=1000", 100},
+		{"n=0", 20},
+		{"n=abc", 20},
+	} {
+		rec := httptest.NewRecorder()
+		recentRequestsHandler(rec, httptest.NewRequest("GET", "/api/requests/recent?"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		var response struct {
+			Count int `json:"count"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Count != tt.count {
+			t.Errorf("%q: count = %d, want %d", tt.query, response.Count, tt.count)
+		}
+	}
+}
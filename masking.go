@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// defaultMaskHeaders are the credentials masked in stored headers unless
+// -mask-headers says otherwise
+const defaultMaskHeaders = "Authorization,Proxy-Authorization,Cookie,Set-Cookie"
+
+// maskedValue replaces each value of a masked header or redacted JSON field
+const maskedValue = "***"
+
+// bodyMask replaces every match of a regular expression in stored text bodies
+type bodyMask struct {
+	Name        string // Reported in masks_applied when the mask matched
+	pattern     *regexp.Regexp
+	replacement []byte // May reference groups as $1 or ${name}
+}
+
+// bodyMaskPresets are the built-in mask sets selectable with -mask-preset
+var bodyMaskPresets = map[string][]bodyMask{
+	"pii": {
+		{Name: "pii:email", pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), replacement: []byte("[EMAIL]")},
+		{Name: "pii:credit-card", pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), replacement: []byte("[CARD]")},
+		{Name: "pii:ssn", pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), replacement: []byte("[SSN]")},
+	},
+}
+
+// parseBodyMask parses a "pattern=>replacement" spec, e.g. 'token=\w+=>token=***'.
+// The last "=>" separates the two so patterns may contain it.
+func parseBodyMask(spec string) (bodyMask, error) {
+	idx := strings.LastIndex(spec, "=>")
+	if idx <= 0 {
+		return bodyMask{}, fmt.Errorf("invalid body mask %q, expected pattern=>replacement", spec)
+	}
+	expr := spec[:idx]
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return bodyMask{}, fmt.Errorf("invalid body mask pattern %q: %v", expr, err)
+	}
+	return bodyMask{Name: expr, pattern: pattern, replacement: []byte(spec[idx+2:])}, nil
+}
+
+// parseBodyMaskPreset returns the masks of a comma-separated list of preset names
+func parseBodyMaskPreset(value string) ([]bodyMask, error) {
+	var masks []bodyMask
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		preset, ok := bodyMaskPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown mask preset %q", name)
+		}
+		masks = append(masks, preset...)
+	}
+	return masks, nil
+}
+
+// parseMaskHeaders parses the comma-separated -mask-headers list into a set of
+// canonical header names
+func parseMaskHeaders(value string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		names[http.CanonicalHeaderKey(name)] = true
+	}
+	return names, nil
+}
+
+// maskHeaders replaces the values of the named headers in a stored headers
+// JSON string, returning it unchanged when none of them is present
+func maskHeaders(headersJSON string, names map[string]bool) string {
+	if len(names) == 0 || headersJSON == "" {
+		return headersJSON
+	}
+	var headers http.Header
+	if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil {
+		log.Printf("Error parsing headers to mask: %v", err)
+		return headersJSON
+	}
+
+	masked := false
+	for name, values := range headers {
+		if !names[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for i := range values {
+			values[i] = maskedValue
+		}
+		masked = true
+	}
+	if !masked {
+		return headersJSON
+	}
+	return HeadersToJSON(headers)
+}
+
+// parseRedactJSONFields parses the comma-separated -redact-json-fields list
+func parseRedactJSONFields(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// isJSONMediaType reports whether a Content-Type is JSON, including +json types
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// redactJSONBody replaces the values of the named fields, at any depth and
+// compared case-insensitively, with "***", adding "json:<field>" to applied
+// for each field found. The body is re-encoded only when a field was
+// redacted; bodies that do not parse as JSON are returned unchanged.
+func redactJSONBody(body []byte, fields []string, applied map[string]bool) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Keep numbers exactly as sent
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return body // Trailing data after the JSON value
+	}
+	if !redactJSONValue(value, fields, applied) {
+		return body
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Error encoding redacted JSON body: %v", err)
+		return body
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// redactJSONValue redacts the named fields of a decoded JSON value in place,
+// reporting whether anything was redacted
+func redactJSONValue(value interface{}, fields []string, applied map[string]bool) bool {
+	redacted := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			matched := false
+			for _, field := range fields {
+				if strings.EqualFold(key, field) {
+					v[key] = maskedValue
+					applied["json:"+field] = true
+					matched, redacted = true, true
+					break
+				}
+			}
+			if !matched && redactJSONValue(item, fields, applied) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if redactJSONValue(item, fields, applied) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+// maskBody applies masks to a body, adding the names of the masks that
+// matched to applied
+func maskBody(body []byte, masks []bodyMask, applied map[string]bool) []byte {
+	for _, mask := range masks {
+		if !mask.pattern.Match(body) {
+			continue
+		}
+		body = mask.pattern.ReplaceAll(body, mask.replacement)
+		applied[mask.Name] = true
+	}
+	return body
+}
+
+// maskLogEntry masks the -mask-headers headers and the text bodies of a log
+// entry in place and returns the comma-separated names of the body masks
+// applied, in configuration order, followed by the redacted JSON fields.
+// JSON fields are redacted first, so body masks see the redacted bodies.
+func maskLogEntry(logEntry *RequestLog) string {
+	rules := currentRules()
+	logEntry.RequestHeaders = maskHeaders(logEntry.RequestHeaders, rules.maskHeaders)
+	logEntry.ResponseHeaders = maskHeaders(logEntry.ResponseHeaders, rules.maskHeaders)
+
+	masks := rules.bodyMasks
+	fields := rules.redactJSONFields
+	if len(masks) == 0 && len(fields) == 0 {
+		return ""
+	}
+
+	applied := make(map[string]bool)
+	if len(fields) > 0 {
+		if isJSONMediaType(getContentTypeFromHeaders(logEntry.RequestHeaders)) {
+			logEntry.RequestBody = redactJSONBody(logEntry.RequestBody, fields, applied)
+		}
+		if isJSONMediaType(getContentTypeFromHeaders(logEntry.ResponseHeaders)) {
+			logEntry.ResponseBody = redactJSONBody(logEntry.ResponseBody, fields, applied)
+		}
+	}
+	if logEntry.IsRequestBodyText {
+		logEntry.RequestBody = maskBody(logEntry.RequestBody, masks, applied)
+	}
+	if logEntry.IsResponseBodyText {
+		logEntry.ResponseBody = maskBody(logEntry.ResponseBody, masks, applied)
+	}
+
+	var names []string
+	for _, mask := range masks {
+		if applied[mask.Name] {
+			names = append(names, mask.Name)
+			delete(applied, mask.Name)
+		}
+	}
+	for _, field := range fields {
+		if applied["json:"+field] {
+			names = append(names, "json:"+field)
+			delete(applied, "json:"+field)
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// The CA certificate and key written by -gen-certs and used by -mitm, unless
+// -ca-cert and -ca-key name other files
+const (
+	defaultCACertFile = "certs/ca.crt"
+	defaultCAKeyFile  = "certs/ca.key"
+)
+
+// mitmCertValidity is how long generated certificates are valid; they are
+// regenerated once less than a day is left
+const mitmCertValidity = 365 * 24 * time.Hour
+
+// mitmCertCacheSize is the number of generated certificates kept; the least
+// recently used is dropped to make room for another host
+const mitmCertCacheSize = 1024
+
+// mitmCertificates generates the HTTPS certificates with -mitm; nil serves
+// the single certificate from proxyCertFiles
+var mitmCertificates *leafCertCache
+
+// leafCertCache generates a certificate for each host clients connect to,
+// signed by the CA, and keeps the mitmCertCacheSize most recently used for
+// later connections
+type leafCertCache struct {
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+
+	mu      sync.Mutex
+	certs   map[string]*list.Element // Keyed by server name or IP address, holding *leafCert
+	lru     *list.List               // Cached certificates, most recently used first
+	pending map[string]*leafCertCall // Generations in progress, shared by concurrent handshakes for a host
+}
+
+// leafCert is a cached certificate and the host it was generated for
+type leafCert struct {
+	host string
+	cert *tls.Certificate
+}
+
+// leafCertCall is a certificate generation other handshakes for the same host wait for
+type leafCertCall struct {
+	done chan struct{} // Closed once cert and err are set
+	cert *tls.Certificate
+	err  error
+}
+
+// loadLeafCertCache loads the CA certificate and key that sign the generated certificates
+func loadLeafCertCache(certFile, keyFile string) (*leafCertCache, error) {
+	caCert, caKey, err := loadCA(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &leafCertCache{
+		caCert:  caCert,
+		caKey:   caKey,
+		certs:   make(map[string]*list.Element),
+		lru:     list.New(),
+		pending: make(map[string]*leafCertCall),
+	}, nil
+}
+
+// getCertificate is the tls.Config.GetCertificate callback. Clients without
+// SNI get a certificate for the IP address they connected to.
+func (c *leafCertCache) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" && hello.Conn != nil {
+		host, _, _ = net.SplitHostPort(hello.Conn.LocalAddr().String())
+	}
+	if host == "" {
+		return nil, fmt.Errorf("no server name to generate a certificate for")
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.certs[host]; ok {
+		if cached := elem.Value.(*leafCert); time.Until(cached.cert.Leaf.NotAfter) > 24*time.Hour {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return cached.cert, nil
+		}
+	}
+
+	// Concurrent handshakes for a new host share one certificate
+	if call, ok := c.pending[host]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.cert, call.err
+		case <-hello.Context().Done():
+			return nil, hello.Context().Err()
+		}
+	}
+	call := &leafCertCall{done: make(chan struct{})}
+	c.pending[host] = call
+	c.mu.Unlock()
+
+	// Generated outside the lock so handshakes for other hosts go on meanwhile
+	call.cert, call.err = c.generate(host)
+
+	c.mu.Lock()
+	delete(c.pending, host)
+	if call.err == nil {
+		c.store(host, call.cert)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		log.Printf("Error generating certificate for %s: %v", host, call.err)
+		return nil, call.err
+	}
+	log.Printf("Generated certificate for %s", host)
+	return call.cert, nil
+}
+
+// store caches the certificate of host as the most recently used, dropping
+// the least recently used beyond mitmCertCacheSize. The caller holds c.mu.
+func (c *leafCertCache) store(host string, cert *tls.Certificate) {
+	if elem, ok := c.certs[host]; ok {
+		elem.Value = &leafCert{host: host, cert: cert}
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.certs[host] = c.lru.PushFront(&leafCert{host: host, cert: cert})
+	if c.lru.Len() > mitmCertCacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.certs, oldest.Value.(*leafCert).host)
+	}
+}
+
+// generate creates a certificate for host signed by the CA
+func (c *leafCertCache) generate(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"dGateway MITM"},
+			CommonName:   host,
+		},
+		NotBefore:   now.Add(-time.Hour), // Tolerate clients whose clocks are behind
+		NotAfter:    now.Add(mitmCertValidity),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}
+	if template.NotAfter.After(c.caCert.NotAfter) {
+		template.NotAfter = c.caCert.NotAfter
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.caCert, &key.PublicKey, c.caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, c.caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
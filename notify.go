@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyRule posts a summary of every recorded request matching its
+// conditions to a webhook, at most once per notifyInterval
+type notifyRule struct {
+	Conditions string // As given on the command line, e.g. status=5xx,url=/pay
+	Webhook    string
+	status     string // Exact code (502) or class (5xx); empty matches any
+	urlPart    string // Substring of the request URL; empty matches any
+	method     string // Request method; empty matches any
+
+	mu         sync.Mutex
+	lastSent   time.Time
+	suppressed int // Matches dropped by the rate limit since the last notification
+}
+
+// notifyRules holds the rules configured with -notify
+var notifyRules []*notifyRule
+
+// notifyInterval is the minimum time between two notifications of one rule
+var notifyInterval = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// parseNotifyRule parses a "conditions:webhook" spec such as
+// status=5xx,url=/pay:https://hooks.example.com/T000. Conditions are
+// comma-separated status=, url= and method= terms which must all match.
+func parseNotifyRule(spec string) (*notifyRule, error) {
+	idx := strings.Index(spec, ":http")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid notify rule %q, expected conditions:http(s)://webhook", spec)
+	}
+	rule := &notifyRule{Conditions: spec[:idx], Webhook: spec[idx+1:]}
+	if parsed, err := url.Parse(rule.Webhook); err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid notify webhook %q", rule.Webhook)
+	}
+
+	for _, term := range strings.Split(rule.Conditions, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid notify condition %q, expected key=value", term)
+		}
+		switch key {
+		case "status":
+			if !isStatusPattern(value) {
+				return nil, fmt.Errorf("invalid notify status %q, expected a code like 502 or a class like 5xx", value)
+			}
+			rule.status = strings.ToLower(value)
+		case "url":
+			rule.urlPart = value
+		case "method":
+			rule.method = strings.ToUpper(value)
+		default:
+			return nil, fmt.Errorf("unknown notify condition %q, expected status, url or method", key)
+		}
+	}
+	return rule, nil
+}
+
+// isStatusPattern reports whether value is a three digit status code or a class such as 5xx
+func isStatusPattern(value string) bool {
+	if len(value) != 3 || value[0] < '1' || value[0] > '5' {
+		return false
+	}
+	if strings.EqualFold(value[1:], "xx") {
+		return true
+	}
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+// matches reports whether a log entry satisfies every condition of the rule.
+// The status seen by the client is matched, i.e. after -map-status.
+func (rule *notifyRule) matches(logEntry RequestLog) bool {
+	if rule.status != "" {
+		code := strconv.Itoa(logEntry.ClientStatusCode)
+		if strings.HasSuffix(rule.status, "xx") {
+			if code[:1] != rule.status[:1] {
+				return false
+			}
+		} else if code != rule.status {
+			return false
+		}
+	}
+	if rule.urlPart != "" && !strings.Contains(logEntry.URL, rule.urlPart) {
+		return false
+	}
+	return rule.method == "" || logEntry.Method == rule.method
+}
+
+// allow applies the rate limit, returning whether to send and how many
+// earlier matches were suppressed since the previous notification
+func (rule *notifyRule) allow(now time.Time) (bool, int) {
+	rule.mu.Lock()
+	defer rule.mu.Unlock()
+	if !rule.lastSent.IsZero() && now.Sub(rule.lastSent) < notifyInterval {
+		rule.suppressed++
+		return false, 0
+	}
+	suppressed := rule.suppressed
+	rule.lastSent = now
+	rule.suppressed = 0
+	return true, suppressed
+}
+
+// notifyPayload is the JSON body posted to webhooks. Text makes the payload
+// usable with Slack-compatible incoming webhooks as-is.
+type notifyPayload struct {
+	Text             string    `json:"text"`
+	Rule             string    `json:"rule"`
+	ID               int       `json:"id"`
+	Timestamp        time.Time `json:"timestamp"`
+	Method           string    `json:"method"`
+	URL              string    `json:"url"`
+	StatusCode       int       `json:"status_code"`
+	ClientStatusCode int       `json:"client_status_code"`
+	ListenerPort     int       `json:"listener_port"`
+	HandledBy        string    `json:"handled_by"`
+	DurationMs       int64     `json:"duration_ms"`
+	Suppressed       int       `json:"suppressed"` // Matches not notified because of the rate limit
+}
+
+// notifyMatchingRules posts the log entry to the webhook of every matching
+// rule. Delivery happens in the background so logging is never held up.
+func notifyMatchingRules(logEntry RequestLog) {
+	for _, rule := range notifyRules {
+		if !rule.matches(logEntry) {
+			continue
+		}
+		send, suppressed := rule.allow(time.Now())
+		if !send {
+			continue
+		}
+
+		text := fmt.Sprintf("dGateway: %s %s -> %d (rule %s)", logEntry.Method, logEntry.URL, logEntry.ClientStatusCode, rule.Conditions)
+		if suppressed > 0 {
+			text += fmt.Sprintf(", %d earlier matches suppressed", suppressed)
+		}
+		payload := notifyPayload{
+			Text:             text,
+			Rule:             rule.Conditions,
+			ID:               logEntry.ID,
+			Timestamp:        logEntry.Timestamp,
+			Method:           logEntry.Method,
+			URL:              logEntry.URL,
+			StatusCode:       logEntry.StatusCode,
+			ClientStatusCode: logEntry.ClientStatusCode,
+			ListenerPort:     logEntry.ListenerPort,
+			HandledBy:        logEntry.HandledBy,
+			DurationMs:       logEntry.duration.Milliseconds(),
+			Suppressed:       suppressed,
+		}
+		go postNotification(rule, payload)
+	}
+}
+
+// postNotification sends one notification, logging delivery failures. The
+// webhook URL is kept out of the log since it usually embeds a secret.
+func postNotification(rule *notifyRule, payload notifyPayload) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		log.Printf("Error encoding notification: %v", err)
+		return
+	}
+	resp, err := notifyClient.Post(rule.Webhook, "application/json", &body)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		log.Printf("Error sending notification for rule %s: %v", rule.Conditions, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Notification webhook of rule %s responded with %s", rule.Conditions, resp.Status)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// openAPIBodySamples is how many bodies per operation, status code and media
+// type are merged into the inferred schema
+const openAPIBodySamples = 5
+
+// openAPIMaxExampleBytes is the size above which a body is not used as an example
+const openAPIMaxExampleBytes = 4096
+
+// OpenAPIDocument represents the top-level OpenAPI 3.0 document
+type OpenAPIDocument struct {
+	OpenAPI string                     `json:"openapi"`
+	Info    OpenAPIInfo                `json:"info"`
+	Servers []OpenAPIServer            `json:"servers,omitempty"`
+	Paths   map[string]OpenAPIPathItem `json:"paths"`
+}
+
+// OpenAPIInfo describes the API
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIServer is a base URL the paths are relative to
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+// OpenAPIPathItem maps lowercase HTTP methods to the operations of a path
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+// OpenAPIOperation represents one method of a path
+type OpenAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter represents a path or query parameter
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenAPISchema `json:"schema"`
+	Example  interface{}    `json:"example,omitempty"`
+}
+
+// OpenAPIRequestBody represents the request body of an operation
+type OpenAPIRequestBody struct {
+	Content map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse represents the response for one status code
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType holds the schema and an example body of a media type
+type OpenAPIMediaType struct {
+	Schema  *OpenAPISchema `json:"schema"`
+	Example interface{}    `json:"example,omitempty"`
+}
+
+// OpenAPISchema represents the subset of JSON Schema inferred from samples
+type OpenAPISchema struct {
+	Type       string                    `json:"type,omitempty"`
+	Format     string                    `json:"format,omitempty"`
+	Nullable   bool                      `json:"nullable,omitempty"`
+	Properties map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Items      *OpenAPISchema            `json:"items,omitempty"`
+}
+
+// openAPIOperationSamples accumulates what was observed for one operation
+type openAPIOperationSamples struct {
+	operation  *OpenAPIOperation
+	name       string // Method and path template, e.g. GET /users/{id}
+	count      int
+	pathParams []OpenAPIParameter
+	query      map[string][]string // Observed values of each query parameter
+	bodies     map[string]int      // Bodies merged so far, keyed by "request|type" or "status|type"
+}
+
+// exportRequestsToOpenAPI builds an OpenAPI 3.0 skeleton from captured
+// requests, grouping them by method and path template
+func exportRequestsToOpenAPI(requests []RequestLog) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:       "dGateway Export",
+			Description: fmt.Sprintf("Generated from %d captured requests", len(requests)),
+			Version:     "1.0.0",
+		},
+		Servers: []OpenAPIServer{{URL: targetBaseURL().String()}},
+		Paths:   make(map[string]OpenAPIPathItem),
+	}
+
+	samples := make(map[string]*openAPIOperationSamples)
+	for _, req := range requests {
+		parsedURL, err := url.Parse(req.URL)
+		if err != nil {
+			log.Printf("Skipping request %d in OpenAPI export: %v", req.ID, err)
+			continue
+		}
+		template, pathParams := openAPIPathTemplate(parsedURL.Path)
+		method := strings.ToLower(req.Method)
+
+		pathItem, ok := doc.Paths[template]
+		if !ok {
+			pathItem = make(OpenAPIPathItem)
+			doc.Paths[template] = pathItem
+		}
+		key := method + " " + template
+		sample, ok := samples[key]
+		if !ok {
+			sample = &openAPIOperationSamples{
+				operation: &OpenAPIOperation{
+					Responses: make(map[string]OpenAPIResponse),
+				},
+				name:       strings.ToUpper(method) + " " + template,
+				pathParams: pathParams,
+				query:      make(map[string][]string),
+				bodies:     make(map[string]int),
+			}
+			samples[key] = sample
+			pathItem[method] = sample.operation
+		}
+		sample.count++
+
+		for name, values := range parsedURL.Query() {
+			sample.query[name] = append(sample.query[name], values...)
+		}
+
+		if len(req.RequestBody) > 0 {
+			if sample.operation.RequestBody == nil {
+				sample.operation.RequestBody = &OpenAPIRequestBody{Content: make(map[string]OpenAPIMediaType)}
+			}
+			sample.addBody(sample.operation.RequestBody.Content, "request", req.RequestBody, getContentTypeFromHeaders(req.RequestHeaders))
+		}
+
+		status := strconv.Itoa(req.StatusCode)
+		response, ok := sample.operation.Responses[status]
+		if !ok {
+			response = OpenAPIResponse{Description: http.StatusText(req.StatusCode)}
+			if response.Description == "" {
+				response.Description = "Status " + status
+			}
+		}
+		if len(req.ResponseBody) > 0 {
+			if response.Content == nil {
+				response.Content = make(map[string]OpenAPIMediaType)
+			}
+			sample.addBody(response.Content, status, req.ResponseBody, getContentTypeFromHeaders(req.ResponseHeaders))
+		}
+		sample.operation.Responses[status] = response
+	}
+
+	for _, sample := range samples {
+		sample.finish()
+	}
+	return doc
+}
+
+// addBody merges a body into the schema of its media type in content, scope
+// being request or the status code. The first bodies of each media type are
+// sampled, keeping the first small enough one as the example.
+func (s *openAPIOperationSamples) addBody(content map[string]OpenAPIMediaType, scope string, body []byte, contentType string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	key := scope + "|" + mediaType
+	if s.bodies[key] >= openAPIBodySamples {
+		return
+	}
+	s.bodies[key]++
+
+	var schema *OpenAPISchema
+	var example interface{}
+	var value interface{}
+	switch {
+	case json.Unmarshal(body, &value) == nil:
+		schema, example = inferOpenAPISchema(value), value
+	case isTextData(body, contentType, textThreshold, textSampleBytes):
+		schema, example = &OpenAPISchema{Type: "string"}, string(body)
+	default:
+		schema = &OpenAPISchema{Type: "string", Format: "binary"}
+	}
+
+	media := content[mediaType]
+	media.Schema = mergeOpenAPISchemas(media.Schema, schema)
+	if media.Example == nil && len(body) <= openAPIMaxExampleBytes {
+		media.Example = example
+	}
+	content[mediaType] = media
+}
+
+// finish fills in the summary and parameters once every request has been seen
+func (s *openAPIOperationSamples) finish() {
+	op := s.operation
+	op.Summary = fmt.Sprintf("%s (observed %d times)", s.name, s.count)
+	op.Parameters = append(op.Parameters, s.pathParams...)
+
+	names := make([]string, 0, len(s.query))
+	for name := range s.query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := s.query[name]
+		param := OpenAPIParameter{Name: name, In: "query", Schema: &OpenAPISchema{Type: "string"}, Example: values[0]}
+		if example, err := strconv.ParseInt(values[0], 10, 64); err == nil {
+			param.Schema.Type, param.Example = "integer", example
+			for _, value := range values {
+				if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+					param.Schema.Type, param.Example = "string", values[0]
+					break
+				}
+			}
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+}
+
+// openAPIPathTemplate turns numeric and UUID path segments into parameters:
+// /users/42/orders/7 becomes /users/{id}/orders/{id2}
+func openAPIPathTemplate(path string) (string, []OpenAPIParameter) {
+	if path == "" {
+		return "/", nil
+	}
+	segments := strings.Split(path, "/")
+	var params []OpenAPIParameter
+	for i, segment := range segments {
+		var schema *OpenAPISchema
+		var example interface{}
+		if n, err := strconv.ParseUint(segment, 10, 64); err == nil {
+			schema, example = &OpenAPISchema{Type: "integer"}, n
+		} else if _, err := uuid.Parse(segment); err == nil && len(segment) == 36 {
+			schema, example = &OpenAPISchema{Type: "string", Format: "uuid"}, segment
+		}
+		if schema == nil {
+			continue
+		}
+		name := "id"
+		if len(params) > 0 {
+			name = fmt.Sprintf("id%d", len(params)+1)
+		}
+		segments[i] = "{" + name + "}"
+		params = append(params, OpenAPIParameter{Name: name, In: "path", Required: true, Schema: schema, Example: example})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// inferOpenAPISchema describes a decoded JSON value
+func inferOpenAPISchema(value interface{}) *OpenAPISchema {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+		for name, property := range v {
+			schema.Properties[name] = inferOpenAPISchema(property)
+		}
+		return schema
+	case []interface{}:
+		schema := &OpenAPISchema{Type: "array"}
+		for _, item := range v {
+			schema.Items = mergeOpenAPISchemas(schema.Items, inferOpenAPISchema(item))
+		}
+		if schema.Items == nil {
+			schema.Items = &OpenAPISchema{}
+		}
+		return schema
+	case float64:
+		if v == math.Trunc(v) {
+			return &OpenAPISchema{Type: "integer"}
+		}
+		return &OpenAPISchema{Type: "number"}
+	case string:
+		return &OpenAPISchema{Type: "string"}
+	case bool:
+		return &OpenAPISchema{Type: "boolean"}
+	}
+	return &OpenAPISchema{Nullable: true}
+}
+
+// mergeOpenAPISchemas combines the schemas of two samples: object properties
+// are united, integers widen to numbers and null makes a schema nullable.
+// Schemas without a type (null or conflicting samples) take the other's type.
+func mergeOpenAPISchemas(a, b *OpenAPISchema) *OpenAPISchema {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	var merged OpenAPISchema
+	switch {
+	case a.Type == "":
+		merged = *b
+	case b.Type == "":
+		merged = *a
+	case a.Type == b.Type:
+		merged = *a
+		if a.Format != b.Format {
+			merged.Format = ""
+		}
+		if a.Type == "object" {
+			merged.Properties = make(map[string]*OpenAPISchema)
+			for name, property := range a.Properties {
+				merged.Properties[name] = property
+			}
+			for name, property := range b.Properties {
+				merged.Properties[name] = mergeOpenAPISchemas(merged.Properties[name], property)
+			}
+		}
+		if a.Type == "array" {
+			merged.Items = mergeOpenAPISchemas(a.Items, b.Items)
+		}
+	case (a.Type == "integer" || a.Type == "number") && (b.Type == "integer" || b.Type == "number"):
+		merged.Type = "number"
+	}
+	merged.Nullable = a.Nullable || b.Nullable
+	return &merged
+}
+
+// exportOpenAPIHandler handles GET /api/export/openapi, generating an OpenAPI
+// 3.0 skeleton from every request matching the list filters
+func exportOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, ok := filteredRequests(w, r)
+	if !ok {
+		return
+	}
+
+	// Set response headers for file download
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"dgateway-openapi.json\"")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(exportRequestsToOpenAPI(requests)); err != nil {
+		log.Printf("Error encoding OpenAPI JSON: %v", err)
+		http.Error(w, "Failed to encode OpenAPI document", http.StatusInternalServerError)
+		return
+	}
+}
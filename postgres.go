@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Values of -db-driver
+const (
+	dbDriverSQLite   = "sqlite"
+	dbDriverPostgres = "postgres"
+)
+
+// dbDriver selects the storage backend; -db holds its DSN
+var dbDriver = dbDriverSQLite
+
+// postgresDriverName is the database/sql name of postgresDriver
+const postgresDriverName = "dgateway-postgres"
+
+func init() {
+	sql.Register(postgresDriverName, postgresDriver{})
+}
+
+const postgresCreateTableSQL = `
+	CREATE TABLE IF NOT EXISTS requests (
+		id BIGSERIAL PRIMARY KEY,
+		timestamp TIMESTAMPTZ,
+		method TEXT,
+		url TEXT,
+		request_headers TEXT,
+		request_body BYTEA,
+		status_code INTEGER,
+		response_headers TEXT,
+		response_body BYTEA
+	);
+	`
+
+// postgresURLIndexSQL indexes the lowercased url for the LIKE 'x%' prefix
+// lookups of the url_prefix filter, which lowercases both sides to ignore
+// case as SQLite does and which a default collation index cannot serve
+const postgresURLIndexSQL = "CREATE INDEX IF NOT EXISTS idx_requests_url_lower ON requests(lower(url) text_pattern_ops);"
+
+// postgresErrorCondition is the PostgreSQL form of requestErrorCondition.
+// Stored headers are not validated in SQL; /api/requests/validate checks them.
+const postgresErrorCondition = `(COALESCE(body_error, '') != '')`
+
+// postgresStore stores the requests in a shared PostgreSQL database, for
+// -db-driver postgres
+type postgresStore struct {
+	sqlStore
+}
+
+// openPostgresStore connects to the PostgreSQL database of the DSN
+func openPostgresStore(dataSourceName string) *postgresStore {
+	// lib/pq does not support LastInsertId, so the INSERT returns the id
+	s := &postgresStore{sqlStore{db: openDB(postgresDriverName, dataSourceName, false), returnsID: true}}
+	migrateSchema(s.db, s, postgresCreateTableSQL, postgresURLIndexSQL)
+	s.prepareInsert()
+	return s
+}
+
+// likeOperator is ILIKE, PostgreSQL's LIKE matching case
+func (s *postgresStore) likeOperator() string {
+	return "ILIKE"
+}
+
+// urlPrefixCondition lowercases both sides, as ILIKE cannot use the index on lower(url)
+func (s *postgresStore) urlPrefixCondition() string {
+	return "lower(url) LIKE lower(?) ESCAPE '\\'"
+}
+
+func (s *postgresStore) errorCondition() string {
+	return postgresErrorCondition
+}
+
+// postgresNumberPattern matches the JSON numbers stored in the TEXT
+// -index-json-field columns
+const postgresNumberPattern = `^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`
+
+// jsonFieldNumber casts the values that are JSON numbers, leaving the others NULL
+func (s *postgresStore) jsonFieldNumber(column string) string {
+	return fmt.Sprintf("(CASE WHEN %s ~ '%s' THEN CAST(%s AS NUMERIC) END)", column, postgresNumberPattern, column)
+}
+
+// jsonFieldOrder sorts numbers numerically ahead of the text values, as
+// SQLite does for ascending sorts
+func (s *postgresStore) jsonFieldOrder(column, direction string) string {
+	return s.jsonFieldNumber(column) + " " + direction + " NULLS LAST, " + column + " " + direction + " NULLS LAST"
+}
+
+// postgresDriver wraps lib/pq so the SQLite flavoured queries shared by both
+// stores, and those run on db by the exports, stats and search, run unchanged: ? placeholders are rewritten to $1, $2, ... and
+// booleans are stored as 0/1, matching the SMALLINT columns they live in.
+type postgresDriver struct{}
+
+func (postgresDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := (&pq.Driver{}).Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return postgresConn{conn}, nil
+}
+
+// postgresConn only exposes Prepare, so database/sql sends every query
+// through the rewriting below
+type postgresConn struct {
+	driver.Conn
+}
+
+func (c postgresConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(rebindPostgres(query))
+	if err != nil {
+		return nil, err
+	}
+	return postgresStmt{stmt}, nil
+}
+
+type postgresStmt struct {
+	driver.Stmt
+}
+
+// CheckNamedValue stores booleans as integers and leaves everything else to
+// the default conversion
+func (postgresStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if b, ok := nv.Value.(bool); ok {
+		nv.Value = int64(0)
+		if b {
+			nv.Value = int64(1)
+		}
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// rebindPostgres replaces the ? placeholders outside string literals with
+// PostgreSQL's numbered $n placeholders
+func rebindPostgres(query string) string {
+	var b strings.Builder
+	inString := false
+	n := 0
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// columnType maps the SQLite column types used by the migrations to
+// PostgreSQL. Booleans become SMALLINT because queries compare them with 0
+// and 1, and the loosely typed NUMERIC JSON field columns become TEXT, cast
+// by jsonFieldNumber where numbers are compared or sorted.
+func (s *postgresStore) columnType(columnType string) string {
+	switch columnType {
+	case "INTEGER":
+		return "BIGINT"
+	case "BOOLEAN":
+		return "SMALLINT"
+	case "BLOB":
+		return "BYTEA"
+	case "DATETIME":
+		return "TIMESTAMPTZ"
+	case "NUMERIC":
+		return "TEXT"
+	}
+	return columnType
+}
+
+// columnExists looks the column up in information_schema
+func (s *postgresStore) columnExists(tx *sql.Tx, tableName, columnName string) bool {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?)", tableName, columnName).Scan(&exists)
+	if err != nil {
+		log.Fatalf("Failed to query table info for %s: %v", tableName, err)
+	}
+	return exists
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRebindPostgres(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM requests WHERE id = ?", "SELECT * FROM requests WHERE id = $1"},
+		{"UPDATE requests SET origin = ? WHERE origin IS NULL AND source = ?", "UPDATE requests SET origin = $1 WHERE origin IS NULL AND source = $2"},
+		{"SELECT '?' WHERE url LIKE ? ESCAPE '\\'", "SELECT '?' WHERE url LIKE $1 ESCAPE '\\'"},
+		{"SELECT 'it''s?' = ?", "SELECT 'it''s?' = $1"},
+	}
+	for _, tt := range tests {
+		if got := rebindPostgres(tt.query); got != tt.want {
+			t.Errorf("rebindPostgres(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// withPostgresJSONField builds queries as the PostgreSQL store does, with a
+// json_n field configured
+func withPostgresJSONField(t *testing.T) {
+	savedStore, savedFields := store, jsonFieldIndexes
+	t.Cleanup(func() { store, jsonFieldIndexes = savedStore, savedFields })
+	store = &postgresStore{}
+	field, err := parseJSONFieldIndex("response:$.n=n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonFieldIndexes = []jsonFieldIndex{field}
+}
+
+// TestPostgresJSONFieldNumbers checks that the TEXT json_ columns are sorted
+// and compared as numbers once rebound for PostgreSQL, so "10" follows "9"
+func TestPostgresJSONFieldNumbers(t *testing.T) {
+	withPostgresJSONField(t)
+	const number = `(CASE WHEN json_n ~ '^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$' THEN CAST(json_n AS NUMERIC) END)`
+
+	for _, tt := range []struct {
+		sort string
+		want string
+	}{
+		{"json_n_asc", number + " ASC NULLS LAST, json_n ASC NULLS LAST, timestamp DESC"},
+		{"json_n_desc", number + " DESC NULLS LAST, json_n DESC NULLS LAST, timestamp DESC"},
+	} {
+		query := rebindPostgres("SELECT id FROM requests WHERE id > ? ORDER BY " + requestListOrder(url.Values{"sort": {tt.sort}}))
+		if want := "SELECT id FROM requests WHERE id > $1 ORDER BY " + tt.want; query != want {
+			t.Errorf("sort=%s:\n got %s\nwant %s", tt.sort, query, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{float64(9), number + " > $1"},
+		{"9", "json_n > $1"},
+	} {
+		compiler := &searchCompiler{}
+		condition, err := compiler.compile(searchNode{Field: "json_n", Op: "gt", Value: tt.value}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rebindPostgres(condition); got != tt.want {
+			t.Errorf("json_n gt %#v:\n got %s\nwant %s", tt.value, got, tt.want)
+		}
+	}
+}
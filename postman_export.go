@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// postmanSchema identifies the Postman Collection v2.1 format
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// PostmanCollection represents the top-level Postman collection
+type PostmanCollection struct {
+	Info PostmanInfo   `json:"info"`
+	Item []PostmanItem `json:"item"`
+}
+
+// PostmanInfo describes the collection
+type PostmanInfo struct {
+	PostmanID string `json:"_postman_id"`
+	Name      string `json:"name"`
+	Schema    string `json:"schema"`
+}
+
+// PostmanItem represents a single request of the collection
+type PostmanItem struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Request     PostmanRequest `json:"request"`
+}
+
+// PostmanRequest represents the request of an item
+type PostmanRequest struct {
+	Method string          `json:"method"`
+	Header []PostmanHeader `json:"header"`
+	Body   *PostmanBody    `json:"body,omitempty"`
+	URL    PostmanURL      `json:"url"`
+}
+
+// PostmanHeader represents a request header
+type PostmanHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PostmanBody represents a raw request body
+type PostmanBody struct {
+	Mode string `json:"mode"`
+	Raw  string `json:"raw"`
+}
+
+// PostmanURL represents a request URL split into its parts
+type PostmanURL struct {
+	Raw      string              `json:"raw"`
+	Protocol string              `json:"protocol,omitempty"`
+	Host     []string            `json:"host,omitempty"`
+	Port     string              `json:"port,omitempty"`
+	Path     []string            `json:"path,omitempty"`
+	Query    []PostmanQueryParam `json:"query,omitempty"`
+}
+
+// PostmanQueryParam represents a query string parameter
+type PostmanQueryParam struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// exportRequestsToPostman exports requests to a Postman collection, with URLs
+// resolved against the target of the listener that received each request
+func exportRequestsToPostman(requests []RequestLog) *PostmanCollection {
+	collection := &PostmanCollection{
+		Info: PostmanInfo{
+			PostmanID: uuid.New().String(),
+			Name:      "dGateway Export",
+			Schema:    postmanSchema,
+		},
+		Item: make([]PostmanItem, len(requests)),
+	}
+
+	for i, req := range requests {
+		targetURL, err := resolveTargetURL(req.URL, req.ListenerPort)
+		if err != nil {
+			targetURL = req.URL
+		}
+
+		item := PostmanItem{
+			Name: fmt.Sprintf("%s %s", req.Method, req.URL),
+			Request: PostmanRequest{
+				Method: req.Method,
+				Header: []PostmanHeader{},
+				URL:    postmanURL(targetURL),
+			},
+		}
+
+		// Headers Postman sets itself are left out, as in exported scripts
+		names, headers := scriptHeaders(req)
+		for _, name := range names {
+			for _, value := range headers[name] {
+				item.Request.Header = append(item.Request.Header, PostmanHeader{Key: name, Value: value})
+			}
+		}
+
+		// Raw bodies are text; binary ones are pointed to instead
+		if len(req.RequestBody) > 0 {
+			if isTextData(req.RequestBody, getContentTypeFromHeaders(req.RequestHeaders), textThreshold, textSampleBytes) {
+				item.Request.Body = &PostmanBody{Mode: "raw", Raw: string(req.RequestBody)}
+			} else {
+				item.Description = fmt.Sprintf("Binary request body of %d bytes not included, download it from /api/requests/body/request/%d?raw=1", len(req.RequestBody), req.ID)
+			}
+		}
+
+		collection.Item[i] = item
+	}
+
+	return collection
+}
+
+// postmanURL splits a URL into the parts of a Postman URL object
+func postmanURL(rawURL string) PostmanURL {
+	result := PostmanURL{Raw: rawURL}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return result
+	}
+
+	result.Protocol = parsedURL.Scheme
+	if hostname := parsedURL.Hostname(); hostname != "" {
+		result.Host = strings.Split(hostname, ".")
+	}
+	result.Port = parsedURL.Port()
+	if path := strings.TrimPrefix(parsedURL.EscapedPath(), "/"); path != "" {
+		result.Path = strings.Split(path, "/")
+	}
+	// Keep the order and encoding of the captured query string
+	for _, pair := range strings.Split(parsedURL.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		result.Query = append(result.Query, PostmanQueryParam{Key: key, Value: value})
+	}
+	return result
+}
+
+// exportPostmanHandler handles GET /api/export/postman, exporting every
+// request matching the list filters as a Postman Collection v2.1 document
+func exportPostmanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, ok := filteredRequests(w, r)
+	if !ok {
+		return
+	}
+
+	// Set response headers for file download
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"dgateway-export.postman_collection.json\"")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(exportRequestsToPostman(requests)); err != nil {
+		log.Printf("Error encoding Postman collection JSON: %v", err)
+		http.Error(w, "Failed to encode Postman collection", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoFiles holds the message descriptors loaded via -proto-descriptor
+var protoFiles *protoregistry.Files
+
+// protoTypeMappings maps URL path prefixes to protobuf message types
+var protoTypeMappings []protoTypeMapping
+
+// protoTypeMapping associates a URL path prefix with request and response message types
+type protoTypeMapping struct {
+	PathPrefix   string
+	RequestType  string
+	ResponseType string
+}
+
+// loadProtoDescriptor loads a serialized FileDescriptorSet (as produced by
+// `protoc --include_imports --descriptor_set_out=file.pb`)
+func loadProtoDescriptor(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read descriptor file: %v", err)
+	}
+
+	var fdset descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &fdset); err != nil {
+		return fmt.Errorf("failed to parse descriptor set: %v", err)
+	}
+
+	files, err := protodesc.NewFiles(&fdset)
+	if err != nil {
+		return fmt.Errorf("failed to build descriptors: %v", err)
+	}
+
+	protoFiles = files
+	return nil
+}
+
+// parseProtoMapping parses a "/path/prefix=pkg.Request[,pkg.Response]" mapping.
+// When only one type is given it is used for both the request and the response.
+func parseProtoMapping(spec string) (protoTypeMapping, error) {
+	prefix, types, ok := strings.Cut(spec, "=")
+	if !ok || prefix == "" || types == "" {
+		return protoTypeMapping{}, fmt.Errorf("invalid proto mapping %q, expected /path=pkg.Request[,pkg.Response]", spec)
+	}
+
+	mapping := protoTypeMapping{PathPrefix: prefix}
+	reqType, respType, hasResp := strings.Cut(types, ",")
+	mapping.RequestType = strings.TrimSpace(reqType)
+	mapping.ResponseType = mapping.RequestType
+	if hasResp {
+		mapping.ResponseType = strings.TrimSpace(respType)
+	}
+	return mapping, nil
+}
+
+// isProtobufContentType reports whether the content type denotes a protobuf body
+func isProtobufContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return true
+	}
+	return false
+}
+
+// protoMessageType resolves the message type for a body, preferring a
+// messageType/proto parameter on the content type and falling back to the
+// longest matching URL path prefix mapping.
+func protoMessageType(contentType, rawURL string, isResponse bool) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if messageType := params["messagetype"]; messageType != "" {
+			return messageType
+		}
+		if messageType := params["proto"]; messageType != "" {
+			return messageType
+		}
+	}
+
+	path := rawURL
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		path = parsedURL.Path
+	}
+
+	var best *protoTypeMapping
+	for i := range protoTypeMappings {
+		mapping := &protoTypeMappings[i]
+		if strings.HasPrefix(path, mapping.PathPrefix) && (best == nil || len(mapping.PathPrefix) > len(best.PathPrefix)) {
+			best = mapping
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	if isResponse {
+		return best.ResponseType
+	}
+	return best.RequestType
+}
+
+// decodeProtobufToJSON decodes a protobuf message of the given type into JSON
+func decodeProtobufToJSON(data []byte, messageType string) ([]byte, error) {
+	if protoFiles == nil {
+		return nil, fmt.Errorf("no protobuf descriptor loaded")
+	}
+
+	desc, err := protoFiles.FindDescriptorByName(protoreflect.FullName(messageType))
+	if err != nil {
+		return nil, fmt.Errorf("unknown message type %s: %v", messageType, err)
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", messageType)
+	}
+
+	msg := dynamicpb.NewMessage(msgDesc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", messageType, err)
+	}
+
+	return protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(msg)
+}
+
+// maybeDecodeProtobuf decodes a stored protobuf body to JSON for display. The
+// message type can be forced with the proto_type query parameter. It returns
+// false when the body is not protobuf or cannot be decoded.
+func maybeDecodeProtobuf(r *http.Request, body []byte, contentType, rawURL string, isResponse bool) ([]byte, bool) {
+	if protoFiles == nil || !isProtobufContentType(contentType) {
+		return nil, false
+	}
+
+	messageType := r.URL.Query().Get("proto_type")
+	if messageType == "" {
+		messageType = protoMessageType(contentType, rawURL, isResponse)
+	}
+	if messageType == "" {
+		return nil, false
+	}
+
+	decoded, err := decodeProtobufToJSON(body, messageType)
+	if err != nil {
+		log.Printf("Error decoding protobuf body: %v", err)
+		return nil, false
+	}
+	return decoded, true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// headerRecordRule matches responses carrying a header, optionally with a specific value
+type headerRecordRule struct {
+	Name  string
+	Value string // Empty means the header only has to be present
+}
+
+// parseHeaderRecordRule parses a "Name:Value" or "Name" rule
+func parseHeaderRecordRule(spec string) (headerRecordRule, error) {
+	name, value, _ := strings.Cut(spec, ":")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return headerRecordRule{}, fmt.Errorf("invalid header rule %q, expected Name:Value", spec)
+	}
+	return headerRecordRule{Name: http.CanonicalHeaderKey(name), Value: strings.TrimSpace(value)}, nil
+}
+
+// String returns the rule in its command line form
+func (rule headerRecordRule) String() string {
+	if rule.Value == "" {
+		return rule.Name
+	}
+	return rule.Name + ":" + rule.Value
+}
+
+// matches reports whether the headers satisfy the rule
+func (rule headerRecordRule) matches(headers http.Header) bool {
+	values, ok := headers[rule.Name]
+	if !ok {
+		return false
+	}
+	if rule.Value == "" {
+		return true
+	}
+	for _, value := range values {
+		if strings.EqualFold(strings.TrimSpace(value), rule.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRecordResponse applies the -record-if-header rules to an upstream
+// response. Without rules every response is recorded; otherwise any match
+// does, and the matching rule is returned as Name or Name:Value.
+func shouldRecordResponse(resp *http.Response) (bool, string) {
+	rules := currentRules()
+	if len(rules.recordIfHeaders) == 0 {
+		return true, ""
+	}
+	for _, rule := range rules.recordIfHeaders {
+		if rule.matches(resp.Header) {
+			return true, rule.String()
+		}
+	}
+	return false, ""
+}
+
+// markForRecording selects the exchange for logging when it is recordable and
+// the response passes the -record-if-header rules
+func markForRecording(reqLog *RequestLog, resp *http.Response) {
+	record, matchedRule := shouldRecordResponse(resp)
+	reqLog.record = reqLog.recordable() && record
+	if matchedRule != "" {
+		reqLog.addDecision(decisionRecord, "response matched -record-if-header %s", matchedRule)
+	}
+}
+
+// parsePathPatterns compiles a comma-separated list of URL path regular expressions
+func parsePathPatterns(list string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// recordsPath applies -record-exclude and -record-include to a URL path.
+// Excluding wins; with include patterns only matching paths are recorded.
+func (rules *ruleSet) recordsPath(urlPath string) bool {
+	for _, re := range rules.recordExclude {
+		if re.MatchString(urlPath) {
+			return false
+		}
+	}
+	if len(rules.recordInclude) == 0 {
+		return true
+	}
+	for _, re := range rules.recordInclude {
+		if re.MatchString(urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordable reports whether the entry may be logged: its route or listener
+// is recording and its path was not ruled out by -record-include/-record-exclude.
+// The record attribute of a route overrides that of the listener, which
+// overrides the global IsRecording.
+func (l *RequestLog) recordable() bool {
+	if l.pathExcluded {
+		return false
+	}
+	if l.routeRecord != nil {
+		return *l.routeRecord
+	}
+	return isRecordingPort(l.ListenerPort)
+}
+
+// parseContentTypePatterns splits a comma-separated glob list, validating each pattern
+func parseContentTypePatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid content type pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// matchesContentType reports whether the media type matches any of the patterns
+func matchesContentType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldCaptureBody applies -skip-content-types and -capture-content-types to
+// a body's Content-Type. Skipping wins; with a capture list only matches are kept.
+func shouldCaptureBody(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	rules := currentRules()
+	if matchesContentType(rules.skipContentTypes, mediaType) {
+		return false
+	}
+	return len(rules.captureContentTypes) == 0 || matchesContentType(rules.captureContentTypes, mediaType)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// batchReplayResult is the outcome of one request of a batch replay
+type batchReplayResult struct {
+	ID             int    `json:"id"`
+	Method         string `json:"method,omitempty"`
+	URL            string `json:"url,omitempty"` // URL the request was replayed to
+	StatusCode     int    `json:"statusCode"`
+	OriginalStatus int    `json:"original_status"`
+	Matches        *bool  `json:"matches"` // Same status code and body as recorded, null when unknown, see replayDiff
+	Error          string `json:"error,omitempty"`
+	DurationMs     int64  `json:"duration_ms"`
+}
+
+// batchReplayHandler handles POST /api/replay/batch with a body such as
+// {"ids": [1, 2, 3], "target": "http://staging:8081"}, replaying the stored
+// requests one after another in the given order. Relative URLs are resolved
+// against target, or without one against the target of the listener that
+// received each request.
+func batchReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch struct {
+		IDs    []int  `json:"ids"`
+		Target string `json:"target"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&batch); err != nil {
+		http.Error(w, jsonBodyError(err), http.StatusBadRequest)
+		return
+	}
+	if len(batch.IDs) == 0 {
+		http.Error(w, "No request IDs given", http.StatusBadRequest)
+		return
+	}
+
+	var target *url.URL
+	if batch.Target != "" {
+		parsed, err := url.Parse(batch.Target)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			http.Error(w, fmt.Sprintf("Invalid target %q, expected an absolute URL such as http://host:8081", batch.Target), http.StatusBadRequest)
+			return
+		}
+		target = parsed
+	}
+
+	results := make([]batchReplayResult, 0, len(batch.IDs))
+	matched, unknown := 0, 0
+	for _, id := range batch.IDs {
+		result := replayStoredRequest(id, target)
+		switch {
+		case result.Matches == nil:
+			unknown++
+		case *result.Matches:
+			matched++
+		}
+		results = append(results, result)
+	}
+	log.Printf("Batch replay of %d requests: %d matched, %d differed, %d could not be compared", len(results), matched, len(results)-matched-unknown, unknown)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// replayStoredRequest replays one stored request of a batch and compares the
+// response with the recorded one
+func replayStoredRequest(id int, target *url.URL) batchReplayResult {
+	result := batchReplayResult{ID: id}
+
+	requests, err := getRequestLogs(" AND id = ?", id)
+	if err != nil {
+		log.Printf("Error fetching request %d: %v", id, err)
+		result.Error = "failed to fetch request"
+		return result
+	}
+	if len(requests) == 0 {
+		result.Error = "request not found"
+		return result
+	}
+	stored := requests[0]
+	result.Method = stored.Method
+	result.OriginalStatus = stored.StatusCode
+
+	replayReq, err := newStoredReplayRequest(stored, target)
+	if err != nil {
+		log.Printf("Error creating replay request for request %d: %v", id, err)
+		result.Error = fmt.Sprintf("failed to create replay request: %v", err)
+		return result
+	}
+	result.URL = replayReq.URL.String()
+
+	replayed := executeReplay(replayReq, result.URL, nil, &stored, false)
+	result.StatusCode = replayed.StatusCode
+	result.Error = replayed.Error
+	result.DurationMs = replayed.DurationMs
+	if replayed.Diff != nil {
+		result.Matches = replayed.Diff.Matches
+	} else {
+		// A failed replay differs from any recorded response
+		result.Matches = new(bool)
+	}
+	return result
+}
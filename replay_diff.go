@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// replayDiffIgnoredHeaders differ between any two responses and are left out
+// of the header comparison
+var replayDiffIgnoredHeaders = map[string]bool{
+	"Date":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+}
+
+// replayDiffContext is the number of unchanged lines around each hunk of a body diff
+const replayDiffContext = 3
+
+// maxDiffCells bounds the size of the table used to find the smallest line
+// diff; larger changes are shown as the old lines removed and the new added
+const maxDiffCells = 4 << 20
+
+// replayDiff compares a replayed response with the recorded one
+type replayDiff struct {
+	Matches        *bool      `json:"matches"` // Same status code and body, null when the status matches but the body cannot be compared; headers are informational
+	StatusChanged  bool       `json:"status_changed"`
+	OriginalStatus int        `json:"original_status"`
+	Headers        headerDiff `json:"headers"`
+	Body           bodyDiff   `json:"body"`
+}
+
+// headerDiff lists headers, multiple values joined with ", ", that differ
+type headerDiff struct {
+	Added   map[string]string       `json:"added,omitempty"`   // Only in the replayed response
+	Removed map[string]string       `json:"removed,omitempty"` // Only in the recorded response
+	Changed map[string]headerChange `json:"changed,omitempty"`
+}
+
+type headerChange struct {
+	Original string `json:"original"`
+	Replayed string `json:"replayed"`
+}
+
+// bodyDiff compares the bodies; text bodies come with a unified diff
+type bodyDiff struct {
+	Changed      *bool  `json:"changed"`              // null when the recorded body is incomplete
+	Incomplete   string `json:"incomplete,omitempty"` // Why the recorded body cannot be compared, see storedBodyGap
+	OriginalSize int    `json:"original_size"`        // Size of the recorded body as received
+	ReplayedSize int    `json:"replayed_size"`
+	Text         bool   `json:"text"`
+	UnifiedDiff  string `json:"unified_diff,omitempty"`
+}
+
+// storedBodyGap tells why the stored response body of a request is not the
+// body received: truncated when cut to -max-body-size, stream_truncated when
+// cut at -stream-capture-bytes, not_stored when dropped by -body-sample-rate
+// or the content type capture rules, masked when masks rewrote it. It
+// returns "" for a body stored whole.
+func storedBodyGap(req RequestLog) string {
+	switch {
+	case req.ResponseBodyTruncated:
+		return "truncated"
+	case req.Truncated:
+		return "stream_truncated"
+	case req.ResponseBody == nil && req.ResponseBodySize > 0:
+		return "not_stored"
+	case req.MasksApplied != "":
+		return "masked"
+	}
+	return ""
+}
+
+// diffReplay compares the replayed response with the one recorded for original.
+// The replayed body is decoded like recorded bodies before comparing. Bodies
+// not stored whole are not compared, leaving body.changed null.
+func diffReplay(original RequestLog, resp *http.Response, respBody []byte) *replayDiff {
+	diff := &replayDiff{
+		StatusChanged:  resp.StatusCode != original.StatusCode,
+		OriginalStatus: original.StatusCode,
+	}
+
+	var originalHeaders http.Header
+	if err := json.Unmarshal([]byte(original.ResponseHeaders), &originalHeaders); err != nil {
+		log.Printf("Error parsing response headers for request %d: %v", original.ID, err)
+	}
+	diff.Headers = diffHeaders(originalHeaders, resp.Header)
+
+	body := respBody
+	if encoding := resp.Header.Get("Content-Encoding"); isSupportedEncoding(encoding) {
+		if decompressed, err := decompressBody(respBody, encoding); err == nil {
+			body = decompressed
+		}
+	}
+	diff.Body = bodyDiff{
+		Incomplete:   storedBodyGap(original),
+		OriginalSize: len(original.ResponseBody),
+		ReplayedSize: len(body),
+		Text: isTextData(original.ResponseBody, getContentTypeFromHeaders(original.ResponseHeaders), textThreshold, textSampleBytes) &&
+			isTextData(body, resp.Header.Get("Content-Type"), textThreshold, textSampleBytes),
+	}
+	if original.ResponseBodySize > 0 {
+		diff.Body.OriginalSize = original.ResponseBodySize
+	}
+
+	// A status change is a mismatch whatever the bodies; with the same status
+	// the outcome stays unknown unless the recorded body is whole
+	matches := false
+	if diff.Body.Incomplete == "" {
+		changed := string(body) != string(original.ResponseBody)
+		diff.Body.Changed = &changed
+		if changed && diff.Body.Text {
+			diff.Body.UnifiedDiff = unifiedDiff(splitLines(string(original.ResponseBody)), splitLines(string(body)))
+		}
+		matches = !diff.StatusChanged && !changed
+		diff.Matches = &matches
+	} else if diff.StatusChanged {
+		diff.Matches = &matches
+	}
+	return diff
+}
+
+// diffHeaders compares two header sets by canonical name, skipping
+// replayDiffIgnoredHeaders
+func diffHeaders(original, replayed http.Header) headerDiff {
+	normalize := func(header http.Header) map[string]string {
+		values := make(map[string]string)
+		for name, v := range header {
+			name = http.CanonicalHeaderKey(name)
+			if !replayDiffIgnoredHeaders[name] {
+				values[name] = strings.Join(v, ", ")
+			}
+		}
+		return values
+	}
+	before, after := normalize(original), normalize(replayed)
+
+	var diff headerDiff
+	for name, value := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			if diff.Added == nil {
+				diff.Added = make(map[string]string)
+			}
+			diff.Added[name] = value
+		case old != value:
+			if diff.Changed == nil {
+				diff.Changed = make(map[string]headerChange)
+			}
+			diff.Changed[name] = headerChange{Original: old, Replayed: value}
+		}
+	}
+	for name, value := range before {
+		if _, ok := after[name]; !ok {
+			if diff.Removed == nil {
+				diff.Removed = make(map[string]string)
+			}
+			diff.Removed[name] = value
+		}
+	}
+	return diff
+}
+
+// splitLines splits text into lines, ignoring a final newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed or '+' added
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns an edit script turning a into b. Common leading and
+// trailing lines are matched first so only the changed middle is compared.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// diffMiddle finds the smallest edit script through the longest common
+// subsequence of lines, unless the inputs are too large for it
+func diffMiddle(a, b []string) []diffOp {
+	var ops []diffOp
+	n, m := len(a), len(b)
+	if n*m > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff renders the line diff of a and b in unified format, with
+// replayDiffContext lines of context around each hunk
+func unifiedDiff(a, b []string) string {
+	ops := diffLines(a, b)
+
+	// Line numbers in a and b at which each op starts
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	for k, op := range ops {
+		aPos[k+1], bPos[k+1] = aPos[k], bPos[k]
+		if op.kind != '+' {
+			aPos[k+1]++
+		}
+		if op.kind != '-' {
+			bPos[k+1]++
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("--- original\n+++ replayed\n")
+	for next := 0; next < len(ops); {
+		first := next
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		// Merge changes separated by little enough context into one hunk
+		last := first
+		for k := first + 1; k < len(ops) && k-last-1 <= 2*replayDiffContext; k++ {
+			if ops[k].kind != ' ' {
+				last = k
+			}
+		}
+
+		start := first - replayDiffContext
+		if start < next {
+			start = next
+		}
+		end := last + replayDiffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		aCount, bCount := aPos[end]-aPos[start], bPos[end]-bPos[start]
+		aStart, bStart := aPos[start]+1, bPos[start]+1
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		next = end
+	}
+	return sb.String()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// recordReplay stores a replayed exchange like captured traffic, marked with
+// origin replay, so replays are listed next to the requests they repeat.
+// resp is nil when the replayed request failed with replayErr. Replays are
+// recorded when asked for, whether or not recording is on.
+func recordReplay(replayReq *http.Request, resp *http.Response, respBody []byte, start time.Time, replayErr error) {
+	reqLog := RequestLog{
+		Timestamp:      start,
+		Method:         replayReq.Method,
+		URL:            replayReq.URL.String(),
+		RequestHeaders: HeadersToJSON(replayReq.Header),
+		UpstreamURL:    replayReq.URL.String(),
+		RequestProto:   replayReq.Proto,
+		Origin:         originReplay,
+	}
+	reqLog.SendMs, reqLog.TTFBMs = -1, -1
+	reqLog.DNSMs, reqLog.ConnectMs, reqLog.TLSMs = -1, -1, -1
+	reqLog.addDecision(decisionRecord, "replayed from the admin API with \"record\": true")
+
+	// The client consumed the request body, so it is read again
+	if replayReq.GetBody != nil {
+		if body, err := replayReq.GetBody(); err == nil {
+			reqLog.RequestBody, _ = ioutil.ReadAll(body)
+			body.Close()
+		}
+	}
+
+	if resp == nil {
+		// Recorded as the proxy records requests the upstream did not answer
+		reqLog.StatusCode = http.StatusBadGateway
+		if isTimeout(replayErr) {
+			reqLog.StatusCode = http.StatusGatewayTimeout
+		}
+		reqLog.ResponseBody = []byte(replayErr.Error())
+		reqLog.HandledBy = handledByError
+		reqLog.addDecision(decisionUpstream, "replayed request failed: %v", replayErr)
+	} else {
+		reqLog.StatusCode = resp.StatusCode
+		reqLog.ResponseHeaders = HeadersToJSON(resp.Header)
+		reqLog.ResponseProto = resp.Proto
+		reqLog.ResponseWireSize = len(respBody)
+		reqLog.ResponseBody = respBody
+		if encoding := resp.Header.Get("Content-Encoding"); isSupportedEncoding(encoding) && resp.StatusCode != http.StatusPartialContent {
+			if decompressed, err := decompressBody(respBody, encoding); err != nil {
+				reqLog.BodyError = fmt.Sprintf("decompressing response body: %v", err)
+			} else {
+				reqLog.ResponseBody = decompressed
+			}
+		}
+	}
+	reqLog.duration = time.Since(start)
+
+	queueRequestLog(reqLog)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// retryBaseDelay is the wait before the first retry; each further retry waits twice as long
+var retryBaseDelay = 100 * time.Millisecond
+
+// retryTransport resends idempotent requests that failed to connect or got a
+// 502, 503 or 504 from the upstream, up to retries times with exponential
+// backoff. The response of the last attempt is returned.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+}
+
+// newRetryTransport wraps next with retries, returning next itself when retries is 0
+func newRetryTransport(next http.RoundTripper, retries int) http.RoundTripper {
+	if retries <= 0 {
+		return next
+	}
+	return &retryTransport{next: next, retries: retries}
+}
+
+// isRetryable reports whether a request may be sent more than once: its
+// method is idempotent or the client marked it with an idempotency key
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.Get("X-Idempotency-Key") != "" || req.Header.Get("Idempotency-Key") != ""
+}
+
+// isRetryableStatus reports whether an upstream status is worth retrying
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isRetryable(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	// Buffer the body so every attempt can send it again
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reqLog, _ := req.Context().Value("reqLog").(*RequestLog)
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if body != nil {
+			attemptReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case isRetryableStatus(resp.StatusCode):
+			reason = resp.Status
+		}
+		if reason == "" || attempt == t.retries || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		// Discard the failed response so its connection can be reused
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if reqLog != nil {
+			reqLog.RetryCount = attempt + 1
+			reqLog.addDecision(decisionUpstream, "attempt %d failed (%s), retrying in %v (-retries %d)", attempt+1, reason, delay, t.retries)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
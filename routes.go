@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// proxyRoute forwards requests under a path prefix to its own backend
+type proxyRoute struct {
+	Prefix string `json:"prefix"`
+	Target string `json:"target"`
+	Record *bool  `json:"record,omitempty"` // Overrides the listener and global recording switches when set
+
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+}
+
+// routeTable holds routes ordered from the longest prefix to the shortest
+type routeTable []*proxyRoute
+
+// loadRoutes reads a JSON array of {"prefix": "/api/users", "target": "http://users:8081"}
+// entries, optionally with "record": true or false, creating a reverse proxy
+// for each target that captures responses like the main proxy
+func loadRoutes(path string) (routeTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes routeTable
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("invalid routes file %s: %v", path, err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("routes file %s has no routes", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return nil, fmt.Errorf("invalid route prefix %q, must start with /", route.Prefix)
+		}
+		if seen[route.Prefix] {
+			return nil, fmt.Errorf("duplicate route prefix %q", route.Prefix)
+		}
+		seen[route.Prefix] = true
+		target, err := url.Parse(route.Target)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("invalid target %q of route %s", route.Target, route.Prefix)
+		}
+		route.target = target
+		route.proxy = httputil.NewSingleHostReverseProxy(target)
+		route.proxy.ModifyResponse = captureResponse
+		route.proxy.Transport = upstreamTransport
+		route.proxy.ErrorHandler = proxyErrorHandler
+	}
+
+	// Longest prefix first so the first match is the most specific
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+	return routes, nil
+}
+
+// match returns the route with the longest prefix matching the path, or nil.
+// Prefixes match whole path segments: /api/users matches /api/users and
+// /api/users/42 but not /api/usersettings.
+func (routes routeTable) match(path string) *proxyRoute {
+	for _, route := range routes {
+		prefix := route.Prefix
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return route
+		}
+	}
+	return nil
+}
+
+// String lists the routes as prefix=target pairs, followed by their record
+// attribute when set
+func (routes routeTable) String() string {
+	items := make([]string, len(routes))
+	for i, route := range routes {
+		items[i] = route.Prefix + "=" + route.target.String()
+		if route.Record != nil {
+			items[i] += fmt.Sprintf(" record=%t", *route.Record)
+		}
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestRouteRecordOverridesListener checks that the record attribute of a
+// route overrides that of the listener, which overrides IsRecording
+func TestRouteRecordOverridesListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	routesJSON := `[
+		{"prefix": "/metrics", "target": "http://metrics:9100", "record": false},
+		{"prefix": "/audit", "target": "http://audit:8080", "record": true},
+		{"prefix": "/api", "target": "http://api:8080"}
+	]`
+	if err := ioutil.WriteFile(path, []byte(routesJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := loadRoutes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedRecording, savedListeners := IsRecording, proxyListeners
+	t.Cleanup(func() { IsRecording, proxyListeners = savedRecording, savedListeners })
+	recordOff := false
+	proxyListeners = []proxyListener{{Port: 8082, Record: &recordOff}}
+
+	for _, tt := range []struct {
+		path        string
+		port        int
+		isRecording bool
+		want        bool
+	}{
+		{"/metrics/cpu", 8080, true, false},
+		{"/audit/login", 8080, false, true},
+		{"/audit/login", 8082, true, true},
+		{"/api/users", 8080, true, true},
+		{"/api/users", 8080, false, false},
+		{"/api/users", 8082, true, false},
+	} {
+		IsRecording = tt.isRecording
+		reqLog := RequestLog{ListenerPort: tt.port}
+		if route := routes.match(tt.path); route != nil {
+			reqLog.routeRecord = route.Record
+		}
+		if got := reqLog.recordable(); got != tt.want {
+			t.Errorf("%s on port %d with IsRecording %t: recordable = %t, want %t", tt.path, tt.port, tt.isRecording, got, tt.want)
+		}
+	}
+
+	reqLog := RequestLog{routeRecord: routes.match("/audit").Record, pathExcluded: true}
+	if reqLog.recordable() {
+		t.Error("a path excluded by -record-exclude is recorded on a record=true route")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ruleConfig holds the raw values of the flags that can be changed at runtime
+// by editing the -config file and sending SIGHUP
+type ruleConfig struct {
+	recordIfHeaders     multiFlag
+	recordInclude       string
+	recordExclude       string
+	captureContentTypes string
+	skipContentTypes    string
+	mapStatuses         multiFlag
+	maskBodies          multiFlag
+	maskPreset          string
+	maskHeaders         string
+	redactJSONFields    string
+	headerSizeWarn      int
+	headerSizeLimit     int
+	maxConcurrent       int
+	maxConcurrentWait   time.Duration
+	routes              string
+	headerRules         string
+}
+
+// newRuleConfig returns the rule flag defaults
+func newRuleConfig() ruleConfig {
+	return ruleConfig{maxConcurrentWait: 5 * time.Second, maskHeaders: defaultMaskHeaders}
+}
+
+// register defines the rule flags on fs, storing their values in cfg. The
+// values already in cfg become the defaults, so registering keeps them.
+func (cfg *ruleConfig) register(fs *flag.FlagSet) {
+	fs.Var(&cfg.recordIfHeaders, "record-if-header", "only record responses carrying this header, as Name:Value or Name (repeatable, any match records)")
+	fs.StringVar(&cfg.recordInclude, "record-include", cfg.recordInclude, "comma-separated regular expressions; only requests whose URL path matches one are recorded (empty = all)")
+	fs.StringVar(&cfg.recordExclude, "record-exclude", cfg.recordExclude, "comma-separated regular expressions; requests whose URL path matches one are never recorded, e.g. ^/health$,^/metrics")
+	fs.StringVar(&cfg.captureContentTypes, "capture-content-types", cfg.captureContentTypes, "comma-separated content type globs whose bodies are stored, e.g. application/json,text/* (empty = all)")
+	fs.StringVar(&cfg.skipContentTypes, "skip-content-types", cfg.skipContentTypes, "comma-separated content type globs whose bodies are never stored, e.g. image/*,video/*")
+	fs.Var(&cfg.mapStatuses, "map-status", "rewrite an upstream status code before it reaches the client, e.g. 500=>503 (repeatable)")
+	fs.Var(&cfg.maskBodies, "mask-body", "replace regex matches in stored text bodies, e.g. 'token=\\w+=>token=***' (repeatable, $1 references groups)")
+	fs.StringVar(&cfg.maskPreset, "mask-preset", cfg.maskPreset, "comma-separated built-in body masks to apply, e.g. pii (emails, card numbers, SSNs)")
+	fs.StringVar(&cfg.maskHeaders, "mask-headers", cfg.maskHeaders, "comma-separated headers whose values are stored as *** (empty = none); requests are still forwarded with the real values")
+	fs.StringVar(&cfg.redactJSONFields, "redact-json-fields", cfg.redactJSONFields, "comma-separated JSON field names whose values are stored as *** in JSON bodies, at any depth, e.g. password,token")
+	fs.IntVar(&cfg.headerSizeWarn, "header-size-warn", cfg.headerSizeWarn, "flag recorded requests whose headers exceed this many bytes (0 = off)")
+	fs.IntVar(&cfg.headerSizeLimit, "header-size-limit", cfg.headerSizeLimit, "reject requests whose headers exceed this many bytes with 431 (0 = off)")
+	fs.IntVar(&cfg.maxConcurrent, "max-concurrent", cfg.maxConcurrent, "maximum number of concurrently proxied requests (0 = unlimited)")
+	fs.DurationVar(&cfg.maxConcurrentWait, "max-concurrent-wait", cfg.maxConcurrentWait, "how long a request waits for a free slot before getting a 503")
+	fs.StringVar(&cfg.routes, "routes", cfg.routes, "JSON file of path prefix routes, e.g. [{\"prefix\": \"/api/users\", \"target\": \"http://users:8081\"}]; the longest matching prefix wins and unmatched requests get a 502")
+	fs.StringVar(&cfg.headerRules, "header-rules", cfg.headerRules, "JSON file of headers to set or remove on proxied requests and responses, e.g. {\"request\": {\"set\": {\"Authorization\": \"Bearer t\"}}, \"response\": {\"remove\": [\"Set-Cookie\"]}}")
+}
+
+// clone returns a copy of the config whose repeatable flags can be appended to independently
+func (cfg ruleConfig) clone() ruleConfig {
+	cfg.recordIfHeaders = append(multiFlag(nil), cfg.recordIfHeaders...)
+	cfg.mapStatuses = append(multiFlag(nil), cfg.mapStatuses...)
+	cfg.maskBodies = append(multiFlag(nil), cfg.maskBodies...)
+	return cfg
+}
+
+// loadRuleConfig applies the rule flags in a config file on top of base. Each
+// non-empty line not starting with # holds one flag as "name value" or
+// "name=value"; repeatable flags add to the command line values, others replace them.
+func loadRuleConfig(base ruleConfig, path string) (ruleConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ruleConfig{}, err
+	}
+	defer file.Close()
+
+	cfg := base.clone()
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg.register(fs)
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimLeft(line, "-")
+		sep := strings.IndexAny(line, " \t=")
+		if sep < 0 {
+			return ruleConfig{}, fmt.Errorf("%s:%d: expected name value, got %q", path, lineNum, line)
+		}
+		name, value := line[:sep], strings.TrimSpace(line[sep+1:])
+		if err := fs.Set(name, value); err != nil {
+			return ruleConfig{}, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ruleConfig{}, err
+	}
+	return cfg, nil
+}
+
+// ruleSet is the parsed form of a ruleConfig. A ruleSet is never modified
+// once installed; a reload builds a new one and swaps it in.
+type ruleSet struct {
+	config              ruleConfig
+	recordIfHeaders     []headerRecordRule // Restrict recording to responses matching any rule
+	recordInclude       []*regexp.Regexp   // Restrict recording to URL paths matching any pattern
+	recordExclude       []*regexp.Regexp   // Never record URL paths matching any pattern
+	captureContentTypes []string           // Glob patterns (e.g. image/*) of media types whose bodies are stored
+	skipContentTypes    []string           // Glob patterns of media types whose bodies are never stored
+	statusMappings      map[int]int        // Upstream status -> status sent to the client
+	bodyMasks           []bodyMask         // Masks applied to stored text bodies
+	maskHeaders         map[string]bool    // Canonical names of headers whose stored values are masked
+	redactJSONFields    []string           // JSON field names whose stored values are redacted
+	headerSizeWarn      int                // Flag requests whose headers exceed this many bytes (0 = off)
+	headerSizeLimit     int                // Reject requests whose headers exceed this many bytes with 431 (0 = off)
+	concurrencyLimiter  chan struct{}      // Semaphore bounding concurrently proxied requests; nil means unlimited
+	concurrencyWait     time.Duration      // How long a request may wait for a free slot
+	routes              routeTable         // Path prefix routes of the main proxy port; nil when not routing by path
+	headerRules         *headerRules       // Header edits applied to every proxied exchange; nil when unset
+}
+
+var (
+	rulesMu     sync.RWMutex
+	activeRules = &ruleSet{statusMappings: map[int]int{}, concurrencyWait: 5 * time.Second}
+)
+
+// currentRules returns the rule set in effect. Callers handling one request
+// should fetch it once so the request sees a consistent set across a reload.
+func currentRules() *ruleSet {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return activeRules
+}
+
+// buildRuleSet parses a rule config. The concurrency semaphore of previous is
+// kept when the limit is unchanged so requests holding slots stay counted.
+func buildRuleSet(cfg ruleConfig, previous *ruleSet) (*ruleSet, error) {
+	set := &ruleSet{
+		config:          cfg,
+		statusMappings:  map[int]int{},
+		headerSizeWarn:  cfg.headerSizeWarn,
+		headerSizeLimit: cfg.headerSizeLimit,
+		concurrencyWait: cfg.maxConcurrentWait,
+	}
+
+	for _, spec := range cfg.recordIfHeaders {
+		rule, err := parseHeaderRecordRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("-record-if-header: %v", err)
+		}
+		set.recordIfHeaders = append(set.recordIfHeaders, rule)
+	}
+
+	var err error
+	if set.recordInclude, err = parsePathPatterns(cfg.recordInclude); err != nil {
+		return nil, fmt.Errorf("-record-include: %v", err)
+	}
+	if set.recordExclude, err = parsePathPatterns(cfg.recordExclude); err != nil {
+		return nil, fmt.Errorf("-record-exclude: %v", err)
+	}
+	if set.captureContentTypes, err = parseContentTypePatterns(cfg.captureContentTypes); err != nil {
+		return nil, fmt.Errorf("-capture-content-types: %v", err)
+	}
+	if set.skipContentTypes, err = parseContentTypePatterns(cfg.skipContentTypes); err != nil {
+		return nil, fmt.Errorf("-skip-content-types: %v", err)
+	}
+
+	for _, spec := range cfg.mapStatuses {
+		from, to, err := parseStatusMapping(spec)
+		if err != nil {
+			return nil, fmt.Errorf("-map-status: %v", err)
+		}
+		set.statusMappings[from] = to
+	}
+
+	if set.bodyMasks, err = parseBodyMaskPreset(cfg.maskPreset); err != nil {
+		return nil, fmt.Errorf("-mask-preset: %v", err)
+	}
+	for _, spec := range cfg.maskBodies {
+		mask, err := parseBodyMask(spec)
+		if err != nil {
+			return nil, fmt.Errorf("-mask-body: %v", err)
+		}
+		set.bodyMasks = append(set.bodyMasks, mask)
+	}
+
+	if set.maskHeaders, err = parseMaskHeaders(cfg.maskHeaders); err != nil {
+		return nil, fmt.Errorf("-mask-headers: %v", err)
+	}
+	set.redactJSONFields = parseRedactJSONFields(cfg.redactJSONFields)
+
+	// The routes and header rules files are read again on every reload, so
+	// edits to them apply even when their paths are unchanged
+	if cfg.routes != "" {
+		if upstreamTargets != nil {
+			return nil, fmt.Errorf("-routes and -targets cannot be combined")
+		}
+		if set.routes, err = loadRoutes(cfg.routes); err != nil {
+			return nil, fmt.Errorf("-routes: %v", err)
+		}
+	}
+	if cfg.headerRules != "" {
+		if set.headerRules, err = loadHeaderRules(cfg.headerRules); err != nil {
+			return nil, fmt.Errorf("-header-rules: %v", err)
+		}
+	}
+
+	if cfg.maxConcurrent < 0 {
+		return nil, fmt.Errorf("-max-concurrent %d is negative", cfg.maxConcurrent)
+	}
+	if cfg.maxConcurrent > 0 {
+		if previous != nil && cap(previous.concurrencyLimiter) == cfg.maxConcurrent {
+			set.concurrencyLimiter = previous.concurrencyLimiter
+		} else {
+			set.concurrencyLimiter = make(chan struct{}, cfg.maxConcurrent)
+		}
+	}
+	return set, nil
+}
+
+// installRules makes set the rule set in effect
+func installRules(set *ruleSet) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	activeRules = set
+}
+
+// ruleConfigChanges lists the flags whose values differ between two configs
+func ruleConfigChanges(before, after ruleConfig) []string {
+	beforeFlags := flag.NewFlagSet("before", flag.ContinueOnError)
+	before.register(beforeFlags)
+	afterFlags := flag.NewFlagSet("after", flag.ContinueOnError)
+	after.register(afterFlags)
+
+	var changes []string
+	afterFlags.VisitAll(func(f *flag.Flag) {
+		old := beforeFlags.Lookup(f.Name).Value.String()
+		if value := f.Value.String(); value != old {
+			changes = append(changes, fmt.Sprintf("-%s: %q -> %q", f.Name, old, value))
+		}
+	})
+	return changes
+}
+
+// reloadRules re-reads the config file and swaps in the new rule set. An
+// invalid file is rejected and the current rules stay in effect.
+func reloadRules(base ruleConfig, path string) {
+	cfg, err := loadRuleConfig(base, path)
+	if err != nil {
+		log.Printf("Rejected reload of %s, keeping current rules: %v", path, err)
+		return
+	}
+
+	// Build under the write lock so concurrent reloads cannot interleave
+	rulesMu.Lock()
+	set, err := buildRuleSet(cfg, activeRules)
+	if err != nil {
+		rulesMu.Unlock()
+		log.Printf("Rejected reload of %s, keeping current rules: %v", path, err)
+		return
+	}
+	previous := activeRules
+	activeRules = set
+	rulesMu.Unlock()
+
+	changes := ruleConfigChanges(previous.config, set.config)
+	if before, after := previous.routes.String(), set.routes.String(); before != after {
+		changes = append(changes, fmt.Sprintf("routes: %q -> %q", before, after))
+	}
+	if !reflect.DeepEqual(previous.headerRules, set.headerRules) {
+		changes = append(changes, fmt.Sprintf("header rules: %q -> %q", previous.headerRules, set.headerRules))
+	}
+	if len(changes) == 0 {
+		log.Printf("Reloaded %s: no rule changes", path)
+		return
+	}
+	log.Printf("Reloaded %s: %s", path, strings.Join(changes, "; "))
+}
+
+// watchRuleReloads reloads the config file whenever the process receives SIGHUP
+func watchRuleReloads(base ruleConfig, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadRules(base, path)
+		}
+	}()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scriptSkippedHeaders are request headers that are not reproduced in
+// exported scripts because the HTTP client sets them itself or because the
+// stored body has already been decompressed
+var scriptSkippedHeaders = map[string]bool{
+	"Host":             true,
+	"Content-Length":   true,
+	"Connection":       true,
+	"Content-Encoding": true,
+}
+
+// shellQuote quotes a string for safe use as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// scriptHeaders returns the stored request headers to replay, sorted by name
+func scriptHeaders(req RequestLog) ([]string, map[string][]string) {
+	var headers map[string][]string
+	if err := json.Unmarshal([]byte(req.RequestHeaders), &headers); err != nil {
+		log.Printf("Error parsing request headers for request %d: %v", req.ID, err)
+	}
+
+	var names []string
+	for name := range headers {
+		if !scriptSkippedHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, headers
+}
+
+// generateShellScript builds a bash script that replays the requests with curl
+func generateShellScript(requests []RequestLog) string {
+	var sb strings.Builder
+	sb.WriteString("#!/usr/bin/env bash\n")
+	sb.WriteString("# Generated by dGateway\n")
+	sb.WriteString("set -euo pipefail\n")
+
+	for _, req := range requests {
+		sb.WriteString("\n")
+		// Quoted, so a line break in a stored URL cannot end the comment
+		fmt.Fprintf(&sb, "# Request %d: %q %q (original status %d, %s)\n", req.ID, req.Method, req.URL, req.StatusCode, req.Timestamp.Format("2006-01-02 15:04:05"))
+		sb.WriteString(curlCommand(req))
+		sb.WriteString("\necho\n")
+	}
+
+	return sb.String()
+}
+
+// curlCommand returns a shell command that replays the request against its
+// target with curl. Binary bodies are embedded as base64 and piped in.
+func curlCommand(req RequestLog) string {
+	targetURL, err := resolveTargetURL(req.URL, req.ListenerPort)
+	if err != nil {
+		targetURL = req.URL
+	}
+
+	var sb strings.Builder
+	isText := isTextData(req.RequestBody, getContentTypeFromHeaders(req.RequestHeaders), textThreshold, textSampleBytes)
+	if len(req.RequestBody) > 0 && !isText {
+		// Binary bodies are embedded as base64 and decoded on the fly
+		fmt.Fprintf(&sb, "printf '%%s' %s | base64 -d | \\\n  ", shellQuote(base64.StdEncoding.EncodeToString(req.RequestBody)))
+	}
+
+	fmt.Fprintf(&sb, "curl -sS -X %s %s", shellQuote(req.Method), shellQuote(targetURL))
+	names, headers := scriptHeaders(req)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(&sb, " \\\n  -H %s", shellQuote(name+": "+value))
+		}
+	}
+
+	if len(req.RequestBody) > 0 {
+		if isText {
+			fmt.Fprintf(&sb, " \\\n  --data-binary %s", shellQuote(string(req.RequestBody)))
+		} else {
+			sb.WriteString(" \\\n  --data-binary @-")
+		}
+	}
+	return sb.String()
+}
+
+// generateGoScript builds a standalone Go program that replays the requests with net/http
+func generateGoScript(requests []RequestLog) string {
+	var sb strings.Builder
+	sb.WriteString("// Generated by dGateway. Run with: go run main.go\n")
+	sb.WriteString("package main\n\n")
+	sb.WriteString("import (\n\t\"fmt\"\n\t\"io\"\n\t\"log\"\n\t\"net/http\"\n\t\"strings\"\n)\n\n")
+	sb.WriteString("func do(method, url, body string, headers [][2]string) {\n")
+	sb.WriteString("\treq, err := http.NewRequest(method, url, strings.NewReader(body))\n")
+	sb.WriteString("\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n")
+	sb.WriteString("\tfor _, h := range headers {\n\t\treq.Header.Add(h[0], h[1])\n\t}\n")
+	sb.WriteString("\tresp, err := http.DefaultClient.Do(req)\n")
+	sb.WriteString("\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n")
+	sb.WriteString("\tdefer resp.Body.Close()\n")
+	sb.WriteString("\trespBody, _ := io.ReadAll(resp.Body)\n")
+	sb.WriteString("\tfmt.Printf(\"%s %s -> %s\\n%s\\n\", method, url, resp.Status, respBody)\n")
+	sb.WriteString("}\n\n")
+	sb.WriteString("func main() {\n")
+
+	for i, req := range requests {
+		targetURL, err := resolveTargetURL(req.URL, req.ListenerPort)
+		if err != nil {
+			targetURL = req.URL
+		}
+
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "\t// Request %d (original status %d, %s)\n", req.ID, req.StatusCode, req.Timestamp.Format("2006-01-02 15:04:05"))
+		fmt.Fprintf(&sb, "\tdo(%s, %s, %s, [][2]string{\n", strconv.Quote(req.Method), strconv.Quote(targetURL), strconv.Quote(string(req.RequestBody)))
+		names, headers := scriptHeaders(req)
+		for _, name := range names {
+			for _, value := range headers[name] {
+				fmt.Fprintf(&sb, "\t\t{%s, %s},\n", strconv.Quote(name), strconv.Quote(value))
+			}
+		}
+		sb.WriteString("\t})\n")
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// writeScript renders the requests in the language selected by the lang
+// query parameter (sh or go) and sends it as a download
+func writeScript(w http.ResponseWriter, r *http.Request, requests []RequestLog, baseName string) {
+	var script, filename string
+	switch lang := r.URL.Query().Get("lang"); lang {
+	case "", "sh":
+		script = generateShellScript(requests)
+		filename = baseName + ".sh"
+	case "go":
+		script = generateGoScript(requests)
+		filename = baseName + ".go"
+	default:
+		http.Error(w, "Unsupported script language, expected sh or go", http.StatusBadRequest)
+		return
+	}
+
+	sendScript(w, script, filename)
+}
+
+// sendScript sends a generated script as a download
+func sendScript(w http.ResponseWriter, script, filename string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write([]byte(script))
+}
+
+// getRequestScriptHandler handles GET /api/requests/{id}/script?lang=sh|go
+func getRequestScriptHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, err := getRequestLogs(" AND id = ?", id)
+	if err != nil {
+		http.Error(w, "Failed to fetch request", http.StatusInternalServerError)
+		log.Printf("Error fetching request %d: %v", id, err)
+		return
+	}
+	if len(requests) == 0 {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	writeScript(w, r, requests, fmt.Sprintf("request-%d", id))
+}
+
+// exportScriptHandler handles GET /api/export/script?lang=sh|go, emitting every
+// request matching the list filters in timestamp order
+func exportScriptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, ok := filteredRequests(w, r)
+	if !ok {
+		return
+	}
+
+	writeScript(w, r, requests, "dgateway-replay")
+}
+
+// exportCurlHandler handles GET /api/export/curl?id={id}, emitting the curl
+// command that replays one request
+func exportCurlHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := getRequestLogs(" AND id = ?", id)
+	if err != nil {
+		http.Error(w, "Failed to fetch request", http.StatusInternalServerError)
+		log.Printf("Error fetching request %d: %v", id, err)
+		return
+	}
+	if len(requests) == 0 {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	sendScript(w, curlCommand(requests[0])+"\n", fmt.Sprintf("request-%d.curl.sh", id))
+}
+
+// filteredRequests loads the requests matching the list filters,
+// writing an error response and returning false on failure
+func filteredRequests(w http.ResponseWriter, r *http.Request) ([]RequestLog, bool) {
+	where, args := buildRequestFilter(r.URL.Query())
+	requests, err := getRequestLogs(where, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
+		log.Printf("Error fetching requests: %v", err)
+		return nil, false
+	}
+	return requests, true
+}
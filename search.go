@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	searchMaxDepth      = 8   // Maximum nesting of and/or/not groups
+	searchMaxConditions = 100 // Maximum number of field conditions in one query
+)
+
+// searchColumns maps the fields accepted by the search DSL to their columns
+var searchColumns = map[string]string{
+	"id":                 "id",
+	"timestamp":          "timestamp",
+	"method":             "method",
+	"url":                "url",
+	"status_code":        "status_code",
+	"client_status_code": "COALESCE(client_status_code, status_code)",
+	"listener_port":      "listener_port",
+	"request_body_size":  "request_body_size",
+	"response_body_size": "response_body_size",
+	"conn_id":            "conn_id",
+	"anomaly":            "COALESCE(anomaly, 0)",
+	"handled_by":         "COALESCE(handled_by, 'upstream')",
+	"origin":             originColumn,
+	"tls_sni":            "tls_sni",
+	"request_range":      "request_range",
+	"content_range":      "content_range",
+	"upstream":           "upstream",
+	"route":              "route",
+	"duration_ms":        "duration_ms",
+	"ttfb_ms":            "ttfb_ms",
+	"dns_ms":             "dns_ms",
+	"connect_ms":         "connect_ms",
+	"tls_ms":             "tls_ms",
+	"protocol":           "protocol",
+	"client_ip":          "client_ip",
+}
+
+// searchComparisons maps the comparison operators of the search DSL to SQL
+var searchComparisons = map[string]string{
+	"eq":  "=",
+	"ne":  "!=",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+// searchNode is one node of a search query: either an and/or/not group or a
+// single field condition such as {"field": "status_code", "op": "gte", "value": 500}
+type searchNode struct {
+	And []searchNode `json:"and,omitempty"`
+	Or  []searchNode `json:"or,omitempty"`
+	Not *searchNode  `json:"not,omitempty"`
+
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// searchCompiler turns a search query into a parameterized WHERE clause.
+// Field names and operators are only ever taken from the whitelists above;
+// values are always bound as arguments.
+type searchCompiler struct {
+	args       []interface{}
+	conditions int
+}
+
+func (c *searchCompiler) compile(node searchNode, depth int) (string, error) {
+	if depth > searchMaxDepth {
+		return "", fmt.Errorf("query nested deeper than %d levels", searchMaxDepth)
+	}
+
+	switch {
+	case len(node.And) > 0:
+		return c.compileGroup(node.And, " AND ", depth)
+	case len(node.Or) > 0:
+		return c.compileGroup(node.Or, " OR ", depth)
+	case node.Not != nil:
+		inner, err := c.compile(*node.Not, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case node.Field != "":
+		return c.compileCondition(node)
+	default:
+		return "", fmt.Errorf("empty query node")
+	}
+}
+
+func (c *searchCompiler) compileGroup(nodes []searchNode, joiner string, depth int) (string, error) {
+	parts := make([]string, 0, len(nodes))
+	for _, child := range nodes {
+		part, err := c.compile(child, depth+1)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	return "(" + strings.Join(parts, joiner) + ")", nil
+}
+
+func (c *searchCompiler) compileCondition(node searchNode) (string, error) {
+	c.conditions++
+	if c.conditions > searchMaxConditions {
+		return "", fmt.Errorf("query has more than %d conditions", searchMaxConditions)
+	}
+
+	column, ok := searchColumns[node.Field]
+	isJSONField := false
+	if !ok {
+		name := strings.TrimPrefix(node.Field, "json_")
+		field, found := jsonFieldByName(name)
+		if name == node.Field || !found {
+			return "", fmt.Errorf("unknown field %q", node.Field)
+		}
+		column, isJSONField = field.column(), true
+	}
+
+	if sqlOp, ok := searchComparisons[node.Op]; ok {
+		if node.Value == nil {
+			return "", fmt.Errorf("operator %q on %s needs a value", node.Op, node.Field)
+		}
+		if _, isNumber := node.Value.(float64); isNumber && isJSONField {
+			// Compared as numbers even where the column stores text
+			column = store.jsonFieldNumber(column)
+		}
+		c.args = append(c.args, node.Value)
+		return fmt.Sprintf("%s %s ?", column, sqlOp), nil
+	}
+
+	switch node.Op {
+	case "contains", "starts_with":
+		value, ok := node.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("operator %q on %s needs a string value", node.Op, node.Field)
+		}
+		pattern := escapeLike(value) + "%"
+		if node.Op == "contains" {
+			pattern = "%" + pattern
+		}
+		c.args = append(c.args, pattern)
+		return fmt.Sprintf("%s %s ? ESCAPE '\\'", column, store.likeOperator()), nil
+	case "in":
+		values, ok := node.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return "", fmt.Errorf("operator \"in\" on %s needs a non-empty list value", node.Field)
+		}
+		c.args = append(c.args, values...)
+		return fmt.Sprintf("%s IN (?%s)", column, strings.Repeat(", ?", len(values)-1)), nil
+	case "is_null":
+		return fmt.Sprintf("%s IS NULL", column), nil
+	default:
+		return "", fmt.Errorf("unknown operator %q", node.Op)
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in a value matched with ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// searchRequestsHandler handles POST /api/requests/search. The body holds a
+// query tree plus optional pagination and sort, e.g.
+//
+//	{"query": {"and": [{"field": "method", "op": "eq", "value": "POST"},
+//	  {"or": [{"field": "status_code", "op": "gte", "value": 500},
+//	          {"field": "url", "op": "contains", "value": "/pay"}]}]},
+//	 "page": 1, "page_size": 50}
+func searchRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var search struct {
+		Query    *searchNode `json:"query"`
+		Page     int         `json:"page"`
+		PageSize int         `json:"page_size"`
+		Sort     string      `json:"sort"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		http.Error(w, "Invalid search body", http.StatusBadRequest)
+		return
+	}
+
+	page := 1
+	pageSize := 50
+	if search.Page > 0 {
+		page = search.Page
+	}
+	if search.PageSize > 0 && search.PageSize <= 100 {
+		pageSize = search.PageSize
+	}
+	offset := (page - 1) * pageSize
+
+	where := ""
+	compiler := &searchCompiler{}
+	if search.Query != nil {
+		condition, err := compiler.compile(*search.Query, 0)
+		if err != nil {
+			http.Error(w, "Invalid search query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		where = " AND " + condition
+	}
+
+	var totalCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM requests WHERE 1=1"+where, compiler.args...).Scan(&totalCount); err != nil {
+		http.Error(w, "Failed to fetch request count", http.StatusInternalServerError)
+		log.Printf("Error fetching search count: %v", err)
+		return
+	}
+
+	order := requestListOrder(url.Values{"sort": {search.Sort}})
+	args := append(compiler.args, pageSize, offset)
+	rows, err := db.Query("SELECT "+requestSummaryColumns()+" FROM requests WHERE 1=1"+where+" ORDER BY "+order+" LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
+		log.Printf("Error searching requests: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	response := struct {
+		Requests   []RequestLog `json:"requests"`
+		Page       int          `json:"page"`
+		PageSize   int          `json:"page_size"`
+		TotalCount int          `json:"total_count"`
+		TotalPages int          `json:"total_pages"`
+	}{
+		Requests:   scanRequestSummaries(rows),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalCount: totalCount,
+		TotalPages: (totalCount + pageSize - 1) / pageSize,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
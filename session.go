@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long a login stays valid
+var sessionTTL = 24 * time.Hour
+
+// sessionStore maps the tokens handed out on login to their expiry. Sessions
+// live in memory only, so all of them end when the gateway restarts.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]time.Time
+}
+
+var sessions = &sessionStore{sessions: make(map[string]time.Time)}
+
+// create starts a session lasting ttl and returns its token
+func (s *sessionStore) create(ttl time.Duration) string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate session token: %v", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Drop expired sessions so abandoned logins do not accumulate
+	for existing, expiry := range s.sessions {
+		if now.After(expiry) {
+			delete(s.sessions, existing)
+		}
+	}
+	s.sessions[token] = now.Add(ttl)
+	return token
+}
+
+// valid reports whether the token belongs to an unexpired session
+func (s *sessionStore) valid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.sessions[token]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) {
+		delete(s.sessions, token)
+		return false
+	}
+	return true
+}
+
+// remove ends a single session
+func (s *sessionStore) remove(token string) {
+	s.mu.Lock()
+	delete(s.sessions, token)
+	s.mu.Unlock()
+}
+
+// clear ends every session
+func (s *sessionStore) clear() {
+	s.mu.Lock()
+	s.sessions = make(map[string]time.Time)
+	s.mu.Unlock()
+}
+
+// isAuthenticated reports whether the request comes from a user authenticated
+// by a trusted SSO proxy or carries a valid session cookie
+func isAuthenticated(r *http.Request) bool {
+	if _, ok := trustedHeaderUser(r); ok {
+		return true
+	}
+
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return false
+	}
+	return sessions.valid(cookie.Value)
+}
+
+// revokeAllSessionsHandler handles POST /api/sessions/revoke-all
+func revokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions.clear()
+	log.Println("All admin sessions revoked.")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"message": "All sessions revoked"}`))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// statsTopURLs is the number of most requested URLs listed in the stats
+const statsTopURLs = 10
+
+// urlCount is a URL with the number of requests made to it
+type urlCount struct {
+	URL   string `json:"url"`
+	Count int    `json:"count"`
+}
+
+// trafficStats aggregates the stored requests matching the list filters
+type trafficStats struct {
+	TotalRequests int            `json:"total_requests"`
+	StatusClasses map[string]int `json:"status_classes"` // e.g. "2xx": 120
+	TopURLs       []urlCount     `json:"top_urls"`
+	AvgDurationMs float64        `json:"avg_duration_ms"` // -1 when no request has a measured duration
+	P95DurationMs int64          `json:"p95_duration_ms"`
+	StoredBytes   int64          `json:"stored_bytes"` // Headers and bodies
+}
+
+// requestStats computes the traffic statistics with aggregate queries, so
+// the rows themselves are never loaded. where and args come from
+// buildRequestFilter.
+func requestStats(where string, args []interface{}) (*trafficStats, error) {
+	stats := &trafficStats{
+		StatusClasses: make(map[string]int),
+		TopURLs:       []urlCount{},
+		AvgDurationMs: -1,
+		P95DurationMs: -1,
+	}
+
+	var storedBytes sql.NullInt64
+	err := db.QueryRow("SELECT COUNT(*), SUM(COALESCE(LENGTH(request_headers), 0) + COALESCE(LENGTH(request_body), 0) + COALESCE(LENGTH(response_headers), 0) + COALESCE(LENGTH(response_body), 0)) FROM requests WHERE 1=1"+where, args...).Scan(&stats.TotalRequests, &storedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("counting requests: %v", err)
+	}
+	stats.StoredBytes = storedBytes.Int64
+
+	rows, err := db.Query("SELECT status_code / 100, COUNT(*) FROM requests WHERE status_code IS NOT NULL"+where+" GROUP BY status_code / 100", args...)
+	if err != nil {
+		return nil, fmt.Errorf("grouping by status: %v", err)
+	}
+	for rows.Next() {
+		var class, count int
+		if err := rows.Scan(&class, &count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning status class: %v", err)
+		}
+		stats.StatusClasses[fmt.Sprintf("%dxx", class)] = count
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT url, COUNT(*) FROM requests WHERE 1=1"+where+" GROUP BY url ORDER BY COUNT(*) DESC, url LIMIT ?", append(args, statsTopURLs)...)
+	if err != nil {
+		return nil, fmt.Errorf("grouping by URL: %v", err)
+	}
+	for rows.Next() {
+		var top urlCount
+		if err := rows.Scan(&top.URL, &top.Count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning URL count: %v", err)
+		}
+		stats.TopURLs = append(stats.TopURLs, top)
+	}
+	rows.Close()
+
+	// Durations of -1 or NULL were not measured
+	durationWhere := " AND duration_ms >= 0" + where
+	var measured int
+	var avg sql.NullFloat64
+	if err := db.QueryRow("SELECT COUNT(*), AVG(duration_ms) FROM requests WHERE 1=1"+durationWhere, args...).Scan(&measured, &avg); err != nil {
+		return nil, fmt.Errorf("averaging durations: %v", err)
+	}
+	if measured > 0 {
+		stats.AvgDurationMs = avg.Float64
+		// Nearest-rank percentile: the smallest duration at or above 95% of the others
+		rank := (measured*95 + 99) / 100
+		if err := db.QueryRow("SELECT duration_ms FROM requests WHERE 1=1"+durationWhere+" ORDER BY duration_ms LIMIT 1 OFFSET ?", append(args, rank-1)...).Scan(&stats.P95DurationMs); err != nil {
+			return nil, fmt.Errorf("finding 95th percentile duration: %v", err)
+		}
+	}
+
+	return stats, nil
+}
+
+// statsHandler handles GET /api/stats, reporting the current load and
+// statistics of the stored requests matching the list filters (e.g.
+// start_date and end_date)
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := requestStats(buildRequestFilter(r.URL.Query()))
+	if err != nil {
+		http.Error(w, "Failed to compute stats", http.StatusInternalServerError)
+		log.Printf("Error computing stats: %v", err)
+		return
+	}
+
+	response := struct {
+		InFlight          int64 `json:"in_flight"`
+		MaxConcurrent     int   `json:"max_concurrent"`
+		LogQueued         int   `json:"log_queued"` // Recorded requests waiting for the database writers
+		LogBuffer         int   `json:"log_buffer"`
+		LogEntriesDropped int64 `json:"log_entries_dropped"` // Since startup, because the queue was full
+		*trafficStats
+	}{
+		InFlight:          inFlightRequests.Load(),
+		MaxConcurrent:     cap(currentRules().concurrencyLimiter),
+		LogQueued:         len(requestLogChan),
+		LogBuffer:         cap(requestLogChan),
+		LogEntriesDropped: droppedLogEntries.Load(),
+		trafficStats:      stats,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseStatusMapping parses a "500=>503" rule into its upstream and client status codes
+func parseStatusMapping(spec string) (int, int, error) {
+	from, to, ok := strings.Cut(spec, "=>")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid status mapping %q, expected FROM=>TO", spec)
+	}
+	fromCode, err := parseStatusCode(from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status mapping %q: %v", spec, err)
+	}
+	toCode, err := parseStatusCode(to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status mapping %q: %v", spec, err)
+	}
+	return fromCode, toCode, nil
+}
+
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || code < 100 || code > 999 {
+		return 0, fmt.Errorf("bad status code %q", s)
+	}
+	return code, nil
+}
+
+// rewriteStatus applies the -map-status rules to an upstream response and
+// returns the status code sent to the client
+func rewriteStatus(resp *http.Response) int {
+	code, ok := currentRules().statusMappings[resp.StatusCode]
+	if !ok {
+		return resp.StatusCode
+	}
+	resp.StatusCode = code
+	resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	return code
+}
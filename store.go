@@ -0,0 +1,332 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Store is the storage backend of the captured requests, selected by
+// -db-driver. The list filters, exports and stats build their queries with
+// buildRequestFilter and run them on db, using the dialect methods for the
+// SQL that differs between backends.
+type Store interface {
+	// LogRequest stores a log entry, returning it as stored: with its ID,
+	// the derived fields filled in and the bodies masked, sampled or cut
+	LogRequest(logEntry RequestLog) (RequestLog, error)
+	// LogRequests stores several log entries at once, returning those stored
+	LogRequests(entries []RequestLog) []RequestLog
+	// GetRequests returns a page of the request summaries matching the list
+	// filters and sort order in params, with the number of matching requests
+	GetRequests(params url.Values, page, pageSize int) ([]RequestLog, int, error)
+	// GetRequestDetail returns the metadata of a request without its bodies,
+	// or sql.ErrNoRows when no request has the ID
+	GetRequestDetail(id int) (RequestLog, error)
+	// DeleteRequest removes a request, returning sql.ErrNoRows when no
+	// request has the ID
+	DeleteRequest(id int) error
+
+	// likeOperator returns the LIKE operator ignoring case, as SQLite's LIKE does
+	likeOperator() string
+	// urlPrefixCondition matches urls starting with the escaped pattern argument
+	urlPrefixCondition() string
+	// errorCondition matches rows with malformed stored headers or recorded body errors
+	errorCondition() string
+	// jsonFieldNumber is the numeric value of an -index-json-field column, for
+	// comparisons with numbers
+	jsonFieldNumber(column string) string
+	// jsonFieldOrder sorts by an -index-json-field column, numbers numerically
+	// and rows without a value last
+	jsonFieldOrder(column, direction string) string
+}
+
+// store is the backend opened by InitDB. The SQL dialect methods of the
+// default SQLite store are usable before then.
+var store Store = &sqliteStore{}
+
+// schemaDialect is the backend specific part of the schema migrations
+type schemaDialect interface {
+	// columnExists reports whether a table has a column
+	columnExists(tx *sql.Tx, tableName, columnName string) bool
+	// columnType maps the SQLite column types used by the migrations
+	columnType(columnType string) string
+}
+
+// sqlStore implements Store on a database/sql database. The SQLite and
+// PostgreSQL stores embed it and add their SQL dialect.
+type sqlStore struct {
+	db         *sql.DB
+	insertStmt *sql.Stmt // The INSERT storing log entries, shared by every writer
+	returnsID  bool      // insertStmt returns the id, for drivers without LastInsertId
+}
+
+// openDB opens a database with the -db-max-open-conns pool limits, or a
+// single connection kept open forever for an in-memory database
+func openDB(driverName, dataSourceName string, inMemory bool) *sql.DB {
+	conn, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	if inMemory {
+		// An in-memory database is dropped when its last connection closes, so
+		// keep exactly one connection open for the lifetime of the process
+		conn.SetMaxOpenConns(1)
+		conn.SetMaxIdleConns(1)
+		conn.SetConnMaxLifetime(0)
+		conn.SetConnMaxIdleTime(0)
+	} else {
+		conn.SetMaxOpenConns(dbMaxOpenConns)
+		conn.SetMaxIdleConns(dbMaxIdleConns)
+		conn.SetConnMaxLifetime(dbConnMaxLifetime)
+	}
+	return conn
+}
+
+// prepareInsert prepares insertStmt, including the -index-json-field columns
+func (s *sqlStore) prepareInsert() {
+	columns := requestInsertColumns
+	for _, field := range jsonFieldIndexes {
+		columns += ", " + field.column()
+	}
+	placeholders := "?" + strings.Repeat(", ?", strings.Count(columns, ","))
+	insertSQL := "INSERT INTO requests(" + columns + ") VALUES(" + placeholders + ")"
+	if s.returnsID {
+		insertSQL += " RETURNING id"
+	}
+	stmt, err := s.db.Prepare(insertSQL)
+	if err != nil {
+		log.Fatalf("Failed to prepare the insert statement: %v", err)
+	}
+	s.insertStmt = stmt
+}
+
+// insert runs insertStmt, or its copy in a transaction, setting the ID of the entry
+func (s *sqlStore) insert(stmt *sql.Stmt, p *pendingInsert) error {
+	var id int64
+	if s.returnsID {
+		if err := stmt.QueryRow(p.args...).Scan(&id); err != nil {
+			return err
+		}
+	} else {
+		result, err := stmt.Exec(p.args...)
+		if err != nil {
+			return err
+		}
+		id, _ = result.LastInsertId()
+	}
+	p.entry.ID = int(id)
+	return nil
+}
+
+func (s *sqlStore) LogRequest(logEntry RequestLog) (RequestLog, error) {
+	pending := prepareLogEntry(logEntry)
+	if err := s.insert(s.insertStmt, &pending); err != nil {
+		log.Printf("Failed to insert log entry: %v", err)
+		return pending.entry, err
+	}
+	pending.stored()
+	return pending.entry, nil
+}
+
+// LogRequests stores the entries in one transaction. When the transaction
+// fails the entries are stored one at a time, so a single bad entry does not
+// lose the others.
+func (s *sqlStore) LogRequests(entries []RequestLog) []RequestLog {
+	pending := make([]pendingInsert, len(entries))
+	for i, entry := range entries {
+		pending[i] = prepareLogEntry(entry)
+	}
+
+	err := s.insertBatch(pending)
+	if err != nil {
+		log.Printf("Failed to insert batch of %d log entries, inserting them one at a time: %v", len(pending), err)
+	}
+	var stored []RequestLog
+	for i := range pending {
+		if err != nil {
+			if err := s.insert(s.insertStmt, &pending[i]); err != nil {
+				log.Printf("Failed to insert log entry: %v", err)
+				continue
+			}
+		}
+		pending[i].stored()
+		stored = append(stored, pending[i].entry)
+	}
+	return stored
+}
+
+// insertBatch inserts the entries in a single transaction
+func (s *sqlStore) insertBatch(pending []pendingInsert) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt := tx.Stmt(s.insertStmt)
+	defer stmt.Close()
+	for i := range pending {
+		if err := s.insert(stmt, &pending[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetRequests(params url.Values, page, pageSize int) ([]RequestLog, int, error) {
+	// The list and its count share the same filters
+	where, args := buildRequestFilter(params)
+	from := " FROM requests WHERE 1=1" + where
+
+	var totalCount int
+	if err := s.db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&totalCount); err != nil {
+		return nil, 0, fmt.Errorf("counting requests: %w", err)
+	}
+
+	// Ordering and pagination arguments only apply to the list query
+	query := "SELECT " + requestSummaryColumns() + from + " ORDER BY " + requestListOrder(params) + " LIMIT ? OFFSET ?"
+	pageArgs := append(append([]interface{}{}, args...), pageSize, (page-1)*pageSize)
+	rows, err := s.db.Query(query, pageArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	return scanRequestSummaries(rows), totalCount, nil
+}
+
+func (s *sqlStore) GetRequestDetail(id int) (RequestLog, error) {
+	row := s.db.QueryRow("SELECT id, timestamp, method, url, request_headers, request_body_size, is_request_body_text, status_code, response_headers, response_body_size, is_response_body_text, COALESCE(response_wire_size, 0), COALESCE(conn_id, 0), COALESCE(seq, 0), COALESCE(request_header_size, 0), COALESCE(request_headers_oversized, 0), COALESCE(request_charset, ''), COALESCE(response_charset, ''), COALESCE(client_status_code, status_code), COALESCE(listener_port, 0), COALESCE(client_bytes_sent, 0), COALESCE(body_error, ''), COALESCE(anomaly, 0), COALESCE(anomaly_reason, ''), COALESCE(handled_by, 'upstream'), COALESCE(masks_applied, ''), COALESCE(tls_sni, ''), COALESCE(request_range, ''), COALESCE(content_range, ''), COALESCE(upstream, ''), COALESCE(route, ''), COALESCE(duration_ms, -1), COALESCE(ttfb_ms, -1), COALESCE(truncated, 0), COALESCE(protocol, 'http'), COALESCE(request_body_truncated, 0), COALESCE(response_body_truncated, 0), COALESCE(client_ip, ''), COALESCE(tags, ''), COALESCE(retry_count, 0), COALESCE(request_proto, ''), COALESCE(response_proto, ''), COALESCE(dns_ms, -1), COALESCE(connect_ms, -1), COALESCE(tls_ms, -1), "+originColumn+" FROM requests WHERE id = ?", id)
+
+	var req RequestLog
+	var tags string
+	err := row.Scan(&req.ID, &req.Timestamp, &req.Method, &req.URL, &req.RequestHeaders, &req.RequestBodySize, &req.IsRequestBodyText, &req.StatusCode, &req.ResponseHeaders, &req.ResponseBodySize, &req.IsResponseBodyText, &req.ResponseWireSize, &req.ConnID, &req.Seq, &req.RequestHeaderSize, &req.RequestHeadersOversized, &req.RequestCharset, &req.ResponseCharset, &req.ClientStatusCode, &req.ListenerPort, &req.ClientBytesSent, &req.BodyError, &req.Anomaly, &req.AnomalyReason, &req.HandledBy, &req.MasksApplied, &req.TLSSNI, &req.RequestRange, &req.ContentRange, &req.Upstream, &req.Route, &req.DurationMs, &req.TTFBMs, &req.Truncated, &req.Protocol, &req.RequestBodyTruncated, &req.ResponseBodyTruncated, &req.ClientIP, &tags, &req.RetryCount, &req.RequestProto, &req.ResponseProto, &req.DNSMs, &req.ConnectMs, &req.TLSMs, &req.Origin)
+	req.Tags = parseTags(tags)
+	return req, err
+}
+
+func (s *sqlStore) DeleteRequest(id int) error {
+	result, err := s.db.Exec("DELETE FROM requests WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// migrateSchema creates the requests table and its indexes, adding the
+// columns introduced since the table was created
+func migrateSchema(conn *sql.DB, dialect schemaDialect, createTableSQL, urlIndexSQL string) {
+	if _, err := conn.Exec(createTableSQL); err != nil {
+		log.Fatalf("Failed to create table: %v", err)
+	}
+
+	// Start a transaction for schema modifications
+	tx, err := conn.Begin()
+	if err != nil {
+		log.Fatalf("Failed to begin transaction for schema migration: %v", err)
+	}
+	defer tx.Rollback() // Rollback on error or if not committed
+
+	for _, column := range []struct{ name, columnType string }{
+		{"request_body_size", "INTEGER"},
+		{"is_request_body_text", "BOOLEAN"},
+		{"response_body_size", "INTEGER"},
+		{"is_response_body_text", "BOOLEAN"},
+		{"response_wire_size", "INTEGER"},
+		{"conn_id", "INTEGER"},
+		{"seq", "INTEGER"},
+		{"request_header_size", "INTEGER"},
+		{"request_headers_oversized", "BOOLEAN"},
+		{"request_charset", "TEXT"},
+		{"response_charset", "TEXT"},
+		{"client_status_code", "INTEGER"},
+		{"listener_port", "INTEGER"},
+		{"client_bytes_sent", "INTEGER"},
+		{"body_error", "TEXT"},
+		{"anomaly", "BOOLEAN"},
+		{"anomaly_reason", "TEXT"},
+		{"handled_by", "TEXT"},
+		{"masks_applied", "TEXT"},
+		{"tls_sni", "TEXT"},
+		{"request_range", "TEXT"},
+		{"content_range", "TEXT"},
+		{"upstream_url", "TEXT"},
+		{"upstream", "TEXT"},
+		{"route", "TEXT"},
+		{"duration_ms", "INTEGER"},
+		{"send_ms", "INTEGER"},
+		{"ttfb_ms", "INTEGER"},
+		{"dns_ms", "INTEGER"},
+		{"connect_ms", "INTEGER"},
+		{"tls_ms", "INTEGER"},
+		{"truncated", "BOOLEAN"},
+		{"protocol", "TEXT"},
+		{"request_body_truncated", "BOOLEAN"},
+		{"response_body_truncated", "BOOLEAN"},
+		{"client_ip", "TEXT"},
+		{"decisions", "TEXT"}, // JSON array of handling decisions
+		{"tags", "TEXT"},      // Comma-separated labels, see parseTags
+		{"retry_count", "INTEGER"},
+		{"request_proto", "TEXT"},
+		{"response_proto", "TEXT"},
+		{"origin", "TEXT"},
+	} {
+		addColumnIfNotExists(tx, dialect, "requests", column.name, column.columnType)
+	}
+	foldSourceColumn(tx, dialect)
+	for _, field := range jsonFieldIndexes {
+		addColumnIfNotExists(tx, dialect, "requests", field.column(), "NUMERIC")
+		if _, err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_requests_%s ON requests(%s);", field.column(), field.column())); err != nil {
+			log.Fatalf("Failed to create index on %s: %v", field.column(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("Failed to commit schema migration: %v", err)
+	}
+
+	// Index the list filters; the url filter (LIKE '%x%') cannot use any
+	// index and always scans
+	for _, indexSQL := range []string{"CREATE INDEX IF NOT EXISTS idx_requests_timestamp ON requests(timestamp);", urlIndexSQL} {
+		if _, err := conn.Exec(indexSQL); err != nil {
+			log.Fatalf("Failed to create index: %v", err)
+		}
+	}
+}
+
+// addColumnIfNotExists checks if a column exists and adds it if not.
+// It assumes it's called within a transaction.
+func addColumnIfNotExists(tx *sql.Tx, dialect schemaDialect, tableName, columnName, columnType string) {
+	if dialect.columnExists(tx, tableName, columnName) {
+		return
+	}
+
+	alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", tableName, columnName, dialect.columnType(columnType))
+	if _, err := tx.Exec(alterSQL); err != nil {
+		log.Fatalf("Failed to add column %s to table %s: %v", columnName, tableName, err)
+	}
+	log.Printf("Added column %s to table %s.", columnName, tableName)
+}
+
+// foldSourceColumn moves the replay marks of the source column, which
+// origin replaced, into origin and drops it. Its other value, proxy, was
+// also stored for imported rows, so those are left to originColumn.
+func foldSourceColumn(tx *sql.Tx, dialect schemaDialect) {
+	if !dialect.columnExists(tx, "requests", "source") {
+		return
+	}
+	if _, err := tx.Exec("UPDATE requests SET origin = ? WHERE origin IS NULL AND source = ?", originReplay, originReplay); err != nil {
+		log.Fatalf("Failed to copy the source column into origin: %v", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE requests DROP COLUMN source;"); err != nil {
+		log.Fatalf("Failed to drop the source column: %v", err)
+	}
+	log.Println("Moved the source column of table requests into origin.")
+}
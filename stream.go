@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// streamCaptureBytes caps how much of a streamed response body is recorded
+var streamCaptureBytes = 1 << 20
+
+// isStreamingResponse reports whether a response is forwarded as it arrives
+// instead of being buffered: Server-Sent Events, and chunked bodies whose
+// length is unknown up front
+func isStreamingResponse(resp *http.Response) bool {
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return true
+	}
+	if resp.ContentLength >= 0 {
+		return false
+	}
+	for _, encoding := range resp.TransferEncoding {
+		if encoding == "chunked" {
+			return true
+		}
+	}
+	return false
+}
+
+// streamCapture passes a streamed response body through to the client,
+// copying its first bytes into the log entry as they are read
+type streamCapture struct {
+	io.ReadCloser
+	reqLog   *RequestLog
+	limit    int
+	encoding string // Content-Encoding of the stream, decoded in the recorded copy once it ends
+}
+
+func newStreamCapture(body io.ReadCloser, reqLog *RequestLog, limit int, encoding string) *streamCapture {
+	return &streamCapture{ReadCloser: body, reqLog: reqLog, limit: limit, encoding: encoding}
+}
+
+func (c *streamCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.reqLog.ResponseWireSize += n
+
+	// The copy never exceeds the limit, so room is never negative
+	kept := n
+	if room := c.limit - len(c.reqLog.ResponseBody); kept > room {
+		kept = room
+		c.reqLog.Truncated = true
+	}
+	c.reqLog.ResponseBody = append(c.reqLog.ResponseBody, p[:kept]...)
+
+	switch {
+	case err == io.EOF:
+		c.finish()
+	case err != nil:
+		c.reqLog.BodyError = appendBodyError(c.reqLog.BodyError, fmt.Sprintf("reading streamed response body: %v", err))
+	}
+	return n, err
+}
+
+// finish decompresses the recorded copy of a complete compressed stream; the
+// client still receives the stream as the upstream encoded it
+func (c *streamCapture) finish() {
+	if !isSupportedEncoding(c.encoding) || c.reqLog.Truncated {
+		return
+	}
+	decompressed, err := decompressBody(c.reqLog.ResponseBody, c.encoding)
+	if err != nil {
+		c.reqLog.BodyError = appendBodyError(c.reqLog.BodyError, fmt.Sprintf("decompressing streamed response body: %v", err))
+		return
+	}
+	c.reqLog.ResponseBody = decompressed
+}
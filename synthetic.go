@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// Values of the handled_by column, naming what produced a recorded response
+const (
+	handledByUpstream    = "upstream"
+	handledByBlock       = "block"
+	handledByRateLimit   = "ratelimit"
+	handledByMock        = "mock"
+	handledByMaintenance = "maintenance"
+	handledByNoRoute     = "noroute"
+	handledByImport      = "import"
+	handledByError       = "error"
+)
+
+// newRequestLog builds the log entry of an incoming request forwarded to
+// target (nil when it has none) from its request line and headers; the body
+// is added once it has been read
+func newRequestLog(r *http.Request, listenerPort int, target *url.URL, rules *ruleSet) RequestLog {
+	reqLog := RequestLog{
+		Timestamp:      time.Now(),
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		RequestHeaders: HeadersToJSON(r.Header),
+	}
+	reqLog.ConnID, reqLog.Seq = nextRequestSeq(r)
+	reqLog.ListenerPort = listenerPort
+	reqLog.ClientIP = clientIP(r)
+	reqLog.SendMs, reqLog.TTFBMs = -1, -1
+	reqLog.DNSMs, reqLog.ConnectMs, reqLog.TLSMs = -1, -1, -1
+	reqLog.RequestHeaderSize = headerSize(r.Header)
+	reqLog.RequestHeadersOversized = rules.headerSizeWarn > 0 && reqLog.RequestHeaderSize > rules.headerSizeWarn
+	if target != nil {
+		reqLog.Upstream = target.String()
+		reqLog.UpstreamURL = upstreamURL(target, r)
+		reqLog.addDecision(decisionRoute, "listener port %d forwards to %s", listenerPort, reqLog.UpstreamURL)
+	}
+	if reqLog.RequestHeadersOversized {
+		reqLog.addDecision(decisionHeaderSize, "request headers are %d bytes, over -header-size-warn %d", reqLog.RequestHeaderSize, rules.headerSizeWarn)
+	}
+	if r.TLS != nil {
+		reqLog.TLSSNI = r.TLS.ServerName
+	}
+	reqLog.RequestProto = r.Proto
+	reqLog.RequestRange = r.Header.Get("Range")
+	reqLog.pathExcluded = !rules.recordsPath(r.URL.Path)
+	return reqLog
+}
+
+// logDropReportEvery is how many dropped log entries are reported by one log
+// line after the first, so bursts do not flood the log
+const logDropReportEvery = 100
+
+// droppedLogEntries counts the entries queueRequestLog dropped, reported by /api/stats
+var droppedLogEntries atomic.Int64
+
+// queueRequestLog hands an entry to the database writers, dropping it when
+// the -log-buffer queue is full
+func queueRequestLog(reqLog RequestLog) {
+	select {
+	case requestLogChan <- reqLog:
+		// Successfully sent to channel
+	default:
+		if dropped := droppedLogEntries.Add(1); dropped == 1 || dropped%logDropReportEvery == 0 {
+			log.Printf("Request log buffer is full (-log-buffer %d), %d log entries dropped so far", cap(requestLogChan), dropped)
+		}
+	}
+}
+
+// recordSynthetic sends a response generated by dGateway itself instead of
+// the upstream (a blocked, rate-limited, mocked or maintenance response) and
+// records it tagged with what produced it. Every short-circuit path goes
+// through here so the capture reflects everything the gateway answered.
+func recordSynthetic(w http.ResponseWriter, reqLog RequestLog, status int, body string, handledBy string) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	w.WriteHeader(status)
+	written, _ := io.WriteString(w, body)
+
+	if !reqLog.recordable() {
+		return
+	}
+	reqLog.StatusCode = status
+	reqLog.ClientStatusCode = status
+	reqLog.ResponseHeaders = HeadersToJSON(w.Header())
+	reqLog.ResponseBody = []byte(body)
+	reqLog.ClientBytesSent = int64(written)
+	reqLog.HandledBy = handledBy
+	reqLog.duration = time.Since(reqLog.Timestamp)
+	queueRequestLog(reqLog)
+}
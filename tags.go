@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxTagLength is the longest tag accepted
+const maxTagLength = 64
+
+// tagsMu serializes tag edits, which read and rewrite the tags column
+var tagsMu sync.Mutex
+
+// validateTag checks that a tag is non-empty and made of letters, digits and
+// - _ . : / only, so tags can be stored comma-separated and used in URLs
+func validateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	}
+	if len(tag) > maxTagLength {
+		return fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:/", c)) {
+			return fmt.Errorf("invalid character %q in tag %q, expected letters, digits or - _ . : /", c, tag)
+		}
+	}
+	return nil
+}
+
+// parseTags splits a stored tags column into its tags. The column holds the
+// sorted tags of a request between commas, e.g. ",bug-123,reviewed,", so a
+// tag can be matched with LIKE '%,tag,%'.
+func parseTags(column string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(column, ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// formatTags builds the stored tags column, sorted and without duplicates
+func formatTags(tags []string) sql.NullString {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			unique = append(unique, tag)
+		}
+	}
+	if len(unique) == 0 {
+		return sql.NullString{}
+	}
+	sort.Strings(unique)
+	return sql.NullString{String: "," + strings.Join(unique, ",") + ",", Valid: true}
+}
+
+// tagFilter builds the WHERE condition matching requests carrying tag
+func tagFilter(tag string) (string, interface{}) {
+	return " AND tags LIKE ? ESCAPE '\\'", "%," + escapeLike(tag) + ",%"
+}
+
+// updateRequestTags applies edit to the tags of a request and stores the
+// result, returning sql.ErrNoRows when no request has the ID
+func updateRequestTags(id int, edit func([]string) []string) ([]string, error) {
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+
+	var column string
+	if err := db.QueryRow("SELECT COALESCE(tags, '') FROM requests WHERE id = ?", id).Scan(&column); err != nil {
+		return nil, err
+	}
+	stored := formatTags(edit(parseTags(column)))
+	if _, err := db.Exec("UPDATE requests SET tags = ? WHERE id = ?", stored, id); err != nil {
+		return nil, err
+	}
+	return parseTags(stored.String), nil
+}
+
+// requestTagsHandler handles POST /api/requests/{id}/tags with a body such as
+// {"tags": ["bug-123", "reviewed"]}, and DELETE /api/requests/{id}/tags/{tag}.
+// Both respond with the tags the request has afterwards.
+func requestTagsHandler(w http.ResponseWriter, r *http.Request, id int, tag string) {
+	var edit func([]string) []string
+	switch {
+	case r.Method == "POST" && tag == "":
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(body.Tags) == 0 {
+			http.Error(w, "No tags given", http.StatusBadRequest)
+			return
+		}
+		for _, added := range body.Tags {
+			if err := validateTag(added); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		edit = func(tags []string) []string { return append(tags, body.Tags...) }
+	case r.Method == "DELETE" && tag != "":
+		edit = func(tags []string) []string {
+			kept := tags[:0]
+			for _, t := range tags {
+				if t != tag {
+					kept = append(kept, t)
+				}
+			}
+			return kept
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags, err := updateRequestTags(id, edit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Request not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		log.Printf("Error updating tags of request %d: %v", id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID   int      `json:"id"`
+		Tags []string `json:"tags"`
+	}{id, tags})
+}
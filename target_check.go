@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// targetTestTimeout bounds how long a connectivity test waits for the upstream
+const targetTestTimeout = 10 * time.Second
+
+// targetTestHandler handles POST /api/target/test, sending a GET (or OPTIONS
+// with ?method=OPTIONS) to the proxy target and reporting the status code,
+// latency and any error. ?listener_port= selects the target of a -listen
+// proxy. The request bypasses the proxy handler so it is never recorded, but
+// uses the transport of the proxy, minus -retries so a single attempt is
+// measured: -upstream-timeout and proxy environment variables apply as for
+// proxied traffic.
+func targetTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	method := "GET"
+	switch r.URL.Query().Get("method") {
+	case "", "GET":
+	case "OPTIONS":
+		method = "OPTIONS"
+	default:
+		http.Error(w, "method must be GET or OPTIONS", http.StatusBadRequest)
+		return
+	}
+
+	listenerPort := 0
+	if portStr := r.URL.Query().Get("listener_port"); portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			http.Error(w, "Invalid listener_port", http.StatusBadRequest)
+			return
+		}
+		listenerPort = port
+	}
+	target := targetForPort(listenerPort).String()
+
+	// Upstream failures are reported in the result rather than as an API error
+	result := struct {
+		Target     string `json:"target"`
+		Method     string `json:"method"`
+		Reachable  bool   `json:"reachable"`
+		StatusCode int    `json:"status_code,omitempty"`
+		LatencyMs  int64  `json:"latency_ms"`
+		Error      string `json:"error,omitempty"`
+	}{Target: target, Method: method}
+
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		client := &http.Client{
+			Transport: upstreamProbeTransport,
+			Timeout:   targetTestTimeout,
+			// Report the target's own answer, not wherever it redirects to
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		result.LatencyMs = time.Since(start).Milliseconds()
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			// Drain a little of the body so the connection can be reused
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			result.Reachable = true
+			result.StatusCode = resp.StatusCode
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// upstreamTiming records the milestones of one upstream exchange. The trace
+// callbacks run on transport goroutines, hence the mutex.
+type upstreamTiming struct {
+	mu           sync.Mutex
+	start        time.Time // Request handed to the reverse proxy
+	wroteRequest time.Time // Request fully written to the upstream connection
+	firstByte    time.Time // First byte of the upstream response received
+
+	// Phases of opening a new upstream connection, zero when one was reused
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+}
+
+// newUpstreamTiming starts timing an exchange now
+func newUpstreamTiming() *upstreamTiming {
+	return &upstreamTiming{start: time.Now()}
+}
+
+// clientTrace returns the httptrace hooks feeding the timing
+func (t *upstreamTiming) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dnsDone = time.Now()
+			t.mu.Unlock()
+		},
+		// Dialing may race several addresses; time from the first attempt
+		// to the first connection established
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() || !t.connectDone.IsZero() {
+				t.connectStart, t.connectDone = time.Now(), time.Time{}
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil && t.connectDone.IsZero() {
+				t.connectDone = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.tlsDone = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wroteRequest = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+}
+
+// apply stores the measured durations in the log entry, leaving -1 for
+// milestones the exchange never reached
+func (t *upstreamTiming) apply(reqLog *RequestLog, end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reqLog.duration = end.Sub(t.start)
+	if !t.wroteRequest.IsZero() {
+		reqLog.SendMs = t.wroteRequest.Sub(t.start).Milliseconds()
+	}
+	if !t.firstByte.IsZero() {
+		reqLog.TTFBMs = t.firstByte.Sub(t.start).Milliseconds()
+	}
+	reqLog.DNSMs = phaseMs(t.dnsStart, t.dnsDone)
+	reqLog.ConnectMs = phaseMs(t.connectStart, t.connectDone)
+	reqLog.TLSMs = phaseMs(t.tlsStart, t.tlsDone)
+}
+
+// phaseMs returns the length of a connection phase in milliseconds, -1 when
+// it did not happen or did not complete
+func phaseMs(start, done time.Time) int64 {
+	if start.IsZero() || done.IsZero() {
+		return -1
+	}
+	return done.Sub(start).Milliseconds()
+}
+
+// harTimings splits the recorded durations into the HAR dns, connect, ssl,
+// send, wait and receive phases, returning the total time as well. Unknown
+// values are -1, as for rows recorded before timings were measured and for
+// connection phases of requests sent over a reused connection.
+func harTimings(req RequestLog) (int64, HARTimings) {
+	timings := HARTimings{DNS: -1, Connect: -1, SSL: -1, Send: -1, Wait: -1, Receive: -1}
+	if req.DurationMs < 0 {
+		return -1, timings
+	}
+	if req.SendMs >= 0 && req.TTFBMs >= req.SendMs && req.DurationMs >= req.TTFBMs {
+		// HAR's connect includes the TLS handshake, which ssl repeats; send
+		// keeps whatever else came before the request was written
+		send := req.SendMs
+		if req.DNSMs >= 0 && req.DNSMs <= send {
+			timings.DNS = req.DNSMs
+			send -= req.DNSMs
+		}
+		connect := req.ConnectMs
+		if req.TLSMs > 0 {
+			connect += req.TLSMs
+		}
+		if req.ConnectMs >= 0 && connect <= send {
+			timings.Connect = connect
+			timings.SSL = req.TLSMs
+			send -= connect
+		}
+		timings.Send = send
+		timings.Wait = req.TTFBMs - req.SendMs
+		timings.Receive = req.DurationMs - req.TTFBMs
+	}
+	return req.DurationMs, timings
+}
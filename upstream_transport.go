@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// upstreamTransport is the transport of every reverse proxy, set up from
+// -upstream-timeout and -retries
+var upstreamTransport http.RoundTripper = http.DefaultTransport
+
+// upstreamProbeTransport is upstreamTransport without the -retries wrapper,
+// for checks reporting whether a single attempt reaches an upstream
+var upstreamProbeTransport http.RoundTripper = http.DefaultTransport
+
+// upstreamDialer opens the connections of WebSocket upgrades, which are
+// tunnelled without upstreamTransport, with the same -upstream-timeout
+var upstreamDialer = newUpstreamDialer(0)
+
+// newUpstreamDialer returns the dialer of newUpstreamTransport. A positive
+// timeout bounds connecting; zero keeps the 30 seconds of http.DefaultTransport.
+func newUpstreamDialer(timeout time.Duration) *net.Dialer {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+}
+
+// newUpstreamTransport returns the transport used to reach upstreams. A
+// positive timeout bounds connecting, the TLS handshake and the wait for the
+// response headers, each on its own; response bodies may take longer. Zero
+// keeps http.DefaultTransport, which waits indefinitely.
+func newUpstreamTransport(timeout time.Duration) http.RoundTripper {
+	if timeout <= 0 {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newUpstreamDialer(timeout).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return transport
+}
+
+// isTimeout reports whether an upstream error is a timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// proxyErrorHandler answers requests the upstream failed with a JSON error,
+// 504 for timeouts and 502 otherwise, instead of ReverseProxy's empty 502.
+// Requests are recorded with the synthetic status, unless ModifyResponse
+// already selected the upstream response for recording by ServeHTTP.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status, message := http.StatusBadGateway, "upstream request failed"
+	switch {
+	case errors.Is(err, errResponseTooLarge):
+		message = "upstream response too large"
+	case isTimeout(err):
+		status, message = http.StatusGatewayTimeout, "upstream request timed out"
+	}
+	log.Printf("Upstream error for %s %s: %v", r.Method, r.URL, err)
+
+	body, _ := json.Marshal(struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{message, status})
+	w.Header().Set("Content-Type", "application/json")
+
+	reqLog, ok := r.Context().Value("reqLog").(*RequestLog)
+	if !ok || reqLog.record {
+		w.WriteHeader(status)
+		w.Write(append(body, '\n'))
+		return
+	}
+	reqLog.addDecision(decisionUpstream, "%s, answered %d: %v", message, status, err)
+	recordSynthetic(w, *reqLog, status, string(body)+"\n", handledByError)
+}
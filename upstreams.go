@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// weightedTarget is one backend of the -targets pool
+type weightedTarget struct {
+	URL     *url.URL
+	Weight  int
+	current int // Smooth weighted round-robin state, guarded by the pool mutex
+
+	director func(*http.Request) // Rewrites requests to URL, built once by parseUpstreamPool
+}
+
+// upstreamPool distributes requests across backends by weighted round-robin
+type upstreamPool struct {
+	mu      sync.Mutex
+	targets []*weightedTarget
+}
+
+// upstreamTargets holds the pool configured with -targets; nil when proxying to -target alone
+var upstreamTargets *upstreamPool
+
+// parseUpstreamPool parses a comma-separated "url[=weight]" list such as
+// http://a:8081=3,http://b:8081=1. The weight defaults to 1.
+func parseUpstreamPool(spec string) (*upstreamPool, error) {
+	pool := &upstreamPool{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		rawURL, weight := item, 1
+		// The weight follows the last "=", which may also appear in a query string
+		if idx := strings.LastIndex(item, "="); idx > 0 {
+			if parsed, err := strconv.Atoi(item[idx+1:]); err == nil {
+				rawURL, weight = item[:idx], parsed
+			}
+		}
+		if weight <= 0 {
+			return nil, fmt.Errorf("invalid target %q, weight must be positive", item)
+		}
+		target, err := url.Parse(rawURL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("invalid target %q, bad URL %q", item, rawURL)
+		}
+		pool.targets = append(pool.targets, &weightedTarget{
+			URL:      target,
+			Weight:   weight,
+			director: httputil.NewSingleHostReverseProxy(target).Director,
+		})
+	}
+	if len(pool.targets) == 0 {
+		return nil, fmt.Errorf("no targets in %q", spec)
+	}
+	return pool, nil
+}
+
+// next picks the backend for a request using smooth weighted round-robin, so
+// weights 3 and 1 yield a, a, b, a rather than bursts to one backend
+func (pool *upstreamPool) next() *weightedTarget {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	total := 0
+	var best *weightedTarget
+	for _, target := range pool.targets {
+		target.current += target.Weight
+		total += target.Weight
+		if best == nil || target.current > best.current {
+			best = target
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// String lists the backends in -targets form
+func (pool *upstreamPool) String() string {
+	items := make([]string, len(pool.targets))
+	for i, target := range pool.targets {
+		items[i] = fmt.Sprintf("%s=%d", target.URL, target.Weight)
+	}
+	return strings.Join(items, ",")
+}
+
+// newUpstreamProxy returns a reverse proxy forwarding each request to the
+// -targets backend ProxyHandler picked for it, stored in the request context,
+// and to fallback when none was picked. Paths and queries are joined exactly
+// as by httputil.NewSingleHostReverseProxy.
+func newUpstreamProxy(fallback *url.URL) *httputil.ReverseProxy {
+	fallbackDirector := httputil.NewSingleHostReverseProxy(fallback).Director
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			if picked, ok := req.Context().Value("upstream").(*weightedTarget); ok {
+				picked.director(req)
+				return
+			}
+			fallbackDirector(req)
+		},
+	}
+}
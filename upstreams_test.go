@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestUpstreamProxyDirector checks that requests go to the picked -targets
+// backend, with its base path joined, and to the fallback otherwise
+func TestUpstreamProxyDirector(t *testing.T) {
+	pool, err := parseUpstreamPool("http://a:8081/base=3,http://b:8082=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := newUpstreamProxy(pool.targets[1].URL)
+
+	var picks []string
+	for i := 0; i < 4; i++ {
+		picked := pool.next()
+		req := httptest.NewRequest("GET", "/users?id=1", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "upstream", picked))
+		proxy.Director(req)
+		picks = append(picks, req.URL.String())
+	}
+	want := []string{"http://a:8081/base/users?id=1", "http://a:8081/base/users?id=1", "http://b:8082/users?id=1", "http://a:8081/base/users?id=1"}
+	for i := range want {
+		if picks[i] != want[i] {
+			t.Errorf("request %d went to %s, want %s", i, picks[i], want[i])
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	proxy.Director(req)
+	if got := req.URL.String(); got != "http://b:8082/health" {
+		t.Errorf("request without a picked backend went to %s, want the fallback http://b:8082/health", got)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// requestErrorCondition is an SQL expression matching rows whose stored
+// headers are not JSON objects or that recorded a body error. CASE is used
+// because json_type fails on invalid JSON and SQLite does not short-circuit OR.
+const requestErrorCondition = `(CASE
+		WHEN COALESCE(body_error, '') != '' THEN 1
+		WHEN NOT json_valid(COALESCE(request_headers, '')) OR NOT json_valid(COALESCE(response_headers, '')) THEN 1
+		WHEN json_type(request_headers) != 'object' OR json_type(response_headers) != 'object' THEN 1
+		ELSE 0 END = 1)`
+
+// appendBodyError adds a body error message to any already recorded
+func appendBodyError(existing, message string) string {
+	if existing == "" {
+		return message
+	}
+	return existing + "; " + message
+}
+
+// RequestValidationIssue describes why a stored request is considered corrupt
+type RequestValidationIssue struct {
+	ID       int      `json:"id"`
+	URL      string   `json:"url"`
+	Problems []string `json:"problems"`
+}
+
+// validateStoredRequest checks that a row's headers parse the way exports
+// expect them to and reports any recorded body errors
+func validateStoredRequest(requestHeaders, responseHeaders, bodyError string) []string {
+	var problems []string
+	var headers http.Header
+	if err := json.Unmarshal([]byte(requestHeaders), &headers); err != nil {
+		problems = append(problems, fmt.Sprintf("malformed request headers: %v", err))
+	}
+	if err := json.Unmarshal([]byte(responseHeaders), &headers); err != nil {
+		problems = append(problems, fmt.Sprintf("malformed response headers: %v", err))
+	}
+	if bodyError != "" {
+		problems = append(problems, "body error: "+bodyError)
+	}
+	return problems
+}
+
+// validateRequestsHandler handles GET /api/requests/validate, scanning every
+// stored request for malformed headers or recorded body errors
+func validateRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.Query("SELECT id, url, COALESCE(request_headers, ''), COALESCE(response_headers, ''), COALESCE(body_error, '') FROM requests ORDER BY id")
+	if err != nil {
+		http.Error(w, "Failed to fetch requests", http.StatusInternalServerError)
+		log.Printf("Error fetching requests for validation: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	response := struct {
+		Checked int                      `json:"checked"`
+		Invalid []RequestValidationIssue `json:"invalid"`
+		Count   int                      `json:"count"`
+	}{
+		Invalid: []RequestValidationIssue{},
+	}
+	for rows.Next() {
+		var id int
+		var url, requestHeaders, responseHeaders, bodyError string
+		if err := rows.Scan(&id, &url, &requestHeaders, &responseHeaders, &bodyError); err != nil {
+			log.Printf("Error scanning request: %v", err)
+			continue
+		}
+		response.Checked++
+		if problems := validateStoredRequest(requestHeaders, responseHeaders, bodyError); len(problems) > 0 {
+			response.Invalid = append(response.Invalid, RequestValidationIssue{ID: id, URL: url, Problems: problems})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating requests for validation: %v", err)
+	}
+	response.Count = len(response.Invalid)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
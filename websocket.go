@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Values of the protocol column
+const (
+	protocolHTTP      = "http"
+	protocolWebSocket = "websocket" // One WebSocket data frame
+)
+
+// WebSocket frame opcodes (RFC 6455 section 5.2)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+)
+
+// isWebSocketUpgrade reports whether the request asks to switch to the WebSocket protocol
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerHasToken reports whether a comma-separated header contains token
+func headerHasToken(headers http.Header, name, token string) bool {
+	for _, value := range headers.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialUpstream opens a connection to the target, using TLS for https targets.
+// The upstreamDialer timeout covers connecting and the TLS handshake together.
+func dialUpstream(ctx context.Context, target *url.URL) (net.Conn, error) {
+	address := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(target.Hostname(), port)
+	}
+	if target.Scheme == "https" {
+		tlsDialer := &tls.Dialer{NetDialer: upstreamDialer, Config: &tls.Config{ServerName: target.Hostname()}}
+		return tlsDialer.DialContext(ctx, "tcp", address)
+	}
+	return upstreamDialer.DialContext(ctx, "tcp", address)
+}
+
+// proxyWebSocket forwards a WebSocket upgrade request to the target and, once
+// the upstream switches protocols, tunnels bytes in both directions until
+// either side closes or the request is cancelled. The handshake is recorded
+// like any request and every text or binary frame as an entry of its own
+// while recording is on.
+func proxyWebSocket(ctx context.Context, w http.ResponseWriter, r *http.Request, target *url.URL, reqLog *RequestLog) {
+	upstreamConn, err := dialUpstream(ctx, target)
+	if err != nil {
+		log.Printf("Error connecting to WebSocket upstream %s: %v", target.Host, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer upstreamConn.Close()
+
+	outreq := r.Clone(ctx)
+	outreq.URL, _ = url.Parse(reqLog.UpstreamURL)
+	outreq.RequestURI = ""
+	if authTrustedHeader != "" {
+		outreq.Header.Del(authTrustedHeader)
+	}
+	if clientIP := remoteIP(r); clientIP != "" {
+		if prior := outreq.Header.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if err := outreq.Write(upstreamConn); err != nil {
+		log.Printf("Error sending WebSocket handshake upstream: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	resp, err := http.ReadResponse(upstreamReader, outreq)
+	if err != nil {
+		log.Printf("Error reading WebSocket handshake response: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	reqLog.StatusCode = resp.StatusCode
+	reqLog.ClientStatusCode = resp.StatusCode
+	currentRules().applyResponseHeaderRules(resp, reqLog)
+	reqLog.ResponseHeaders = HeadersToJSON(resp.Header)
+
+	// The upstream refused the upgrade: relay its answer like any response
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			reqLog.BodyError = appendBodyError(reqLog.BodyError, fmt.Sprintf("reading response body: %v", err))
+		}
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		written, _ := w.Write(body)
+		reqLog.ResponseBody = body
+		reqLog.ClientBytesSent = int64(written)
+		reqLog.duration = time.Since(reqLog.Timestamp)
+		markForRecording(reqLog, resp)
+		if reqLog.record {
+			queueRequestLog(*reqLog)
+		}
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Printf("Error proxying WebSocket: connection cannot be hijacked")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Error hijacking WebSocket connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		log.Printf("Error completing WebSocket handshake: %v", err)
+		return
+	}
+
+	reqLog.duration = time.Since(reqLog.Timestamp)
+	reqLog.addDecision(decisionBody, "upgraded to WebSocket, frames tunnelled and recorded as separate entries")
+	markForRecording(reqLog, resp)
+	if reqLog.record {
+		queueRequestLog(*reqLog)
+	}
+
+	// Frames are recorded while recording is on, unless the handshake response
+	// was not selected by the -record-if-header rules
+	selected, _ := shouldRecordResponse(resp)
+	frameLog := *reqLog
+	frameLog.Protocol = protocolWebSocket
+	fromClient := &wsFrameRecorder{template: frameLog, selected: selected, fromClient: true}
+	fromUpstream := &wsFrameRecorder{template: frameLog, selected: selected}
+
+	// Bytes already buffered on either side are forwarded before the rest
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(io.MultiWriter(upstreamConn, fromClient), clientBuf.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(io.MultiWriter(clientConn, fromUpstream), upstreamReader)
+		done <- struct{}{}
+	}()
+
+	// Closing both connections ends whichever copy is still running
+	finished := 0
+	select {
+	case <-done:
+		finished++
+	case <-ctx.Done():
+	}
+	clientConn.Close()
+	upstreamConn.Close()
+	for ; finished < 2; finished++ {
+		<-done
+	}
+}
+
+// wsFrameRecorder parses the WebSocket frames flowing in one direction of a
+// tunnel as they are written to it and records each text or binary frame.
+// Payloads beyond -stream-capture-bytes are counted but not kept.
+type wsFrameRecorder struct {
+	template   RequestLog // The handshake entry the frames belong to
+	selected   bool       // The handshake passed the -record-if-header rules
+	fromClient bool
+
+	header        []byte // Header bytes of the frame being read
+	inPayload     bool
+	opcode        byte
+	messageOpcode byte // Opcode of the message continued by continuation frames
+	masked        bool
+	mask          [4]byte
+	remaining     uint64 // Payload bytes of the frame still to come
+	offset        uint64 // Payload bytes of the frame seen so far
+	payload       []byte
+	truncated     bool
+}
+
+// Write consumes tunnelled bytes; it never fails so the tunnel is not disturbed
+func (rec *wsFrameRecorder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if !rec.inPayload {
+			rec.header = append(rec.header, p[0])
+			p = p[1:]
+			if len(rec.header) == wsHeaderSize(rec.header) {
+				rec.startFrame()
+			}
+			continue
+		}
+
+		chunk := p
+		if uint64(len(chunk)) > rec.remaining {
+			chunk = chunk[:rec.remaining]
+		}
+		p = p[len(chunk):]
+
+		kept := chunk
+		if room := streamCaptureBytes - len(rec.payload); len(kept) > room {
+			kept = kept[:room]
+			rec.truncated = true
+		}
+		start := len(rec.payload)
+		rec.payload = append(rec.payload, kept...)
+		if rec.masked {
+			for i := start; i < len(rec.payload); i++ {
+				rec.payload[i] ^= rec.mask[(rec.offset+uint64(i-start))%4]
+			}
+		}
+		rec.offset += uint64(len(chunk))
+		rec.remaining -= uint64(len(chunk))
+		if rec.remaining == 0 {
+			rec.endFrame()
+		}
+	}
+	return n, nil
+}
+
+// wsHeaderSize returns the length of a frame header given its first bytes,
+// which is not final until the two leading bytes are known
+func wsHeaderSize(header []byte) int {
+	if len(header) < 2 {
+		return 2
+	}
+	size := 2
+	switch header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+// startFrame decodes a complete frame header
+func (rec *wsFrameRecorder) startFrame() {
+	header := rec.header
+	rec.opcode = header[0] & 0x0f
+	rec.masked = header[1]&0x80 != 0
+	rest := header[2:]
+	switch length := header[1] & 0x7f; length {
+	case 126:
+		rec.remaining = uint64(binary.BigEndian.Uint16(rest))
+		rest = rest[2:]
+	case 127:
+		rec.remaining = binary.BigEndian.Uint64(rest)
+		rest = rest[8:]
+	default:
+		rec.remaining = uint64(length)
+	}
+	if rec.masked {
+		copy(rec.mask[:], rest)
+	}
+	rec.inPayload = true
+	rec.offset = 0
+	if rec.remaining == 0 {
+		rec.endFrame()
+	}
+}
+
+// endFrame records a finished data frame and resets for the next header
+func (rec *wsFrameRecorder) endFrame() {
+	opcode := rec.opcode
+	switch opcode {
+	case wsOpText, wsOpBinary:
+		rec.messageOpcode = opcode
+		rec.record(opcode)
+	case wsOpContinuation:
+		rec.record(rec.messageOpcode)
+	}
+	rec.header = rec.header[:0]
+	rec.inPayload = false
+	rec.payload = nil
+	rec.truncated = false
+}
+
+// record queues a frame as an entry sharing the handshake's request line and
+// headers, with the payload as the request body for frames sent by the client
+// and as the response body for frames sent by the upstream
+func (rec *wsFrameRecorder) record(opcode byte) {
+	if !rec.selected || !rec.template.recordable() {
+		return
+	}
+	entry := rec.template
+	entry.Timestamp = time.Now()
+	entry.RequestBody = nil
+	entry.ResponseBody = nil
+	entry.Decisions = nil
+	entry.Truncated = rec.truncated
+	entry.duration = 0
+
+	kind := "binary"
+	if opcode == wsOpText {
+		kind = "text"
+	}
+	if rec.fromClient {
+		entry.RequestBody = rec.payload
+		entry.addDecision(decisionBody, "WebSocket %s frame sent by the client", kind)
+	} else {
+		entry.ResponseBody = rec.payload
+		entry.addDecision(decisionBody, "WebSocket %s frame sent by the upstream", kind)
+	}
+	queueRequestLog(entry)
+}